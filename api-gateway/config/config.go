@@ -0,0 +1,189 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/shared/envconfig"
+)
+
+// Config holds the settings of the API gateway
+type Config struct {
+	Port     string `env:"API_GATEWAY_PORT" flag:"port" default:"3000"`
+	LogLevel string `env:"LOG_LEVEL" flag:"log-level" default:"info"`
+
+	Inventory Upstream
+	Billing   BillingUpstream
+	RabbitMQ  RabbitMQ
+	Tracking  Tracking
+	Events    Events
+	GraphQL   GraphQL
+	OpenAPI   OpenAPI
+	Tracing   Tracing
+}
+
+// Upstream locates the inventory service. With the grpc transport, the
+// /api/movies routes are transcoded to its gRPC API on GRPCPort instead of
+// being proxied.
+type Upstream struct {
+	Host string `env:"INVENTORY_SERVICE_HOST" flag:"inventory-host" required:"true"`
+	Port string `env:"INVENTORY_SERVICE_PORT" flag:"inventory-port" required:"true"`
+
+	GRPCPort  string `env:"INVENTORY_GRPC_PORT" flag:"inventory-grpc-port" default:"9090"`
+	Transport string `env:"INVENTORY_TRANSPORT" flag:"inventory-transport" default:"http" oneof:"http,grpc"`
+}
+
+// BillingUpstream locates the billing service
+type BillingUpstream struct {
+	Host string `env:"BILLING_SERVICE_HOST" flag:"billing-host" required:"true"`
+	Port string `env:"BILLING_SERVICE_PORT" flag:"billing-port" required:"true"`
+}
+
+// RabbitMQ holds the broker connection settings
+type RabbitMQ struct {
+	Host     string `env:"RABBITMQ_HOST" flag:"rabbitmq-host" required:"true"`
+	Port     string `env:"RABBITMQ_PORT" flag:"rabbitmq-port" default:"5672"`
+	User     string `env:"RABBITMQ_USER" flag:"rabbitmq-user" required:"true"`
+	Password string `env:"RABBITMQ_PASSWORD" required:"true" secret:"true"`
+	VHost    string `env:"RABBITMQ_VHOST" flag:"rabbitmq-vhost" default:"/"`
+
+	// Exchange is the topic exchange billing messages are published to, with
+	// their type as routing key; consumers bind their own queues to it
+	Exchange string `env:"RABBITMQ_BILLING_EXCHANGE" flag:"rabbitmq-billing-exchange" default:"billing_messages"`
+	// EventsExchange is where the billing service publishes what became of
+	// each billing message
+	EventsExchange string `env:"RABBITMQ_EVENTS_EXCHANGE" flag:"rabbitmq-events-exchange" default:"billing_events"`
+	// InventoryExchange is where the inventory service announces catalog
+	// changes
+	InventoryExchange string `env:"RABBITMQ_INVENTORY_EXCHANGE" flag:"rabbitmq-inventory-exchange" default:"inventory_events"`
+
+	// MessageVersion is the version of the billing messages published. Keep
+	// it at the previous version until every consumer supports the new one.
+	MessageVersion int `env:"BILLING_MESSAGE_VERSION" flag:"billing-message-version" default:"1" oneof:"0,1"`
+	// MessageEncoding is the wire encoding of billing messages; Avro needs
+	// version 1 or later
+	MessageEncoding string `env:"BILLING_MESSAGE_ENCODING" flag:"billing-message-encoding" default:"json" oneof:"json,avro"`
+
+	// Messages of PremiumUsers are published with PremiumPriority, which
+	// only matters when the billing queue has RABBITMQ_QUEUE_MAX_PRIORITY set
+	PremiumUsers    []string `env:"BILLING_PREMIUM_USERS" flag:"billing-premium-users"`
+	PremiumPriority int      `env:"BILLING_PREMIUM_PRIORITY" flag:"billing-premium-priority" default:"5"`
+}
+
+// Tracking bounds the billing requests remembered for status lookups: each
+// is kept for TTL, and the oldest are forgotten past MaxEntries
+type Tracking struct {
+	TTL        time.Duration `env:"BILLING_TRACKING_TTL" flag:"billing-tracking-ttl" default:"24h"`
+	MaxEntries int           `env:"BILLING_TRACKING_MAX_ENTRIES" flag:"billing-tracking-max-entries" default:"100000"`
+}
+
+// Events configures the stream of GET /api/events. BufferSize events are
+// kept for clients resuming with Last-Event-ID. APIKeys lists key=subject
+// entries: the subject is the user whose order events the key may see, or *
+// for every user; callers without a key only see catalog events.
+type Events struct {
+	BufferSize int           `env:"EVENTS_BUFFER_SIZE" flag:"events-buffer-size" default:"1000"`
+	Heartbeat  time.Duration `env:"EVENTS_HEARTBEAT" flag:"events-heartbeat" default:"15s"`
+	APIKeys    []string      `env:"EVENTS_API_KEYS" secret:"true"`
+}
+
+// GraphQL enables /api/graphql, which serves movies and orders from the
+// inventory and billing services in a single request
+type GraphQL struct {
+	Enabled bool `env:"GRAPHQL_ENABLED" flag:"graphql" default:"false"`
+}
+
+// OpenAPI selects how requests are checked against the OpenAPI document of
+// the gateway: not at all, requests only, or in debug mode requests and
+// responses, logging the responses that drift from the document
+type OpenAPI struct {
+	Validation string `env:"OPENAPI_VALIDATION" flag:"openapi-validation" default:"off" oneof:"off,requests,debug"`
+}
+
+func (e Events) validate() error {
+	if e.BufferSize < 1 {
+		return fmt.Errorf("EVENTS_BUFFER_SIZE must be at least 1, got %d", e.BufferSize)
+	}
+	if e.Heartbeat <= 0 {
+		return fmt.Errorf("EVENTS_HEARTBEAT must be positive, got %s", e.Heartbeat)
+	}
+	return nil
+}
+
+func (t Tracking) validate() error {
+	if t.TTL <= 0 {
+		return fmt.Errorf("BILLING_TRACKING_TTL must be positive, got %s", t.TTL)
+	}
+	if t.MaxEntries < 1 {
+		return fmt.Errorf("BILLING_TRACKING_MAX_ENTRIES must be at least 1, got %d", t.MaxEntries)
+	}
+	return nil
+}
+
+// Tracing selects the span exporter; the OTLP exporter itself also reads the
+// standard OTEL_EXPORTER_OTLP_* variables
+type Tracing struct {
+	Exporter     string `env:"OTEL_TRACES_EXPORTER" flag:"traces-exporter"`
+	File         string `env:"OTEL_TRACES_FILE" default:"traces.json"`
+	OTLPEndpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+}
+
+// Load reads the configuration from defaults, the optional file given by
+// -config or CONFIG_FILE, the environment and the command-line flags, and
+// validates it
+func Load() (Config, error) {
+	var cfg Config
+	if err := envconfig.Load(&cfg, os.Args[1:]); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := errors.Join(cfg.Tracking.validate(), cfg.Events.validate()); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// Redacted returns the configuration keyed by variable name with secrets masked
+func (c Config) Redacted() map[string]string {
+	return envconfig.Redact(c)
+}
+
+// String formats the configuration with secrets masked
+func (c Config) String() string {
+	return envconfig.Dump(c)
+}
+
+// URL returns the base URL of the upstream
+func (u Upstream) URL() string {
+	return fmt.Sprintf("http://%s:%s", u.Host, u.Port)
+}
+
+// GRPCTarget returns the address of the gRPC API of the upstream
+func (u Upstream) GRPCTarget() string {
+	return net.JoinHostPort(u.Host, u.GRPCPort)
+}
+
+// URL returns the base URL of the upstream
+func (u BillingUpstream) URL() string {
+	return fmt.Sprintf("http://%s:%s", u.Host, u.Port)
+}
+
+// URL returns the AMQP connection URL
+func (r RabbitMQ) URL() string {
+	return fmt.Sprintf("amqp://%s@%s:%s/%s",
+		url.UserPassword(r.User, r.Password).String(),
+		r.Host, r.Port,
+		url.PathEscape(trimSlash(r.VHost)))
+}
+
+// trimSlash maps the default vhost "/" to the empty path segment expected by
+// the AMQP URI scheme
+func trimSlash(vhost string) string {
+	if vhost == "/" {
+		return ""
+	}
+	return vhost
+}
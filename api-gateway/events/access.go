@@ -0,0 +1,72 @@
+package events
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+)
+
+// AllUsers is the subject of a key allowed to see the orders of every user
+const AllUsers = "*"
+
+// Access is what a caller of the stream may see. Everyone sees the catalog;
+// order events need an API key.
+type Access struct {
+	Orders bool
+	// UserID is the only user whose orders the caller sees, empty for all
+	UserID string
+}
+
+// Topics lists the topics the access allows
+func (a Access) Topics() []string {
+	if a.Orders {
+		return Topics
+	}
+	return []string{TopicMovies}
+}
+
+// Allows reports whether the access allows a topic
+func (a Access) Allows(topic string) bool {
+	return topic == TopicMovies || (topic == TopicOrders && a.Orders)
+}
+
+type apiKey struct {
+	key     []byte
+	subject string
+}
+
+// Keys holds the API keys of the stream
+type Keys []apiKey
+
+// ParseKeys reads key=subject entries, the subject being a user ID or *
+func ParseKeys(entries []string) (Keys, error) {
+	keys := make(Keys, 0, len(entries))
+	for _, entry := range entries {
+		key, subject, ok := strings.Cut(entry, "=")
+		key, subject = strings.TrimSpace(key), strings.TrimSpace(subject)
+		if !ok || key == "" || subject == "" {
+			// Never echo the entry: it holds a secret
+			return nil, fmt.Errorf("EVENTS_API_KEYS: entry %d is not key=subject", len(keys)+1)
+		}
+		keys = append(keys, apiKey{key: []byte(key), subject: subject})
+	}
+	return keys, nil
+}
+
+// Access returns what a key gives access to. Without a key the caller is
+// anonymous; ok is false for an unknown key.
+func (k Keys) Access(key string) (access Access, ok bool) {
+	if key == "" {
+		return Access{}, true
+	}
+	for _, ak := range k {
+		if subtle.ConstantTimeCompare(ak.key, []byte(key)) == 1 {
+			access = Access{Orders: true}
+			if ak.subject != AllUsers {
+				access.UserID = ak.subject
+			}
+			return access, true
+		}
+	}
+	return Access{}, false
+}
@@ -0,0 +1,48 @@
+package events
+
+import "testing"
+
+func TestKeysAccess(t *testing.T) {
+	keys, err := ParseKeys([]string{"admin-key=*", " user-key = u1 "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key  string
+		want Access
+		ok   bool
+	}{
+		{"", Access{}, true},
+		{"admin-key", Access{Orders: true}, true},
+		{"user-key", Access{Orders: true, UserID: "u1"}, true},
+		{"unknown", Access{}, false},
+		{"user-ke", Access{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := keys.Access(tt.key)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Access(%q) = %+v, %v; want %+v, %v", tt.key, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseKeysInvalid(t *testing.T) {
+	for _, entries := range [][]string{{"key"}, {"=u1"}, {"key="}} {
+		if _, err := ParseKeys(entries); err == nil {
+			t.Errorf("ParseKeys(%q) succeeded", entries)
+		}
+	}
+}
+
+func TestAccessTopics(t *testing.T) {
+	anonymous := Access{}
+	if anonymous.Allows(TopicOrders) || !anonymous.Allows(TopicMovies) {
+		t.Error("anonymous callers see the catalog only")
+	}
+	if got := anonymous.Topics(); len(got) != 1 || got[0] != TopicMovies {
+		t.Errorf("anonymous topics %v", got)
+	}
+	if !(Access{Orders: true, UserID: "u1"}).Allows(TopicOrders) {
+		t.Error("a user key must allow the orders topic")
+	}
+}
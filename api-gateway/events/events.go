@@ -0,0 +1,218 @@
+// Package events fans out the order and catalog changes to the clients of
+// GET /api/events. The latest events are kept in a ring buffer so that a
+// client reconnecting with the ID of the last event it received gets the
+// ones it missed.
+//
+// Event IDs are only meaningful to the gateway instance that issued them:
+// they start with an epoch drawn at startup, and a client resuming from an
+// ID of another instance, or of an event no longer buffered, is told to
+// resynchronize instead.
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Topics a client can subscribe to
+const (
+	TopicMovies = "movies"
+	TopicOrders = "orders"
+)
+
+// Topics lists every topic
+var Topics = []string{TopicMovies, TopicOrders}
+
+// subscriberBuffer is the number of events a subscriber may lag behind
+// before it is cut off
+const subscriberBuffer = 64
+
+// Event is a change streamed to the clients. Type is the routing key it was
+// published with, e.g. billing.order.cancelled or inventory.movie.updated,
+// and Data the event published by the service.
+type Event struct {
+	ID    string          `json:"id,omitempty" doc:"Absent from resync events"`
+	Topic string          `json:"topic,omitempty" enum:"movies,orders"`
+	Type  string          `json:"type" doc:"Routing key of the change, or resync when events were missed" example:"inventory.movie.updated"`
+	Data  json.RawMessage `json:"data" doc:"Event published by the service"`
+
+	// userID owns an order event
+	userID string
+	seq    uint64
+}
+
+// Filter selects the events a subscriber receives
+type Filter struct {
+	Topics map[string]bool
+	// UserID restricts order events to those of a user; empty means every
+	// user
+	UserID string
+}
+
+// Match reports whether the filter lets an event through
+func (f Filter) Match(e Event) bool {
+	if !f.Topics[e.Topic] {
+		return false
+	}
+	return e.Topic != TopicOrders || f.UserID == "" || e.userID == f.UserID
+}
+
+// Hub keeps the latest events and fans them out to the subscribers
+type Hub struct {
+	mu    sync.Mutex
+	epoch string
+	seq   uint64
+	// ring holds the latest events, the oldest at start
+	ring  []Event
+	start int
+	size  int
+	subs  map[*Subscription]struct{}
+}
+
+// NewHub returns a hub keeping the latest size events
+func NewHub(size int) *Hub {
+	return &Hub{
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		ring:  make([]Event, size),
+		subs:  make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish records an event and sends it to the matching subscribers. A
+// subscriber too slow to keep up is cut off; it can resume from the buffer.
+// userID is the owner of an order event.
+func (h *Hub) Publish(topic, typ, userID string, data []byte) {
+	// Events are written on a single SSE data line
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	e := Event{
+		ID:     h.epoch + "-" + strconv.FormatUint(h.seq, 10),
+		Topic:  topic,
+		Type:   typ,
+		Data:   compact.Bytes(),
+		userID: userID,
+		seq:    h.seq,
+	}
+	if h.size < len(h.ring) {
+		h.ring[(h.start+h.size)%len(h.ring)] = e
+		h.size++
+	} else {
+		h.ring[h.start] = e
+		h.start = (h.start + 1) % len(h.ring)
+	}
+
+	for s := range h.subs {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			h.drop(s)
+		}
+	}
+}
+
+// Subscribe registers a subscriber. When lastID is set, it also returns the
+// buffered events published after it; resumed is false when those cannot
+// be known, and the client should reload what it displays.
+func (h *Hub) Subscribe(f Filter, lastID string) (sub *Subscription, backlog []Event, resumed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	resumed = true
+	if lastID != "" {
+		seq, ok := h.parseID(lastID)
+		oldest := h.seq - uint64(h.size) + 1
+		if !ok || seq+1 < oldest {
+			resumed = false
+		} else {
+			for i := range h.size {
+				e := h.ring[(h.start+i)%len(h.ring)]
+				if e.seq > seq && f.Match(e) {
+					backlog = append(backlog, e)
+				}
+			}
+		}
+	}
+
+	sub = &Subscription{hub: h, c: make(chan Event, subscriberBuffer), filter: f}
+	sub.C = sub.c
+	h.subs[sub] = struct{}{}
+	return sub, backlog, resumed
+}
+
+// parseID returns the sequence number of an ID issued by this hub
+func (h *Hub) parseID(id string) (uint64, bool) {
+	epoch, seq, ok := strings.Cut(id, "-")
+	if !ok || epoch != h.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil || n > h.seq {
+		return 0, false
+	}
+	return n, true
+}
+
+// drop removes a subscriber and closes its channel; h.mu must be held
+func (h *Hub) drop(s *Subscription) {
+	if _, ok := h.subs[s]; !ok {
+		return
+	}
+	delete(h.subs, s)
+	if !s.closed {
+		s.dropped = true
+	}
+	close(s.c)
+}
+
+// Close ends every subscription, e.g. so that open streams do not hold up
+// the shutdown of the server
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		s.closed = true
+		h.drop(s)
+	}
+}
+
+// Subscription receives the events matching its filter on C, which is
+// closed when the subscriber is cut off or the hub closed
+type Subscription struct {
+	C <-chan Event
+
+	hub    *Hub
+	c      chan Event
+	filter Filter
+	// dropped is set when the hub cut the subscriber off for being too slow
+	dropped bool
+	closed  bool
+}
+
+// Dropped reports whether the subscriber was cut off for being too slow
+func (s *Subscription) Dropped() bool {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.dropped
+}
+
+// Close unregisters the subscriber
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.closed = true
+	s.hub.drop(s)
+}
@@ -0,0 +1,169 @@
+package events
+
+import (
+	"strconv"
+	"testing"
+)
+
+var allTopics = Filter{Topics: map[string]bool{TopicMovies: true, TopicOrders: true}}
+
+// publishMovies publishes n catalog events and returns their IDs
+func publishMovies(h *Hub, n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		h.Publish(TopicMovies, "inventory.movie.updated", "", []byte(`{"n": `+strconv.Itoa(i)+`}`))
+		ids[i] = h.epoch + "-" + strconv.FormatUint(h.seq, 10)
+	}
+	return ids
+}
+
+func ids(events []Event) []string {
+	out := make([]string, len(events))
+	for i, e := range events {
+		out[i] = e.ID
+	}
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSubscribeResume(t *testing.T) {
+	h := NewHub(4)
+	published := publishMovies(h, 10) // the ring wrapped and holds 7 to 10
+
+	tests := []struct {
+		name    string
+		lastID  string
+		resumed bool
+		backlog []string
+	}{
+		{"no last ID", "", true, nil},
+		{"latest event", published[9], true, nil},
+		{"within the buffer", published[7], true, published[8:]},
+		{"just before the oldest buffered", published[5], true, published[6:]},
+		{"older than the buffer", published[4], false, nil},
+		{"other epoch", "0-9", false, nil},
+		{"not issued yet", h.epoch + "-11", false, nil},
+		{"malformed", "yesterday", false, nil},
+		{"malformed sequence", h.epoch + "-x", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, backlog, resumed := h.Subscribe(allTopics, tt.lastID)
+			defer sub.Close()
+			if resumed != tt.resumed {
+				t.Errorf("resumed %v, want %v", resumed, tt.resumed)
+			}
+			if got := ids(backlog); !equal(got, tt.backlog) {
+				t.Errorf("backlog %v, want %v", got, tt.backlog)
+			}
+		})
+	}
+}
+
+func TestSubscribeBeforeWrap(t *testing.T) {
+	h := NewHub(4)
+	published := publishMovies(h, 3)
+
+	_, backlog, resumed := h.Subscribe(allTopics, published[0])
+	if !resumed || !equal(ids(backlog), published[1:]) {
+		t.Errorf("resumed %v backlog %v, want true %v", resumed, ids(backlog), published[1:])
+	}
+}
+
+func TestOrdersOfOtherUsers(t *testing.T) {
+	h := NewHub(8)
+	h.Publish(TopicOrders, "billing.order.created", "u2", []byte(`{"user_id":"u2"}`))
+	first := h.epoch + "-1"
+	h.Publish(TopicOrders, "billing.order.created", "u1", []byte(`{"user_id":"u1"}`))
+	h.Publish(TopicOrders, "billing.order.paid", "u2", []byte(`{"user_id":"u2"}`))
+	h.Publish(TopicMovies, "inventory.movie.created", "", []byte(`{}`))
+
+	f := Filter{Topics: map[string]bool{TopicMovies: true, TopicOrders: true}, UserID: "u1"}
+	sub, backlog, _ := h.Subscribe(f, first)
+	defer sub.Close()
+	for _, e := range backlog {
+		if e.Topic == TopicOrders && e.userID != "u1" {
+			t.Errorf("backlog leaks %s of %s", e.Type, e.userID)
+		}
+	}
+	if len(backlog) != 2 {
+		t.Errorf("backlog %v, want the order of u1 and the movie", ids(backlog))
+	}
+
+	h.Publish(TopicOrders, "billing.order.cancelled", "u2", []byte(`{}`))
+	h.Publish(TopicOrders, "billing.order.cancelled", "u1", []byte(`{}`))
+	if e := <-sub.C; e.userID != "u1" {
+		t.Errorf("live event %s of %s leaked", e.Type, e.userID)
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	order := Event{Topic: TopicOrders, userID: "u1"}
+	movie := Event{Topic: TopicMovies}
+	tests := []struct {
+		name   string
+		filter Filter
+		event  Event
+		want   bool
+	}{
+		{"own order", Filter{Topics: map[string]bool{TopicOrders: true}, UserID: "u1"}, order, true},
+		{"order of another user", Filter{Topics: map[string]bool{TopicOrders: true}, UserID: "u2"}, order, false},
+		{"every user", Filter{Topics: map[string]bool{TopicOrders: true}}, order, true},
+		{"topic not requested", Filter{Topics: map[string]bool{TopicMovies: true}}, order, false},
+		{"movie for a user key", Filter{Topics: map[string]bool{TopicMovies: true}, UserID: "u2"}, movie, true},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Match(tt.event); got != tt.want {
+			t.Errorf("%s: Match = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSlowSubscriberDropped(t *testing.T) {
+	h := NewHub(4)
+	sub, _, _ := h.Subscribe(allTopics, "")
+	publishMovies(h, subscriberBuffer+1)
+
+	n := 0
+	for range sub.C {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("received %d events before the cut, want %d", n, subscriberBuffer)
+	}
+	if !sub.Dropped() {
+		t.Error("slow subscriber not reported as dropped")
+	}
+}
+
+func TestCloseIsNotADrop(t *testing.T) {
+	h := NewHub(4)
+	sub, _, _ := h.Subscribe(allTopics, "")
+	h.Close()
+	if _, ok := <-sub.C; ok {
+		t.Fatal("channel still open after Close")
+	}
+	if sub.Dropped() {
+		t.Error("closed subscriber reported as dropped")
+	}
+	sub.Close() // closing again is harmless
+}
+
+func TestPublishInvalidJSON(t *testing.T) {
+	h := NewHub(4)
+	h.Publish(TopicMovies, "inventory.movie.updated", "", []byte(`{`))
+	if h.seq != 0 {
+		t.Errorf("invalid event recorded as %d", h.seq)
+	}
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/n-nourdine/play-with-containers/api-gateway/events"
+	"github.com/n-nourdine/play-with-containers/api-gateway/metrics"
+)
+
+// resyncType tells a client resuming the stream that events were missed, so
+// that it reloads the movies and orders it displays
+const resyncType = "resync"
+
+// sseRetry is the reconnection delay suggested to SSE clients
+const sseRetry = 3 * time.Second
+
+// wsWriteTimeout bounds the time to send a frame to a WebSocket client
+const wsWriteTimeout = 10 * time.Second
+
+// Access to the stream is granted by API key, and CORS allows any origin
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// StreamEvents streams the movie and order changes as server-sent events,
+// or over a WebSocket when the request asks for an upgrade. The topics query
+// parameter selects movies, orders or both; order events require an API key
+// and are limited to the user of the key. A client reconnecting with
+// Last-Event-ID (or last_event_id, for WebSocket clients) receives the
+// events it missed.
+func (h *Handler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	access, ok := h.EventKeys.Access(eventsKey(r))
+	if !ok {
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+	filter, status, err := eventFilter(r, access)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+
+	sub, backlog, resumed := h.Events.Subscribe(filter, lastID)
+	defer sub.Close()
+
+	if websocket.IsWebSocketUpgrade(r) {
+		h.streamWebSocket(w, r, sub, backlog, resumed)
+		return
+	}
+	h.streamSSE(w, r, sub, backlog, resumed)
+}
+
+// eventsKey reads the API key from the Authorization header, or from the
+// access_token query parameter since browsers cannot set headers on
+// EventSource and WebSocket requests
+func eventsKey(r *http.Request) string {
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(key)
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// eventFilter returns the filter of the topics requested, by default every
+// topic the access allows
+func eventFilter(r *http.Request, access events.Access) (events.Filter, int, error) {
+	topics := access.Topics()
+	if v := r.URL.Query().Get("topics"); v != "" {
+		topics = strings.Split(v, ",")
+	}
+
+	f := events.Filter{Topics: map[string]bool{}, UserID: access.UserID}
+	for _, topic := range topics {
+		topic = strings.TrimSpace(topic)
+		if !slices.Contains(events.Topics, topic) {
+			return f, http.StatusBadRequest, fmt.Errorf("Unknown topic %q: expected %s", topic, strings.Join(events.Topics, ", "))
+		}
+		if !access.Allows(topic) {
+			return f, http.StatusForbidden, fmt.Errorf("The %s topic requires an API key", topic)
+		}
+		f.Topics[topic] = true
+	}
+	return f, 0, nil
+}
+
+func (h *Handler) streamSSE(w http.ResponseWriter, r *http.Request, sub *events.Subscription, backlog []events.Event, resumed bool) {
+	rc := http.NewResponseController(w)
+	// The stream outlives the write timeout of the server
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.Logger.WarnContext(r.Context(), "cannot clear event stream write deadline", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	metrics.EventStreams.WithLabelValues("sse").Inc()
+	defer metrics.EventStreams.WithLabelValues("sse").Dec()
+
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	if !resumed {
+		fmt.Fprintf(w, "event: %s\ndata: {}\n\n", resyncType)
+	}
+	for _, e := range backlog {
+		writeSSE(w, e)
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				// Cut off for being too slow, or shutting down: the client
+				// reconnects and resumes from the last event it got
+				if sub.Dropped() {
+					metrics.EventStreamsDropped.WithLabelValues("sse").Inc()
+				}
+				return
+			}
+			writeSSE(w, e)
+		case <-heartbeat.C:
+			// A comment keeps proxies from closing an idle stream
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, e events.Event) {
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+}
+
+func (h *Handler) streamWebSocket(w http.ResponseWriter, r *http.Request, sub *events.Subscription, backlog []events.Event, resumed bool) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already answered the client
+		h.Logger.WarnContext(r.Context(), "WebSocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	metrics.EventStreams.WithLabelValues("websocket").Inc()
+	defer metrics.EventStreams.WithLabelValues("websocket").Dec()
+
+	// Read to process control frames and to notice the client leaving;
+	// messages from the client are ignored
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(v any) error {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(v)
+	}
+	if !resumed {
+		if err := send(events.Event{Type: resyncType, Data: json.RawMessage("{}")}); err != nil {
+			return
+		}
+	}
+	for _, e := range backlog {
+		if err := send(e); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-gone:
+			return
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+				if sub.Dropped() {
+					metrics.EventStreamsDropped.WithLabelValues("websocket").Inc()
+					msg = websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "client too slow, resume with last_event_id")
+				}
+				conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteTimeout))
+				return
+			}
+			if err := send(e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
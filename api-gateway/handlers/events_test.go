@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/n-nourdine/play-with-containers/api-gateway/events"
+)
+
+func TestEventFilter(t *testing.T) {
+	anonymous := events.Access{}
+	user := events.Access{Orders: true, UserID: "u1"}
+	admin := events.Access{Orders: true}
+
+	tests := []struct {
+		name   string
+		query  string
+		access events.Access
+		status int
+		topics []string
+		userID string
+	}{
+		{"anonymous default", "", anonymous, 0, []string{events.TopicMovies}, ""},
+		{"anonymous orders", "?topics=orders", anonymous, http.StatusForbidden, nil, ""},
+		{"unknown topic", "?topics=movies,payments", admin, http.StatusBadRequest, nil, ""},
+		{"user default", "", user, 0, []string{events.TopicMovies, events.TopicOrders}, "u1"},
+		{"user orders only", "?topics=%20orders", user, 0, []string{events.TopicOrders}, "u1"},
+		{"every user", "?topics=orders,movies", admin, 0, []string{events.TopicMovies, events.TopicOrders}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/events"+tt.query, nil)
+			f, status, err := eventFilter(r, tt.access)
+			if status != tt.status || (err != nil) != (tt.status != 0) {
+				t.Fatalf("eventFilter = %d, %v; want %d", status, err, tt.status)
+			}
+			if err != nil {
+				return
+			}
+			if len(f.Topics) != len(tt.topics) {
+				t.Errorf("topics %v, want %v", f.Topics, tt.topics)
+			}
+			for _, topic := range tt.topics {
+				if !f.Topics[topic] {
+					t.Errorf("topic %s missing from %v", topic, f.Topics)
+				}
+			}
+			if f.UserID != tt.userID {
+				t.Errorf("user %q, want %q", f.UserID, tt.userID)
+			}
+		})
+	}
+}
+
+// TestEventFilterKeepsOtherUsersOut streams through the hub with the filter
+// of a user key: neither the backlog nor live events may carry the orders of
+// another user
+func TestEventFilterKeepsOtherUsersOut(t *testing.T) {
+	hub := events.NewHub(16)
+	hub.Publish(events.TopicOrders, "billing.order.created", "u1", []byte(`{"user_id":"u1"}`))
+	hub.Publish(events.TopicOrders, "billing.order.created", "u2", []byte(`{"user_id":"u2"}`))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/events?topics=orders", nil)
+	f, _, err := eventFilter(r, events.Access{Orders: true, UserID: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Resume from an ID of another instance: the client must resync and
+	// gets no backlog at all
+	sub, backlog, resumed := hub.Subscribe(f, "other-1")
+	defer sub.Close()
+	if resumed || len(backlog) != 0 {
+		t.Fatalf("resumed %v with %d events from a foreign ID", resumed, len(backlog))
+	}
+
+	hub.Publish(events.TopicOrders, "billing.order.paid", "u2", []byte(`{"user_id":"u2"}`))
+	hub.Publish(events.TopicOrders, "billing.order.paid", "u1", []byte(`{"user_id":"u1"}`))
+	e := <-sub.C
+	if string(e.Data) != `{"user_id":"u1"}` {
+		t.Errorf("received %s", e.Data)
+	}
+}
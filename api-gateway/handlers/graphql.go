@@ -0,0 +1,535 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/n-nourdine/play-with-containers/api-gateway/metrics"
+	"github.com/n-nourdine/play-with-containers/api-gateway/money"
+	"github.com/n-nourdine/play-with-containers/shared/logging"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// maxMovieIDs is the number of movies the inventory service returns at once
+// for GET /api/movies?ids=
+const maxMovieIDs = 100
+
+// upstreamClient is used by the GraphQL resolvers
+var upstreamClient = &http.Client{
+	Timeout:   10 * time.Second,
+	Transport: otelhttp.NewTransport(http.DefaultTransport),
+}
+
+// Movie is a movie of the inventory service
+type Movie struct {
+	ID          string `json:"id" readonly:"true" doc:"Unique movie identifier"`
+	Title       string `json:"title" required:"true" doc:"Movie title"`
+	Description string `json:"description" doc:"Movie description"`
+}
+
+// Order is an order of the billing service, as returned by GET /api/orders
+type Order struct {
+	ID              string        `json:"id"`
+	UserID          string        `json:"user_id"`
+	NumberOfItems   string        `json:"number_of_items"`
+	TotalAmount     money.Amount  `json:"total_amount" doc:"Amount due, tax included" example:"150.00"`
+	Currency        string        `json:"currency" example:"EUR"`
+	Status          string        `json:"status" enum:"pending,paid,fulfilled,cancelled,refunded"`
+	CreatedAt       time.Time     `json:"created_at"`
+	Items           []OrderItem   `json:"items" doc:"Only returned by GET /api/orders/{id}"`
+	PriceMismatch   bool          `json:"price_mismatch" doc:"Accepted although the submitted prices differed from the catalog"`
+	SubmittedAmount *money.Amount `json:"submitted_amount" doc:"Total submitted by the client, set when price_mismatch is true"`
+	NetAmount       money.Amount  `json:"net_amount" doc:"Amount before tax" example:"12.49"`
+	TaxAmount       money.Amount  `json:"tax_amount" example:"2.50"`
+	GrossAmount     money.Amount  `json:"gross_amount" doc:"Amount including tax, equal to total_amount" example:"14.99"`
+	TaxRegion       string        `json:"tax_region" doc:"Region whose rate was applied; absent for orders taken before taxes were computed" example:"FR"`
+	TaxRate         string        `json:"tax_rate" doc:"Tax rate in percent" example:"20"`
+	TaxInclusive    bool          `json:"tax_inclusive" doc:"Whether the item prices included tax"`
+}
+
+// OrderPage is a page of GET /api/orders
+type OrderPage struct {
+	Orders     []Order `json:"orders"`
+	NextCursor string  `json:"next_cursor,omitempty" doc:"Absent on the last page"`
+}
+
+// upstreamError is an error answered by an upstream service. Its status is
+// reported in the extensions of the GraphQL error.
+type upstreamError struct {
+	upstream string
+	status   int
+	msg      string
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("%s service: %s", e.upstream, e.msg)
+}
+
+// Extensions reports the status in GraphQL errors
+func (e *upstreamError) Extensions() map[string]any {
+	return map[string]any{"code": errorCode(e.status), "status": e.status, "upstream": e.upstream}
+}
+
+// errorCode names an HTTP status for GraphQL clients
+func errorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "BAD_REQUEST"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusConflict:
+		return "CONFLICT"
+	case http.StatusUnprocessableEntity:
+		return "UNPROCESSABLE"
+	default:
+		return "UPSTREAM_ERROR"
+	}
+}
+
+// callUpstream sends a JSON request to an upstream service like the REST
+// proxy would, and decodes the response into out unless it is nil
+func (h *Handler) callUpstream(ctx context.Context, upstream, baseURL, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if reqID := logging.RequestID(ctx); reqID != "" {
+		req.Header.Set(logging.RequestIDHeader, reqID)
+	}
+
+	start := time.Now()
+	resp, err := upstreamClient.Do(req)
+	if err != nil {
+		metrics.ObserveUpstream(upstream, method, 0, start)
+		h.Logger.ErrorContext(ctx, "upstream service unavailable", "upstream", upstream, "error", err)
+		return &upstreamError{upstream, http.StatusServiceUnavailable, "service unavailable"}
+	}
+	defer resp.Body.Close()
+	metrics.ObserveUpstream(upstream, method, resp.StatusCode, start)
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &upstreamError{upstream, resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s service: invalid response: %w", upstream, err)
+	}
+	return nil
+}
+
+// fetchMovies looks up movies by ID, a hundred at a time
+func (h *Handler) fetchMovies(ctx context.Context, ids []string) (map[string]Movie, error) {
+	found := make(map[string]Movie, len(ids))
+	for i := 0; i < len(ids); i += maxMovieIDs {
+		chunk := ids[i:min(i+maxMovieIDs, len(ids))]
+		escaped := make([]string, len(chunk))
+		for j, id := range chunk {
+			escaped[j] = url.QueryEscape(id)
+		}
+
+		var movies []Movie
+		if err := h.callUpstream(ctx, "inventory", h.InventoryURL, http.MethodGet, "/api/movies?ids="+strings.Join(escaped, ","), nil, &movies); err != nil {
+			return nil, err
+		}
+		for _, m := range movies {
+			found[m.ID] = m
+		}
+	}
+	return found, nil
+}
+
+// loaders are the batch loaders of a GraphQL request
+type loaders struct {
+	movies *batchLoader[Movie]
+}
+
+type loadersKey struct{}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+// async runs fn in the background and returns the thunk waiting for it, so
+// that sibling fields query their upstreams concurrently
+func async(fn func() (any, error)) func() (any, error) {
+	type result struct {
+		v   any
+		err error
+	}
+	c := make(chan result, 1)
+	go func() {
+		v, err := fn()
+		c <- result{v, err}
+	}()
+	return func() (any, error) {
+		r := <-c
+		return r.v, r.err
+	}
+}
+
+// notFound turns a 404 of an upstream into a null result
+func notFound(v any, err error) (any, error) {
+	var ue *upstreamError
+	if errors.As(err, &ue) && ue.status == http.StatusNotFound {
+		return nil, nil
+	}
+	return v, err
+}
+
+// newSchema builds the GraphQL schema of /api/graphql. Movies are fetched
+// in batches: the movies of every order item of a response take a single
+// call to the inventory service.
+func (h *Handler) newSchema() (graphql.Schema, error) {
+	movieType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Movie",
+		Fields: graphql.Fields{
+			"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"title":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"description": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+
+	orderItemType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "OrderItem",
+		Fields: graphql.Fields{
+			"movieId":   &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"kind":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"quantity":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"unitPrice": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"movie": &graphql.Field{
+				Type:        movieType,
+				Description: "Null when the movie was deleted from the inventory",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return loadersFrom(p.Context).movies.Load(p.Context, p.Source.(OrderItem).MovieID), nil
+				},
+			},
+		},
+	})
+
+	orderType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Order",
+		Fields: graphql.Fields{
+			"id":              &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"userId":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"status":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"numberOfItems":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"totalAmount":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"currency":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"netAmount":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"taxAmount":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"grossAmount":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"taxRegion":       &graphql.Field{Type: graphql.String},
+			"taxRate":         &graphql.Field{Type: graphql.String},
+			"priceMismatch":   &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"submittedAmount": &graphql.Field{Type: graphql.String},
+			"createdAt":       &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"items":           &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(orderItemType)))},
+		},
+	})
+
+	orderPageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "OrderPage",
+		Fields: graphql.Fields{
+			"orders":     &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(orderType)))},
+			"nextCursor": &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	billingStatusType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "BillingStatus",
+		Fields: graphql.Fields{
+			"trackingId": &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"status":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"orderId":    &graphql.Field{Type: graphql.ID},
+			"reason":     &graphql.Field{Type: graphql.String},
+			"detail":     &graphql.Field{Type: graphql.String},
+			"updatedAt":  &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+		},
+	})
+
+	billingReceiptType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "BillingReceipt",
+		Fields: graphql.Fields{
+			"trackingId": &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"status":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"statusUrl":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+
+	movieInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "MovieInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"title":       &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"description": &graphql.InputObjectFieldConfig{Type: graphql.String, DefaultValue: ""},
+		},
+	})
+
+	orderItemInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "OrderItemInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"movieId":   &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.ID)},
+			"kind":      &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "rental or purchase (the default)"},
+			"quantity":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.Int)},
+			"unitPrice": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+
+	billingInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "BillingInput",
+		Description: "Same fields as POST /api/billing: either items, or numberOfItems and totalAmount",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"userId":        &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"numberOfItems": &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"totalAmount":   &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"currency":      &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"taxRegion":     &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"items":         &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(orderItemInput))},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"movie": &graphql.Field{
+				Type: movieType,
+				Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return loadersFrom(p.Context).movies.Load(p.Context, p.Args["id"].(string)), nil
+				},
+			},
+			"movies": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(movieType))),
+				Description: "Every movie, or those with the given title",
+				Args:        graphql.FieldConfigArgument{"title": &graphql.ArgumentConfig{Type: graphql.String}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					path := "/api/movies"
+					if title, _ := p.Args["title"].(string); title != "" {
+						path += "?title=" + url.QueryEscape(title)
+					}
+					return async(func() (any, error) {
+						movies := []Movie{}
+						if err := h.callUpstream(p.Context, "inventory", h.InventoryURL, http.MethodGet, path, nil, &movies); err != nil {
+							return nil, err
+						}
+						if movies == nil {
+							movies = []Movie{}
+						}
+						return movies, nil
+					}), nil
+				},
+			},
+			"order": &graphql.Field{
+				Type: orderType,
+				Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					path := "/api/orders/" + url.PathEscape(p.Args["id"].(string))
+					return async(func() (any, error) {
+						var order Order
+						return notFound(order, h.callUpstream(p.Context, "billing", h.BillingURL, http.MethodGet, path, nil, &order))
+					}), nil
+				},
+			},
+			"orders": &graphql.Field{
+				Type:        graphql.NewNonNull(orderPageType),
+				Description: "Newest first, paginated with nextCursor",
+				Args: graphql.FieldConfigArgument{
+					"userId":  &graphql.ArgumentConfig{Type: graphql.String},
+					"status":  &graphql.ArgumentConfig{Type: graphql.String},
+					"flagged": &graphql.ArgumentConfig{Type: graphql.Boolean},
+					"from":    &graphql.ArgumentConfig{Type: graphql.String},
+					"to":      &graphql.ArgumentConfig{Type: graphql.String},
+					"limit":   &graphql.ArgumentConfig{Type: graphql.Int},
+					"cursor":  &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					q := url.Values{}
+					for arg, param := range map[string]string{"userId": "user_id", "status": "status", "from": "from", "to": "to", "cursor": "cursor"} {
+						if v, _ := p.Args[arg].(string); v != "" {
+							q.Set(param, v)
+						}
+					}
+					if v, ok := p.Args["flagged"].(bool); ok {
+						q.Set("flagged", strconv.FormatBool(v))
+					}
+					if v, ok := p.Args["limit"].(int); ok {
+						q.Set("limit", strconv.Itoa(v))
+					}
+					return async(func() (any, error) {
+						var page OrderPage
+						if err := h.callUpstream(p.Context, "billing", h.BillingURL, http.MethodGet, "/api/orders?"+q.Encode(), nil, &page); err != nil {
+							return nil, err
+						}
+						if page.Orders == nil {
+							page.Orders = []Order{}
+						}
+						return page, nil
+					}), nil
+				},
+			},
+			"billingStatus": &graphql.Field{
+				Type:        billingStatusType,
+				Description: "Null for an unknown or expired tracking ID",
+				Args:        graphql.FieldConfigArgument{"trackingId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					entry, ok := h.Tracking.Get(p.Args["trackingId"].(string))
+					if !ok {
+						return nil, nil
+					}
+					return entry, nil
+				},
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createMovie": &graphql.Field{
+				Type: graphql.NewNonNull(movieType),
+				Args: graphql.FieldConfigArgument{"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(movieInput)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					var movie Movie
+					err := h.callUpstream(p.Context, "inventory", h.InventoryURL, http.MethodPost, "/api/movies", p.Args["input"], &movie)
+					return movie, err
+				},
+			},
+			"updateMovie": &graphql.Field{
+				Type: graphql.NewNonNull(movieType),
+				Args: graphql.FieldConfigArgument{
+					"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(movieInput)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					var movie Movie
+					err := h.callUpstream(p.Context, "inventory", h.InventoryURL, http.MethodPut, "/api/movies/"+url.PathEscape(p.Args["id"].(string)), p.Args["input"], &movie)
+					return movie, err
+				},
+			},
+			"deleteMovie": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+				Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					err := h.callUpstream(p.Context, "inventory", h.InventoryURL, http.MethodDelete, "/api/movies/"+url.PathEscape(p.Args["id"].(string)), nil, nil)
+					return err == nil, err
+				},
+			},
+			"submitBilling": &graphql.Field{
+				Type:        graphql.NewNonNull(billingReceiptType),
+				Description: "Publishes an order to the billing queue like POST /api/billing",
+				Args:        graphql.FieldConfigArgument{"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(billingInput)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					req, err := billingRequestFromInput(p.Args["input"].(map[string]any))
+					if err != nil {
+						return nil, err
+					}
+					trackingID, err := h.submitBilling(p.Context, req)
+					if err != nil {
+						return nil, err
+					}
+					return map[string]any{
+						"trackingId": trackingID,
+						"status":     "accepted",
+						"statusUrl":  "/api/billing/" + trackingID,
+					}, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+// billingRequestFromInput converts a BillingInput to the request published
+// by POST /api/billing
+func billingRequestFromInput(in map[string]any) (BillingRequest, error) {
+	str := func(m map[string]any, k string) string {
+		s, _ := m[k].(string)
+		return s
+	}
+	req := BillingRequest{
+		UserID:        str(in, "userId"),
+		NumberOfItems: str(in, "numberOfItems"),
+		TotalAmount:   str(in, "totalAmount"),
+		Currency:      str(in, "currency"),
+		TaxRegion:     str(in, "taxRegion"),
+	}
+	items, _ := in["items"].([]any)
+	for i, v := range items {
+		item := v.(map[string]any)
+		price, err := money.Parse(str(item, "unitPrice"))
+		if err != nil {
+			return req, &billingError{http.StatusBadRequest, fmt.Sprintf("items[%d]: invalid unitPrice: expected a decimal amount such as 12.50", i)}
+		}
+		quantity, _ := item["quantity"].(int)
+		req.Items = append(req.Items, OrderItem{
+			MovieID:   str(item, "movieId"),
+			Kind:      str(item, "kind"),
+			Quantity:  quantity,
+			UnitPrice: price,
+		})
+	}
+	return req, nil
+}
+
+// graphQLRequest is the body of POST /api/graphql
+type graphQLRequest struct {
+	Query         string         `json:"query" required:"true" example:"{ orders(userId: \"123\") { orders { id status items { movie { title } } } } }"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// ServeGraphQL runs a GraphQL query or mutation over the inventory and
+// billing services. Errors of the upstreams are reported in the errors of
+// the response, with their HTTP status in the extensions.
+func (h *Handler) ServeGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req graphQLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Query == "" {
+		http.Error(w, "Invalid GraphQL request: expected a JSON body with a query", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	ctx = context.WithValue(ctx, loadersKey{}, &loaders{movies: newBatchLoader(h.fetchMovies)})
+
+	result := graphql.Do(graphql.Params{
+		Schema:         h.schema,
+		RequestString:  req.Query,
+		OperationName:  req.OperationName,
+		VariableValues: req.Variables,
+		Context:        ctx,
+	})
+	if result.HasErrors() {
+		h.Logger.DebugContext(r.Context(), "GraphQL request completed with errors", "operation", req.OperationName, "errors", result.Errors)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		h.Logger.WarnContext(r.Context(), "error encoding response", "error", err)
+	}
+}
@@ -4,60 +4,157 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/graphql-go/graphql"
+	"github.com/n-nourdine/play-with-containers/api-gateway/config"
+	"github.com/n-nourdine/play-with-containers/api-gateway/events"
+	"github.com/n-nourdine/play-with-containers/api-gateway/message"
+	"github.com/n-nourdine/play-with-containers/api-gateway/metrics"
+	"github.com/n-nourdine/play-with-containers/api-gateway/money"
+	inventoryv1 "github.com/n-nourdine/play-with-containers/api-gateway/proto/inventory/v1"
 	"github.com/n-nourdine/play-with-containers/api-gateway/rabbitmq"
+	"github.com/n-nourdine/play-with-containers/api-gateway/tracking"
+	"github.com/n-nourdine/play-with-containers/shared/logging"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"google.golang.org/grpc"
 )
 
 type Handler struct {
-	Logger    *log.Logger
+	Logger    *slog.Logger
 	Publisher *rabbitmq.Publisher
+
+	// Tracking holds the status of the billing requests, updated by Outcomes
+	Tracking *tracking.Store
+	Outcomes *rabbitmq.Subscriber
+
+	// Events streams the changes received by Changes to GET /api/events
+	Events    *events.Hub
+	EventKeys events.Keys
+	Changes   *rabbitmq.Subscriber
+	heartbeat time.Duration
+
+	// schema serves /api/graphql
+	schema graphql.Schema
+
+	// Base URLs of the upstream services
+	InventoryURL string
+	BillingURL   string
+
+	// Inventory serves the transcoded /api/movies routes when the inventory
+	// transport is grpc
+	Inventory     inventoryv1.InventoryServiceClient
+	inventoryConn *grpc.ClientConn
 }
 
+// BillingRequest is an order submitted for billing. When Items is set,
+// NumberOfItems and TotalAmount are computed from it and any submitted value
+// is replaced.
 type BillingRequest struct {
-	UserID        string `json:"user_id"`
-	NumberOfItems string `json:"number_of_items"`
-	TotalAmount   string `json:"total_amount"`
+	UserID        string      `json:"user_id" required:"true" doc:"ID of the user making the order"`
+	NumberOfItems string      `json:"number_of_items" doc:"Number of items in the order"`
+	TotalAmount   string      `json:"total_amount" doc:"Total cost of the order; without items, the billing service cannot check it and applies its price mismatch policy" example:"150.00"`
+	Currency      string      `json:"currency,omitempty" doc:"ISO 4217 code; with items, checked against the catalog" example:"EUR"`
+	TaxRegion     string      `json:"tax_region,omitempty" doc:"Country code, or country and region, whose tax rate applies; defaults to the billing service's TAX_DEFAULT_REGION" example:"FR"`
+	Items         []OrderItem `json:"items,omitempty"`
 }
 
-func NewHandler(logger *log.Logger) (*Handler, error) {
+func NewHandler(logger *slog.Logger, cfg config.Config) (*Handler, error) {
 	// Create RabbitMQ publisher
-	publisher, err := rabbitmq.NewPublisher(logger)
+	publisher, err := rabbitmq.NewPublisher(logger, cfg.RabbitMQ)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create RabbitMQ publisher: %w", err)
 	}
 
-	return &Handler{
-		Logger:    logger,
-		Publisher: publisher,
-	}, nil
+	store := tracking.NewStore(cfg.Tracking.TTL, cfg.Tracking.MaxEntries)
+	outcomes, err := rabbitmq.NewOutcomeSubscriber(logger, cfg.RabbitMQ, store)
+	if err != nil {
+		publisher.Close()
+		return nil, fmt.Errorf("failed to subscribe to billing outcomes: %w", err)
+	}
+
+	keys, err := events.ParseKeys(cfg.Events.APIKeys)
+	if err != nil {
+		publisher.Close()
+		outcomes.Close()
+		return nil, err
+	}
+	hub := events.NewHub(cfg.Events.BufferSize)
+	changes, err := rabbitmq.NewChangeSubscriber(logger, cfg.RabbitMQ, hub)
+	if err != nil {
+		publisher.Close()
+		outcomes.Close()
+		return nil, fmt.Errorf("failed to subscribe to change events: %w", err)
+	}
+
+	h := &Handler{
+		Logger:       logger,
+		Publisher:    publisher,
+		Tracking:     store,
+		Outcomes:     outcomes,
+		Events:       hub,
+		EventKeys:    keys,
+		Changes:      changes,
+		heartbeat:    cfg.Events.Heartbeat,
+		InventoryURL: cfg.Inventory.URL(),
+		BillingURL:   cfg.Billing.URL(),
+	}
+	if cfg.Inventory.Transport == "grpc" {
+		if h.inventoryConn, err = dialInventory(cfg.Inventory.GRPCTarget()); err != nil {
+			h.Close()
+			return nil, err
+		}
+		h.Inventory = inventoryv1.NewInventoryServiceClient(h.inventoryConn)
+	}
+	if h.schema, err = h.newSchema(); err != nil {
+		h.Close()
+		return nil, fmt.Errorf("invalid GraphQL schema: %w", err)
+	}
+	return h, nil
 }
 
 func (h *Handler) Close() {
 	if h.Publisher != nil {
 		h.Publisher.Close()
 	}
+	if h.Outcomes != nil {
+		h.Outcomes.Close()
+	}
+	if h.Changes != nil {
+		h.Changes.Close()
+	}
+	if h.inventoryConn != nil {
+		h.inventoryConn.Close()
+	}
 }
 
 // ProxyToInventory forwards all requests to the inventory service
 func (h *Handler) ProxyToInventory(w http.ResponseWriter, r *http.Request) {
-	// Get inventory service URL
-	inventoryURL := fmt.Sprintf("http://%s:%s",
-		os.Getenv("INVENTORY_SERVICE_HOST"),
-		os.Getenv("INVENTORY_SERVICE_PORT"))
+	h.proxy(w, r, "inventory", h.InventoryURL)
+}
+
+// ProxyToBilling forwards order queries and status changes to the billing
+// service
+func (h *Handler) ProxyToBilling(w http.ResponseWriter, r *http.Request) {
+	h.proxy(w, r, "billing", h.BillingURL)
+}
 
+// proxy forwards the request unchanged to the upstream at baseURL
+func (h *Handler) proxy(w http.ResponseWriter, r *http.Request, upstream, baseURL string) {
 	// Create the target URL
-	targetURL := inventoryURL + r.URL.Path
+	targetURL := baseURL + r.URL.Path
 	if r.URL.RawQuery != "" {
 		targetURL += "?" + r.URL.RawQuery
 	}
 
-	h.Logger.Printf("Proxying %s request to: %s", r.Method, targetURL)
+	h.Logger.DebugContext(r.Context(), "proxying request", "upstream", upstream, "method", r.Method, "target", targetURL)
 
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
@@ -68,7 +165,7 @@ func (h *Handler) ProxyToInventory(w http.ResponseWriter, r *http.Request) {
 	if r.Body != nil {
 		bodyBytes, err := io.ReadAll(r.Body)
 		if err != nil {
-			h.Logger.Printf("Error reading request body: %v", err)
+			h.Logger.WarnContext(r.Context(), "error reading request body", "error", err)
 			http.Error(w, "Error reading request body", http.StatusBadRequest)
 			return
 		}
@@ -78,7 +175,7 @@ func (h *Handler) ProxyToInventory(w http.ResponseWriter, r *http.Request) {
 	// Create new request
 	req, err := http.NewRequestWithContext(ctx, r.Method, targetURL, body)
 	if err != nil {
-		h.Logger.Printf("Error creating request: %v", err)
+		h.Logger.ErrorContext(r.Context(), "error creating upstream request", "error", err)
 		http.Error(w, "Error creating request", http.StatusInternalServerError)
 		return
 	}
@@ -90,21 +187,28 @@ func (h *Handler) ProxyToInventory(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Make the request
+	// Make the request; the transport injects the traceparent header
 	client := &http.Client{
-		Timeout: 10 * time.Second,
+		Timeout:   10 * time.Second,
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
 	}
 
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		h.Logger.Printf("Error making request to inventory service: %v", err)
-		http.Error(w, "Inventory service unavailable", http.StatusServiceUnavailable)
+		metrics.ObserveUpstream(upstream, r.Method, 0, start)
+		h.Logger.ErrorContext(r.Context(), "upstream service unavailable", "upstream", upstream, "error", err)
+		http.Error(w, fmt.Sprintf("%s service unavailable", upstream), http.StatusServiceUnavailable)
 		return
 	}
 	defer resp.Body.Close()
+	metrics.ObserveUpstream(upstream, r.Method, resp.StatusCode, start)
 
-	// Copy response headers
+	// Copy response headers; the request ID is already set by the gateway
 	for key, values := range resp.Header {
+		if key == logging.RequestIDHeader {
+			continue
+		}
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
@@ -116,20 +220,20 @@ func (h *Handler) ProxyToInventory(w http.ResponseWriter, r *http.Request) {
 	// Copy response body
 	_, err = io.Copy(w, resp.Body)
 	if err != nil {
-		h.Logger.Printf("Error copying response body: %v", err)
+		h.Logger.WarnContext(r.Context(), "error copying response body", "error", err)
 	}
 
-	h.Logger.Printf("Proxied request completed with status: %d", resp.StatusCode)
+	h.Logger.DebugContext(r.Context(), "proxied request completed", "status", resp.StatusCode)
 }
 
 // HandleBilling processes billing requests and sends them to RabbitMQ
 func (h *Handler) HandleBilling(w http.ResponseWriter, r *http.Request) {
-	h.Logger.Println("Received billing request")
+	h.Logger.DebugContext(r.Context(), "received billing request")
 
 	// Read request body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		h.Logger.Printf("Error reading billing request body: %v", err)
+		h.Logger.WarnContext(r.Context(), "error reading billing request body", "error", err)
 		http.Error(w, "Error reading request body", http.StatusBadRequest)
 		return
 	}
@@ -137,341 +241,133 @@ func (h *Handler) HandleBilling(w http.ResponseWriter, r *http.Request) {
 	// Validate JSON structure
 	var billingReq BillingRequest
 	if err := json.Unmarshal(body, &billingReq); err != nil {
-		h.Logger.Printf("Error parsing billing JSON: %v", err)
+		h.Logger.WarnContext(r.Context(), "invalid billing JSON", "error", err)
 		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
 		return
 	}
 
-	// Validate required fields
-	if billingReq.UserID == "" || billingReq.NumberOfItems == "" || billingReq.TotalAmount == "" {
-		h.Logger.Printf("Missing required fields in billing request: %+v", billingReq)
-		http.Error(w, "Missing required fields: user_id, number_of_items, total_amount", http.StatusBadRequest)
-		return
-	}
-
-	// Send message to RabbitMQ
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	err = h.Publisher.PublishBillingMessage(ctx, string(body))
+	trackingID, err := h.submitBilling(ctx, billingReq)
+	var reqErr *billingError
+	if errors.As(err, &reqErr) {
+		http.Error(w, reqErr.msg, reqErr.status)
+		return
+	}
 	if err != nil {
-		h.Logger.Printf("Error publishing billing message: %v", err)
 		http.Error(w, "Error processing billing request", http.StatusInternalServerError)
 		return
 	}
+	statusURL := "/api/billing/" + trackingID
 
 	// Send success response
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", statusURL)
 	w.WriteHeader(http.StatusOK)
 
-	response := map[string]string{
-		"message": "Message posted successfully",
-		"status":  "accepted",
+	response := BillingAccepted{
+		Message:    "Message posted successfully",
+		Status:     "accepted",
+		TrackingID: trackingID,
+		StatusURL:  statusURL,
 	}
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		h.Logger.Printf("Error encoding response: %v", err)
+		h.Logger.WarnContext(r.Context(), "error encoding response", "error", err)
 	}
+}
 
-	h.Logger.Printf("Billing message published successfully for user: %s", billingReq.UserID)
+// BillingAccepted is the response to a billing request published to
+// RabbitMQ
+type BillingAccepted struct {
+	Message    string `json:"message" example:"Message posted successfully"`
+	Status     string `json:"status" example:"accepted"`
+	TrackingID string `json:"tracking_id" format:"uuid" doc:"ID of the billing message, to follow the request"`
+	StatusURL  string `json:"status_url" example:"/api/billing/0f8e4c1a-5a43-4c0e-9f55-8a3e9b1c2d7e"`
 }
 
-// ServeOpenAPIDoc serves the OpenAPI documentation
-func (h *Handler) ServeOpenAPIDoc(w http.ResponseWriter, r *http.Request) {
-	openAPISpec := `{
-  "openapi": "3.0.0",
-  "info": {
-    "title": "Movie Streaming Platform API Gateway",
-    "description": "API Gateway for a microservices-based movie streaming platform. Routes requests to inventory and billing services.",
-    "version": "1.0.0",
-    "contact": {
-      "name": "API Support",
-      "email": "support@movieplatform.com"
-    }
-  },
-  "servers": [
-    {
-      "url": "http://localhost:3000",
-      "description": "Development server"
-    }
-  ],
-  "paths": {
-    "/api/health": {
-      "get": {
-        "summary": "Health check endpoint",
-        "description": "Returns the health status of the API Gateway",
-        "responses": {
-          "200": {
-            "description": "Service is healthy",
-            "content": {
-              "text/plain": {
-                "schema": {
-                  "type": "string",
-                  "example": "API Gateway is healthy"
-                }
-              }
-            }
-          }
-        }
-      }
-    },
-    "/api/movies": {
-      "get": {
-        "summary": "Get all movies",
-        "description": "Retrieve all movies from the inventory. Supports filtering by title.",
-        "parameters": [
-          {
-            "name": "title",
-            "in": "query",
-            "description": "Filter movies by title",
-            "required": false,
-            "schema": {
-              "type": "string"
-            }
-          }
-        ],
-        "responses": {
-          "200": {
-            "description": "List of movies",
-            "content": {
-              "application/json": {
-                "schema": {
-                  "type": "array",
-                  "items": {
-                    "$ref": "#/components/schemas/Movie"
-                  }
-                }
-              }
-            }
-          }
-        }
-      },
-      "post": {
-        "summary": "Create a new movie",
-        "description": "Add a new movie to the inventory",
-        "requestBody": {
-          "required": true,
-          "content": {
-            "application/json": {
-              "schema": {
-                "type": "object",
-                "required": ["title"],
-                "properties": {
-                  "title": {
-                    "type": "string",
-                    "description": "Movie title"
-                  },
-                  "description": {
-                    "type": "string",
-                    "description": "Movie description"
-                  }
-                }
-              }
-            }
-          }
-        },
-        "responses": {
-          "200": {
-            "description": "Movie created successfully",
-            "content": {
-              "application/json": {
-                "schema": {
-                  "$ref": "#/components/schemas/Movie"
-                }
-              }
-            }
-          }
-        }
-      },
-      "delete": {
-        "summary": "Delete all movies",
-        "description": "Delete all movies from the inventory. Requires confirmation header.",
-        "parameters": [
-          {
-            "name": "Confirm-Delete",
-            "in": "header",
-            "required": true,
-            "schema": {
-              "type": "string",
-              "enum": ["yes"]
-            },
-            "description": "Confirmation header required to delete all movies"
-          }
-        ],
-        "responses": {
-          "204": {
-            "description": "All movies deleted successfully"
-          }
-        }
-      }
-    },
-    "/api/movies/{id}": {
-      "get": {
-        "summary": "Get movie by ID",
-        "description": "Retrieve a specific movie by its ID",
-        "parameters": [
-          {
-            "name": "id",
-            "in": "path",
-            "required": true,
-            "schema": {
-              "type": "string"
-            }
-          }
-        ],
-        "responses": {
-          "200": {
-            "description": "Movie details",
-            "content": {
-              "application/json": {
-                "schema": {
-                  "$ref": "#/components/schemas/Movie"
-                }
-              }
-            }
-          }
-        }
-      },
-      "put": {
-        "summary": "Update movie by ID",
-        "description": "Update an existing movie",
-        "parameters": [
-          {
-            "name": "id",
-            "in": "path",
-            "required": true,
-            "schema": {
-              "type": "string"
-            }
-          }
-        ],
-        "requestBody": {
-          "required": true,
-          "content": {
-            "application/json": {
-              "schema": {
-                "type": "object",
-                "properties": {
-                  "title": {
-                    "type": "string"
-                  },
-                  "description": {
-                    "type": "string"
-                  }
-                }
-              }
-            }
-          }
-        },
-        "responses": {
-          "200": {
-            "description": "Movie updated successfully"
-          }
-        }
-      },
-      "delete": {
-        "summary": "Delete movie by ID",
-        "description": "Delete a specific movie by its ID",
-        "parameters": [
-          {
-            "name": "id",
-            "in": "path",
-            "required": true,
-            "schema": {
-              "type": "string"
-            }
-          }
-        ],
-        "responses": {
-          "204": {
-            "description": "Movie deleted successfully"
-          }
-        }
-      }
-    },
-    "/api/billing": {
-      "post": {
-        "summary": "Process billing request",
-        "description": "Submit a billing order that will be processed asynchronously via RabbitMQ",
-        "requestBody": {
-          "required": true,
-          "content": {
-            "application/json": {
-              "schema": {
-                "$ref": "#/components/schemas/BillingRequest"
-              }
-            }
-          }
-        },
-        "responses": {
-          "200": {
-            "description": "Billing request accepted",
-            "content": {
-              "application/json": {
-                "schema": {
-                  "type": "object",
-                  "properties": {
-                    "message": {
-                      "type": "string",
-                      "example": "Message posted successfully"
-                    },
-                    "status": {
-                      "type": "string",
-                      "example": "accepted"
-                    }
-                  }
-                }
-              }
-            }
-          }
-        }
-      }
-    }
-  },
-  "components": {
-    "schemas": {
-      "Movie": {
-        "type": "object",
-        "properties": {
-          "id": {
-            "type": "string",
-            "description": "Unique movie identifier"
-          },
-          "title": {
-            "type": "string",
-            "description": "Movie title"
-          },
-          "description": {
-            "type": "string",
-            "description": "Movie description"
-          }
-        }
-      },
-      "BillingRequest": {
-        "type": "object",
-        "required": ["user_id", "number_of_items", "total_amount"],
-        "properties": {
-          "user_id": {
-            "type": "string",
-            "description": "ID of the user making the order"
-          },
-          "number_of_items": {
-            "type": "string",
-            "description": "Number of items in the order"
-          },
-          "total_amount": {
-            "type": "string",
-            "description": "Total cost of the order"
-          }
-        }
-      }
-    }
-  }
-}`
+// billingError is a billing request refused before being published, with
+// the HTTP status to answer
+type billingError struct {
+	status int
+	msg    string
+}
+
+func (e *billingError) Error() string {
+	return e.msg
+}
+
+// Extensions reports the status in GraphQL errors
+func (e *billingError) Extensions() map[string]any {
+	return map[string]any{"code": errorCode(e.status), "status": e.status}
+}
+
+// submitBilling validates a billing request, completes it from its items
+// and publishes it to RabbitMQ. It returns the tracking ID of the message;
+// requests refused are reported as *billingError.
+func (h *Handler) submitBilling(ctx context.Context, billingReq BillingRequest) (string, error) {
+	// Validate required fields
+	if billingReq.UserID == "" || (len(billingReq.Items) == 0 && (billingReq.NumberOfItems == "" || billingReq.TotalAmount == "")) {
+		h.Logger.WarnContext(ctx, "missing required fields in billing request", "user_id", billingReq.UserID)
+		return "", &billingError{http.StatusBadRequest, "Missing required fields: user_id, and items or number_of_items and total_amount"}
+	}
+
+	if len(billingReq.Items) == 0 {
+		if _, err := money.Parse(billingReq.TotalAmount); err != nil {
+			return "", &billingError{http.StatusBadRequest, "Invalid total_amount: expected a decimal amount such as 12.50"}
+		}
+	} else {
+		count, total, err := orderTotals(billingReq.Items)
+		if err != nil {
+			return "", &billingError{http.StatusBadRequest, err.Error()}
+		}
+
+		unknown, err := h.unknownMovies(ctx, billingReq.Items)
+		if err != nil {
+			h.Logger.ErrorContext(ctx, "error checking order movies", "error", err)
+			return "", &billingError{http.StatusServiceUnavailable, "Cannot check movies: inventory service unavailable"}
+		}
+		if len(unknown) > 0 {
+			h.Logger.InfoContext(ctx, "billing request for unknown movies", "user_id", billingReq.UserID, "movie_ids", unknown)
+			return "", &billingError{http.StatusUnprocessableEntity, fmt.Sprintf("Unknown movies: %s", strings.Join(unknown, ", "))}
+		}
+
+		billingReq.NumberOfItems = strconv.Itoa(count)
+		billingReq.TotalAmount = total.String()
+	}
+
+	// Send message to RabbitMQ. Only the fields of BillingRequest are
+	// forwarded, and the message must match its schema. Premium users get
+	// ahead of the queue.
+	trackingID, err := h.Publisher.PublishBillingMessage(ctx, message.OrderCreated, billingReq, h.Publisher.Priority(billingReq.UserID))
+	if errors.Is(err, message.ErrInvalid) {
+		h.Logger.WarnContext(ctx, "billing request does not match the message schema", "error", err)
+		return "", &billingError{http.StatusBadRequest, "Invalid billing request: " + err.Error()}
+	}
+	if err != nil {
+		h.Logger.ErrorContext(ctx, "error publishing billing message", "error", err)
+		return "", err
+	}
+
+	h.Tracking.Queue(trackingID)
+	h.Logger.InfoContext(ctx, "billing message published", "user_id", billingReq.UserID, "tracking_id", trackingID)
+	return trackingID, nil
+}
+
+// GetBillingStatus reports whether a billing request is still queued, or
+// was persisted or rejected by the billing service
+func (h *Handler) GetBillingStatus(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.Tracking.Get(r.PathValue("trackingId"))
+	if !ok {
+		http.Error(w, "Unknown or expired tracking ID", http.StatusNotFound)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(openAPISpec))
+	if err := json.NewEncoder(w).Encode(entry); err != nil {
+		h.Logger.WarnContext(r.Context(), "error encoding response", "error", err)
+	}
 }
 
 // ServeSwaggerUI serves a simple Swagger UI interface
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/api-gateway/metrics"
+	"github.com/n-nourdine/play-with-containers/api-gateway/money"
+	"github.com/n-nourdine/play-with-containers/shared/logging"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// MaxItemQuantity bounds the quantity of a single order line
+const MaxItemQuantity = 1000
+
+// OrderItem is one line of a billing request. Kind is "rental" or
+// "purchase" (the default).
+type OrderItem struct {
+	MovieID   string       `json:"movie_id" required:"true" doc:"ID of a movie known to the inventory service"`
+	Kind      string       `json:"kind,omitempty" enum:"rental,purchase" default:"purchase"`
+	Quantity  int          `json:"quantity" required:"true" min:"1" max:"1000"`
+	UnitPrice money.Amount `json:"unit_price" required:"true" example:"12.50"`
+}
+
+// inventoryClient is used to look up movies referenced by orders
+var inventoryClient = &http.Client{
+	Timeout:   3 * time.Second,
+	Transport: otelhttp.NewTransport(http.DefaultTransport),
+}
+
+// errInventoryUnavailable means the movies of an order could not be checked
+var errInventoryUnavailable = errors.New("inventory service unavailable")
+
+// orderTotals validates the lines of an order and returns the number of
+// copies and the amount due
+func orderTotals(items []OrderItem) (int, money.Amount, error) {
+	var (
+		count int
+		total money.Amount
+	)
+	for i, item := range items {
+		if item.MovieID == "" {
+			return 0, 0, fmt.Errorf("items[%d]: movie_id is required", i)
+		}
+		if item.Kind != "" && item.Kind != "rental" && item.Kind != "purchase" {
+			return 0, 0, fmt.Errorf("items[%d]: kind must be rental or purchase", i)
+		}
+		if item.Quantity < 1 || item.Quantity > MaxItemQuantity {
+			return 0, 0, fmt.Errorf("items[%d]: quantity must be between 1 and %d", i, MaxItemQuantity)
+		}
+		if item.UnitPrice <= 0 {
+			return 0, 0, fmt.Errorf("items[%d]: unit_price must be positive", i)
+		}
+		line, err := item.UnitPrice.Mul(int64(item.Quantity))
+		if err != nil {
+			return 0, 0, fmt.Errorf("items[%d]: %w", i, err)
+		}
+		if total, err = total.Add(line); err != nil {
+			return 0, 0, err
+		}
+		count += item.Quantity
+	}
+	return count, total, nil
+}
+
+// unknownMovies returns the IDs among items that the inventory service does
+// not know. Lookups run concurrently, once per distinct movie.
+func (h *Handler) unknownMovies(ctx context.Context, items []OrderItem) ([]string, error) {
+	ids := make(map[string]bool)
+	for _, item := range items {
+		ids[item.MovieID] = true
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		unknown  []string
+		firstErr error
+	)
+	for id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			found, err := h.movieExists(ctx, id)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				if firstErr == nil {
+					firstErr = err
+				}
+			case !found:
+				unknown = append(unknown, id)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, fmt.Errorf("%w: %v", errInventoryUnavailable, firstErr)
+	}
+	return unknown, nil
+}
+
+// movieExists asks the inventory service for a single movie
+func (h *Handler) movieExists(ctx context.Context, id string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.InventoryURL+"/api/movies/"+url.PathEscape(id), nil)
+	if err != nil {
+		return false, err
+	}
+	if reqID := logging.RequestID(ctx); reqID != "" {
+		req.Header.Set(logging.RequestIDHeader, reqID)
+	}
+
+	start := time.Now()
+	resp, err := inventoryClient.Do(req)
+	if err != nil {
+		metrics.ObserveUpstream("inventory", http.MethodGet, 0, start)
+		return false, err
+	}
+	defer resp.Body.Close()
+	metrics.ObserveUpstream("inventory", http.MethodGet, resp.StatusCode, start)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("GET /api/movies/%s returned %d", id, resp.StatusCode)
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+)
+
+// batchLoader gathers the keys requested while a GraphQL query resolves one
+// level of its result, and fetches them with a single upstream call when the
+// first value is needed. Results are kept for the rest of the query, so a
+// key is fetched once however many fields refer to it.
+//
+// It relies on graphql-go resolving thunks breadth-first: every thunk of a
+// level is created before the first one is called.
+type batchLoader[V any] struct {
+	// fetch returns the values found among keys; missing keys resolve to
+	// null
+	fetch func(ctx context.Context, keys []string) (map[string]V, error)
+
+	mu      sync.Mutex
+	pending []string
+	results map[string]*loaded[V]
+}
+
+type loaded[V any] struct {
+	done  chan struct{}
+	value *V
+	err   error
+}
+
+func newBatchLoader[V any](fetch func(ctx context.Context, keys []string) (map[string]V, error)) *batchLoader[V] {
+	return &batchLoader[V]{fetch: fetch, results: make(map[string]*loaded[V])}
+}
+
+// Load queues a key and returns the thunk resolving to its value
+func (l *batchLoader[V]) Load(ctx context.Context, key string) func() (any, error) {
+	l.mu.Lock()
+	r, ok := l.results[key]
+	if !ok {
+		r = &loaded[V]{done: make(chan struct{})}
+		l.results[key] = r
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (any, error) {
+		l.dispatch(ctx)
+		<-r.done
+		if r.err != nil {
+			return nil, r.err
+		}
+		if r.value == nil {
+			return nil, nil
+		}
+		return *r.value, nil
+	}
+}
+
+// dispatch fetches the keys queued so far
+func (l *batchLoader[V]) dispatch(ctx context.Context) {
+	l.mu.Lock()
+	keys := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+	if len(keys) == 0 {
+		return
+	}
+
+	values, err := l.fetch(ctx, keys)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		r := l.results[key]
+		if v, ok := values[key]; ok {
+			r.value = &v
+		}
+		r.err = err
+		close(r.done)
+	}
+}
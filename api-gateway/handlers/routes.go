@@ -0,0 +1,531 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/n-nourdine/play-with-containers/api-gateway/events"
+	"github.com/n-nourdine/play-with-containers/api-gateway/health"
+	"github.com/n-nourdine/play-with-containers/api-gateway/metrics"
+	"github.com/n-nourdine/play-with-containers/api-gateway/money"
+	"github.com/n-nourdine/play-with-containers/api-gateway/openapi"
+	"github.com/n-nourdine/play-with-containers/api-gateway/tracking"
+)
+
+// APIInfo describes the gateway in its OpenAPI document
+var APIInfo = openapi.Info{
+	Title:       "Movie Streaming Platform API Gateway",
+	Description: "API Gateway for a microservices-based movie streaming platform. Routes requests to inventory and billing services.",
+	Version:     "1.0.0",
+}
+
+// Parameters and responses shared by several routes
+var (
+	movieID = openapi.PathParam("id", "Movie ID")
+	orderID = openapi.PathParam("id", "Order ID")
+	kind    = openapi.Param{Name: "kind", In: openapi.InPath, Required: true, Schema: &openapi.Schema{Type: "string", Enum: []string{"rental", "purchase"}}}
+
+	textBody = map[string]*openapi.Schema{"text/plain": {Type: "string"}}
+
+	inventoryDown = openapi.Response{Status: http.StatusServiceUnavailable, Description: "The inventory service could not be reached"}
+	billingDown   = openapi.Response{Status: http.StatusServiceUnavailable, Description: "The billing service could not be reached"}
+	movieNotFound = openapi.Response{Status: http.StatusNotFound, Description: "Movie not found"}
+	orderNotFound = openapi.Response{Status: http.StatusNotFound, Description: "Order not found"}
+	badFormat     = openapi.Response{Status: http.StatusNotAcceptable, Description: "Unsupported format"}
+	timeout       = openapi.Response{Status: http.StatusGatewayTimeout, Description: "The inventory database did not answer in time"}
+)
+
+// format selects the representation of a report, also chosen by the Accept
+// header
+func format(alt string) openapi.Param {
+	return openapi.QueryParam("format", "", &openapi.Schema{Type: "string", Enum: []string{"json", alt}})
+}
+
+// Register registers the routes of the gateway with their documentation.
+// Catalog routes are transcoded to gRPC when the inventory is reached over
+// gRPC, and GraphQL is only served when enabled.
+func (h *Handler) Register(api *openapi.Router, checker *health.Checker, graphQL bool) {
+	api.Define(money.Amount(0), &openapi.Schema{Type: "string", Description: "Decimal amount", Example: "14.99"})
+	api.Component("BillingRequest", BillingRequest{}, "Either items, or number_of_items and total_amount, must be given. With items, both totals are computed from them.")
+	api.Component("BillingStatus", tracking.Entry{}, "")
+	api.Component("GraphQLRequest", graphQLRequest{}, "")
+	api.Component("GraphQLResponse", graphql.Result{}, "")
+	api.Component("HealthReport", health.Report{}, "")
+	api.Component("HealthCheck", health.Result{}, "")
+
+	h.registerStatus(api, checker)
+	h.registerMovies(api)
+	h.registerPricing(api)
+	h.registerOrders(api)
+	h.registerBilling(api)
+	if graphQL {
+		h.registerGraphQL(api)
+	}
+
+	// Serve OpenAPI documentation
+	api.HandleFunc("GET /api/docs", api.ServeJSON, openapi.Operation{
+		Summary:   "OpenAPI document",
+		Tags:      []string{"Documentation"},
+		Responses: []openapi.Response{{Status: http.StatusOK, Description: "This document, as JSON", Content: map[string]*openapi.Schema{"application/json": {Type: "object"}}}},
+	})
+	api.HandleFunc("GET /api/docs.yaml", api.ServeYAML, openapi.Operation{
+		Summary:   "OpenAPI document as YAML",
+		Tags:      []string{"Documentation"},
+		Responses: []openapi.Response{{Status: http.StatusOK, Description: "This document, as YAML", Content: map[string]*openapi.Schema{"application/yaml": {Type: "string"}}}},
+	})
+	api.HandleFunc("GET /", h.ServeSwaggerUI, openapi.Operation{
+		Summary:     "Swagger UI",
+		Description: "Browses this document. Also served for any path without a route.",
+		Tags:        []string{"Documentation"},
+		Responses:   []openapi.Response{{Status: http.StatusOK, Description: "HTML page", Content: map[string]*openapi.Schema{"text/html": {Type: "string"}}}},
+	})
+}
+
+func (h *Handler) registerStatus(api *openapi.Router, checker *health.Checker) {
+	tags := []string{"Status"}
+	report := []openapi.Response{
+		{Status: http.StatusOK, Description: "Every check is up", Type: health.Report{}},
+		{Status: http.StatusServiceUnavailable, Description: "A check is down", Type: health.Report{}},
+	}
+
+	// Health check endpoints
+	api.HandleFunc("GET /api/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("API Gateway is healthy"))
+	}, openapi.Operation{
+		Summary:     "Health check endpoint",
+		Description: "Returns the health status of the API Gateway",
+		Tags:        tags,
+		Responses:   []openapi.Response{{Status: http.StatusOK, Description: "Service is healthy", Content: map[string]*openapi.Schema{"text/plain": {Type: "string", Example: "API Gateway is healthy"}}}},
+	})
+	api.HandleFunc("GET /livez", checker.Live, openapi.Operation{
+		Summary:   "Liveness probe",
+		Tags:      tags,
+		Responses: []openapi.Response{{Status: http.StatusOK, Description: "The gateway is serving HTTP", Type: health.Report{}}},
+	})
+	api.HandleFunc("GET /readyz", checker.Ready, openapi.Operation{
+		Summary:     "Readiness probe",
+		Description: "Checks RabbitMQ and the inventory service",
+		Tags:        tags,
+		Responses:   report,
+	})
+	api.HandleFunc("GET /api/status", h.ServePlatformStatus(checker), openapi.Operation{
+		Summary:     "Platform status",
+		Description: "Readiness of the gateway and of each upstream service",
+		Tags:        tags,
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Every service is ready", Type: PlatformStatus{}},
+			{Status: http.StatusServiceUnavailable, Description: "A service is not ready", Type: PlatformStatus{}},
+		},
+	})
+
+	// Prometheus metrics endpoint
+	api.Handle("GET /metrics", metrics.Handler(), openapi.Operation{
+		Summary:   "Prometheus metrics",
+		Tags:      tags,
+		Responses: []openapi.Response{{Status: http.StatusOK, Description: "Metrics in the Prometheus text format", Content: textBody}},
+	})
+}
+
+// registerMovies registers the catalog routes. They are proxied to the
+// inventory service, or transcoded to its gRPC API.
+func (h *Handler) registerMovies(api *openapi.Router) {
+	tags := []string{"Movies"}
+	list, get, create, update, remove := h.ProxyToInventory, h.ProxyToInventory, h.ProxyToInventory, h.ProxyToInventory, h.ProxyToInventory
+	if h.Inventory != nil {
+		list, get, create, update, remove = h.TranscodeGetMovies, h.TranscodeGetMovie, h.TranscodeCreateMovie, h.TranscodeUpdateMovie, h.TranscodeDeleteMovie
+	}
+
+	api.HandleFunc("GET /api/movies", list, openapi.Operation{
+		Summary:     "Get all movies",
+		Description: "Retrieve all movies from the inventory. Supports filtering by title.",
+		Tags:        tags,
+		Params: []openapi.Param{
+			openapi.QueryParam("title", "Filter movies by title", nil),
+			openapi.QueryParam("ids", "Comma-separated movie IDs, at most 100; unknown IDs are left out", nil),
+		},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "List of movies", Type: []Movie{}},
+			{Status: http.StatusBadRequest, Description: "More than 100 IDs"},
+			timeout, inventoryDown,
+		},
+	})
+	api.HandleFunc("POST /api/movies", create, openapi.Operation{
+		Summary:     "Create a new movie",
+		Description: "Add a new movie to the inventory",
+		Tags:        tags,
+		Body:        &openapi.Body{Required: true, Type: Movie{}},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Movie created, with its ID", Type: Movie{}},
+			{Status: http.StatusBadRequest, Description: "Invalid JSON, or missing title"},
+			{Status: http.StatusInternalServerError, Description: "The movie could not be saved"},
+			timeout, inventoryDown,
+		},
+	})
+	api.HandleFunc("DELETE /api/movies", h.ProxyToInventory, openapi.Operation{
+		Summary:     "Delete all movies",
+		Description: "Delete all movies from the inventory. Requires confirmation header.",
+		Tags:        tags,
+		Params: []openapi.Param{{
+			Name:        "Confirm-Delete",
+			In:          openapi.InHeader,
+			Description: "Confirmation header required to delete all movies",
+			Required:    true,
+			Schema:      &openapi.Schema{Type: "string", Enum: []string{"yes"}},
+		}},
+		Responses: []openapi.Response{
+			{Status: http.StatusNoContent, Description: "All movies deleted successfully"},
+			{Status: http.StatusBadRequest, Description: "Missing Confirm-Delete header"},
+			{Status: http.StatusNotFound, Description: "There was no movie to delete"},
+			timeout, inventoryDown,
+		},
+	})
+	api.HandleFunc("GET /api/movies/{id}", get, openapi.Operation{
+		Summary:     "Get movie by ID",
+		Description: "Retrieve a specific movie by its ID",
+		Tags:        tags,
+		Params:      []openapi.Param{movieID},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Movie details", Type: Movie{}},
+			movieNotFound, timeout, inventoryDown,
+		},
+	})
+	api.HandleFunc("PUT /api/movies/{id}", update, openapi.Operation{
+		Summary:     "Update movie by ID",
+		Description: "Replace the title and description of a movie; both are required",
+		Tags:        tags,
+		Params:      []openapi.Param{movieID},
+		Body:        &openapi.Body{Required: true, Type: Movie{}},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Movie updated", Type: Movie{}},
+			{Status: http.StatusBadRequest, Description: "Invalid JSON, or missing title or description"},
+			movieNotFound,
+			{Status: http.StatusInternalServerError, Description: "The movie could not be updated"},
+			timeout, inventoryDown,
+		},
+	})
+	api.HandleFunc("DELETE /api/movies/{id}", remove, openapi.Operation{
+		Summary:     "Delete movie by ID",
+		Description: "Delete a specific movie by its ID",
+		Tags:        tags,
+		Params:      []openapi.Param{movieID},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Movie deleted"},
+			movieNotFound, timeout, inventoryDown,
+		},
+	})
+}
+
+// registerPricing registers the offers and promotions, also served by the
+// inventory service
+func (h *Handler) registerPricing(api *openapi.Router) {
+	tags := []string{"Pricing"}
+
+	api.HandleFunc("GET /api/movies/{id}/offers", h.ProxyToInventory, openapi.Operation{
+		Summary:     "Get movie offers",
+		Description: "Rental and purchase prices, with the price to pay after the best promotion in force",
+		Tags:        tags,
+		Params: []openapi.Param{
+			movieID,
+			openapi.QueryParam("at", "Pricing time (RFC 3339), default now", &openapi.Schema{Type: "string", Format: "date-time"}),
+		},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Offers of the movie, possibly empty", Type: MovieOffers{}},
+			{Status: http.StatusBadRequest, Description: "Invalid pricing time"},
+			movieNotFound, inventoryDown,
+		},
+	})
+	api.HandleFunc("PUT /api/movies/{id}/offers/{kind}", h.ProxyToInventory, openapi.Operation{
+		Summary: "Create or replace an offer",
+		Tags:    tags,
+		Params:  []openapi.Param{movieID, kind},
+		Body:    &openapi.Body{Required: true, Type: Offer{}},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Offer saved", Type: Offer{}},
+			{Status: http.StatusBadRequest, Description: "Invalid offer; rental_days is required for rentals only"},
+			movieNotFound, inventoryDown,
+		},
+	})
+	api.HandleFunc("DELETE /api/movies/{id}/offers/{kind}", h.ProxyToInventory, openapi.Operation{
+		Summary: "Withdraw an offer",
+		Tags:    tags,
+		Params:  []openapi.Param{movieID, kind},
+		Responses: []openapi.Response{
+			{Status: http.StatusNoContent, Description: "Offer withdrawn"},
+			{Status: http.StatusNotFound, Description: "Offer not found"},
+			inventoryDown,
+		},
+	})
+	api.HandleFunc("GET /api/movies/{id}/promotions", h.ProxyToInventory, openapi.Operation{
+		Summary: "List current and upcoming promotions",
+		Tags:    tags,
+		Params:  []openapi.Param{movieID},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Promotions, soonest first", Type: []Promotion{}},
+			inventoryDown,
+		},
+	})
+	api.HandleFunc("POST /api/movies/{id}/promotions", h.ProxyToInventory, openapi.Operation{
+		Summary: "Add a promotion",
+		Tags:    tags,
+		Params:  []openapi.Param{movieID},
+		Body:    &openapi.Body{Required: true, Type: Promotion{}},
+		Responses: []openapi.Response{
+			{Status: http.StatusCreated, Description: "Promotion created", Type: Promotion{}},
+			{Status: http.StatusBadRequest, Description: "Invalid promotion"},
+			movieNotFound, inventoryDown,
+		},
+	})
+	api.HandleFunc("DELETE /api/promotions/{id}", h.ProxyToInventory, openapi.Operation{
+		Summary: "Remove a promotion",
+		Tags:    tags,
+		Params:  []openapi.Param{{Name: "id", In: openapi.InPath, Description: "Promotion ID", Required: true, Schema: &openapi.Schema{Type: "integer"}}},
+		Responses: []openapi.Response{
+			{Status: http.StatusNoContent, Description: "Promotion removed"},
+			{Status: http.StatusNotFound, Description: "Promotion not found"},
+			inventoryDown,
+		},
+	})
+}
+
+// registerOrders registers the order queries and lifecycle, proxied to the
+// billing service
+func (h *Handler) registerOrders(api *openapi.Router) {
+	tags := []string{"Orders"}
+	statusChanged := openapi.Response{Status: http.StatusOK, Description: "Status changed", Type: OrderEvent{}}
+	cannotMove := openapi.Response{Status: http.StatusConflict, Description: "The order cannot move to this status"}
+	providerDown := openapi.Response{Status: http.StatusBadGateway, Description: "Payment provider unavailable"}
+
+	api.HandleFunc("GET /api/orders", h.ProxyToBilling, openapi.Operation{
+		Summary:     "List orders",
+		Description: "Newest first, paginated with an opaque cursor",
+		Tags:        tags,
+		Params: []openapi.Param{
+			openapi.QueryParam("user_id", "", nil),
+			openapi.QueryParam("status", "", &openapi.Schema{Type: "string", Enum: []string{"pending", "paid", "fulfilled", "cancelled", "refunded"}}),
+			openapi.QueryParam("flagged", "Only orders accepted with a price mismatch", &openapi.Schema{Type: "boolean"}),
+			openapi.QueryParam("from", "Inclusive lower bound (RFC 3339 or YYYY-MM-DD)", nil),
+			openapi.QueryParam("to", "Exclusive upper bound (RFC 3339 or YYYY-MM-DD)", nil),
+			openapi.QueryParam("limit", "", &openapi.Schema{Type: "integer", Minimum: bound(1), Maximum: bound(500), Default: 50}),
+			openapi.QueryParam("cursor", "next_cursor from the previous page", nil),
+		},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "One page of orders", Type: OrderPage{}},
+			{Status: http.StatusBadRequest, Description: "Invalid filter or cursor"},
+			billingDown,
+		},
+	})
+	api.HandleFunc("GET /api/orders/{id}", h.ProxyToBilling, openapi.Operation{
+		Summary: "Get order by ID",
+		Tags:    tags,
+		Params:  []openapi.Param{orderID},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Order found", Type: Order{}},
+			orderNotFound, billingDown,
+		},
+	})
+	api.HandleFunc("GET /api/orders/{id}/events", h.ProxyToBilling, openapi.Operation{
+		Summary: "Get order status history",
+		Tags:    tags,
+		Params:  []openapi.Param{orderID},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Status changes, oldest first", Type: []OrderEvent{}},
+			orderNotFound, billingDown,
+		},
+	})
+	api.HandleFunc("POST /api/orders/{id}/cancel", h.ProxyToBilling, openapi.Operation{
+		Summary:     "Cancel order",
+		Description: "Only pending orders can be cancelled. An authorized payment is voided at the provider first; the order is cancelled when the provider confirms.",
+		Tags:        tags,
+		Params:      []openapi.Param{orderID},
+		Body:        &openapi.Body{Type: StatusChange{}},
+		Responses: []openapi.Response{
+			statusChanged,
+			{Status: http.StatusAccepted, Description: "Void pending at the payment provider", Type: Payment{}},
+			{Status: http.StatusPaymentRequired, Description: "Void refused by the payment provider"},
+			orderNotFound,
+			{Status: http.StatusConflict, Description: "The order cannot move to this status, or its payment authorization is still pending"},
+			providerDown, billingDown,
+		},
+	})
+	api.HandleFunc("POST /api/orders/{id}/fulfill", h.ProxyToBilling, openapi.Operation{
+		Summary:     "Fulfill order",
+		Description: "Marks a paid order as delivered. Only paid orders can be fulfilled.",
+		Tags:        tags,
+		Params:      []openapi.Param{orderID},
+		Body:        &openapi.Body{Type: StatusChange{}},
+		Responses:   []openapi.Response{statusChanged, orderNotFound, cannotMove, billingDown},
+	})
+	api.HandleFunc("POST /api/orders/{id}/refund", h.ProxyToBilling, openapi.Operation{
+		Summary:     "Refund order",
+		Description: "Only paid or fulfilled orders can be refunded. A captured payment is refunded at the provider first; the order is refunded when the provider confirms. A payment that is not captured cannot be refunded: cancel the order to release it.",
+		Tags:        tags,
+		Params:      []openapi.Param{orderID},
+		Body:        &openapi.Body{Type: StatusChange{}},
+		Responses: []openapi.Response{
+			statusChanged,
+			{Status: http.StatusAccepted, Description: "Refund pending at the payment provider", Type: Payment{}},
+			{Status: http.StatusPaymentRequired, Description: "Refund refused by the payment provider"},
+			orderNotFound, cannotMove, providerDown, billingDown,
+		},
+	})
+	api.HandleFunc("GET /api/orders/{id}/invoice", h.ProxyToBilling, openapi.Operation{
+		Summary:     "Get order invoice",
+		Description: "Returns the invoice of a paid, fulfilled or refunded order, issuing it on first request. Issued invoices never change. Rendered as HTML with format=html or an Accept header preferring text/html.",
+		Tags:        tags,
+		Params:      []openapi.Param{orderID, format("html")},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Invoice", Type: Invoice{}, Content: map[string]*openapi.Schema{"text/html": {Type: "string"}}},
+			orderNotFound, badFormat,
+			{Status: http.StatusConflict, Description: "The order is not paid, or was cancelled"},
+			billingDown,
+		},
+	})
+	api.HandleFunc("POST /api/orders/{id}/pay", h.ProxyToBilling, openapi.Operation{
+		Summary:     "Pay order",
+		Description: "Authorizes the payment of a pending order for its total. The order becomes paid when the payment is captured.",
+		Tags:        tags,
+		Params:      []openapi.Param{orderID},
+		Body:        &openapi.Body{Type: PayRequest{}},
+		Responses: []openapi.Response{
+			{Status: http.StatusCreated, Description: "Payment authorized", Type: Payment{}},
+			{Status: http.StatusAccepted, Description: "Authorization pending, the result will arrive by webhook", Type: Payment{}},
+			{Status: http.StatusPaymentRequired, Description: "Payment declined", Type: Payment{}},
+			orderNotFound,
+			{Status: http.StatusConflict, Description: "The order is not pending, or already has a payment in progress"},
+			providerDown, billingDown,
+		},
+	})
+	api.HandleFunc("POST /api/orders/{id}/capture", h.ProxyToBilling, openapi.Operation{
+		Summary:     "Capture order payment",
+		Description: "Captures the authorized payment of an order, which makes the order paid and issues its invoice",
+		Tags:        tags,
+		Params:      []openapi.Param{orderID},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Payment captured", Type: Payment{}},
+			{Status: http.StatusAccepted, Description: "Capture pending, the result will arrive by webhook", Type: Payment{}},
+			{Status: http.StatusPaymentRequired, Description: "Capture refused", Type: Payment{}},
+			{Status: http.StatusNotFound, Description: "No payment in progress for this order"},
+			{Status: http.StatusConflict, Description: "The payment is not authorized"},
+			providerDown, billingDown,
+		},
+	})
+	api.HandleFunc("GET /api/orders/{id}/payments", h.ProxyToBilling, openapi.Operation{
+		Summary: "List order payments",
+		Tags:    tags,
+		Params:  []openapi.Param{orderID},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Payments, oldest first", Type: []Payment{}},
+			orderNotFound, billingDown,
+		},
+	})
+	api.HandleFunc("POST /api/payments/webhook", h.ProxyToBilling, openapi.Operation{
+		Summary:     "Payment provider webhook",
+		Description: `Asynchronous payment results. Requests carry X-Payment-Signature: t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">.`,
+		Tags:        []string{"Payments"},
+		Params:      []openapi.Param{{Name: "X-Payment-Signature", In: openapi.InHeader, Required: true}},
+		Body:        &openapi.Body{Required: true, Type: PaymentWebhook{}},
+		Responses: []openapi.Response{
+			{Status: http.StatusNoContent, Description: "Event applied, or already applied"},
+			{Status: http.StatusUnauthorized, Description: "Missing, invalid or expired signature"},
+			{Status: http.StatusNotFound, Description: "Unknown payment"},
+			{Status: http.StatusConflict, Description: "The payment cannot move to this status"},
+			billingDown,
+		},
+	})
+	api.HandleFunc("GET /api/users/{id}/summary", h.ProxyToBilling, openapi.Operation{
+		Summary:     "User spending summary",
+		Description: "Sums the orders of a user per currency. Exported as CSV with format=csv or an Accept header preferring text/csv.",
+		Tags:        []string{"Reports"},
+		Params:      []openapi.Param{openapi.PathParam("id", "User ID"), format("csv")},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Spending per currency", Type: UserSummary{}, Content: map[string]*openapi.Schema{"text/csv": {Type: "string"}}},
+			badFormat, billingDown,
+		},
+	})
+	api.HandleFunc("GET /api/reports/revenue", h.ProxyToBilling, openapi.Operation{
+		Summary:     "Revenue report",
+		Description: "Revenue per period and currency: orders count in the period they were paid in, refunds in the period they were refunded in. Periods are in UTC. Exported as CSV with format=csv or an Accept header preferring text/csv.",
+		Tags:        []string{"Reports"},
+		Params: []openapi.Param{
+			openapi.QueryParam("group_by", "", &openapi.Schema{Type: "string", Enum: []string{"day", "week", "month"}, Default: "month"}),
+			openapi.QueryParam("from", "Inclusive start, RFC 3339 or YYYY-MM-DD", nil),
+			openapi.QueryParam("to", "Exclusive end, RFC 3339 or YYYY-MM-DD", nil),
+			format("csv"),
+		},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Revenue per period, with totals per currency", Type: RevenueReport{}, Content: map[string]*openapi.Schema{"text/csv": {Type: "string"}}},
+			{Status: http.StatusBadRequest, Description: "Invalid group_by, from or to"},
+			badFormat, billingDown,
+		},
+	})
+}
+
+// registerBilling registers the billing requests, sent to RabbitMQ, and the
+// change stream
+func (h *Handler) registerBilling(api *openapi.Router) {
+	tags := []string{"Billing"}
+
+	api.HandleFunc("POST /api/billing", h.HandleBilling, openapi.Operation{
+		Summary:     "Process billing request",
+		Description: "Submit a billing order that will be processed asynchronously via RabbitMQ",
+		Tags:        tags,
+		Body:        &openapi.Body{Required: true, Type: BillingRequest{}},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Billing request accepted; its status URL is also given in Location", Type: BillingAccepted{}},
+			{Status: http.StatusBadRequest, Description: "Invalid request, or order not matching the billing message schema (unknown fields are dropped, not rejected)"},
+			{Status: http.StatusUnprocessableEntity, Description: "Some items reference unknown movies"},
+			{Status: http.StatusInternalServerError, Description: "The message could not be published"},
+			{Status: http.StatusServiceUnavailable, Description: "The inventory service could not be reached to check the movies"},
+		},
+	})
+	api.HandleFunc("GET /api/billing/{trackingId}", h.GetBillingStatus, openapi.Operation{
+		Summary:     "Get billing request status",
+		Description: "Whether a billing request is still queued, or was persisted or rejected by the billing service. Requests are remembered by the gateway for BILLING_TRACKING_TTL.",
+		Tags:        tags,
+		Params:      []openapi.Param{openapi.PathParam("trackingId", "tracking_id returned by POST /api/billing")},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Status of the request", Type: tracking.Entry{}},
+			{Status: http.StatusNotFound, Description: "Unknown or expired tracking ID"},
+		},
+	})
+
+	// Order and catalog changes, as server-sent events or over a WebSocket.
+	// The stream clears the write timeout of the server.
+	api.HandleFunc("GET /api/events", h.StreamEvents, openapi.Operation{
+		Summary:     "Stream order and catalog changes",
+		Description: "Server-sent events, or JSON messages over a WebSocket when the request asks for an upgrade. Each event has an id, a topic, a type (the routing key it was published with, e.g. billing.order.created or inventory.movie.updated) and the data published by the service. Movie events are public; order events need an API key from EVENTS_API_KEYS and are limited to the user of the key. A client reconnecting with the ID of the last event it received gets the ones it missed, or a resync event when they are no longer known.",
+		Tags:        []string{"Events"},
+		Params: []openapi.Param{
+			openapi.QueryParam("topics", "Comma-separated topics, by default every topic the key allows", &openapi.Schema{Type: "string", Example: "movies,orders"}),
+			openapi.QueryParam("access_token", "API key, for clients that cannot send an Authorization: Bearer header", nil),
+			{Name: "Last-Event-ID", In: openapi.InHeader, Description: "ID of the last event received, sent by EventSource when it reconnects"},
+			openapi.QueryParam("last_event_id", "Same as Last-Event-ID, for WebSocket clients", nil),
+		},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "Stream of events", Content: map[string]*openapi.Schema{"text/event-stream": {Type: "string"}}},
+			{Status: http.StatusSwitchingProtocols, Description: "Switched to a WebSocket, each message being an Event", Type: events.Event{}},
+			{Status: http.StatusBadRequest, Description: "Unknown topic"},
+			{Status: http.StatusUnauthorized, Description: "Invalid API key"},
+			{Status: http.StatusForbidden, Description: "The orders topic requires an API key"},
+		},
+	})
+}
+
+// registerGraphQL registers the GraphQL endpoint over movies and orders
+func (h *Handler) registerGraphQL(api *openapi.Router) {
+	api.HandleFunc("POST /api/graphql", h.ServeGraphQL, openapi.Operation{
+		Summary:     "GraphQL query over movies and orders",
+		Description: "Enabled by GRAPHQL_ENABLED. Queries movie, movies, order, orders and billingStatus; mutations createMovie, updateMovie, deleteMovie and submitBilling. The movies of order items are fetched from the inventory service in one batch. Errors of the upstream services are reported in errors, with their HTTP status in extensions.",
+		Tags:        []string{"GraphQL"},
+		Body:        &openapi.Body{Required: true, Type: graphQLRequest{}},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "GraphQL response, with data and any errors", Type: graphql.Result{}},
+			{Status: http.StatusBadRequest, Description: "Body is not a GraphQL request"},
+		},
+	})
+}
+
+func bound(n float64) *float64 {
+	return &n
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/api-gateway/health"
+	"github.com/n-nourdine/play-with-containers/api-gateway/openapi"
+)
+
+// register registers the routes of the gateway on a fresh router
+func register(t *testing.T, graphQL bool) *openapi.Router {
+	t.Helper()
+	api := openapi.NewRouter(http.NewServeMux(), APIInfo)
+	(&Handler{}).Register(api, health.NewChecker(time.Second), graphQL)
+	return api
+}
+
+func TestRoutesAreDocumented(t *testing.T) {
+	for _, graphQL := range []bool{false, true} {
+		if err := register(t, graphQL).Build(); err != nil {
+			t.Errorf("graphQL %v: %v", graphQL, err)
+		}
+	}
+}
+
+func TestUndocumentedRouteFailsBuild(t *testing.T) {
+	api := register(t, false)
+	api.HandleFunc("GET /api/undocumented", func(http.ResponseWriter, *http.Request) {}, openapi.Operation{})
+
+	err := api.Build()
+	if err == nil {
+		t.Fatal("Build succeeded with an undocumented route")
+	}
+	if !strings.Contains(err.Error(), "GET /api/undocumented") {
+		t.Errorf("error does not name the route: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/api-gateway/health"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// statusClient is used for health probes of the upstream services
+var statusClient = &http.Client{
+	Timeout:   3 * time.Second,
+	Transport: otelhttp.NewTransport(http.DefaultTransport),
+}
+
+// PlatformStatus reports the aggregated readiness of the gateway and of every
+// upstream service
+type PlatformStatus struct {
+	Status   string                   `json:"status" enum:"up,down"`
+	Services map[string]health.Report `json:"services"`
+}
+
+// CheckInventory verifies that the inventory service answers its liveness
+// probe, or its gRPC health check when the gRPC transport is used
+func (h *Handler) CheckInventory(ctx context.Context) error {
+	if h.inventoryConn != nil {
+		return h.checkInventoryGRPC(ctx)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.InventoryURL+"/livez", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := statusClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("inventory unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("inventory returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ServePlatformStatus returns the readiness of the whole platform: the
+// gateway's own checks plus the readiness report of each upstream service
+func (h *Handler) ServePlatformStatus(self *health.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		upstreams := map[string]string{
+			"inventory-app": h.InventoryURL,
+			"billing-app":   h.BillingURL,
+		}
+
+		status := PlatformStatus{
+			Status:   health.StatusUp,
+			Services: make(map[string]health.Report, len(upstreams)+1),
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for name, baseURL := range upstreams {
+			wg.Add(1)
+			go func(name, baseURL string) {
+				defer wg.Done()
+				report := fetchReadiness(r.Context(), baseURL)
+
+				mu.Lock()
+				status.Services[name] = report
+				mu.Unlock()
+			}(name, baseURL)
+		}
+		status.Services["api-gateway"] = self.Run(r.Context())
+		wg.Wait()
+
+		for _, report := range status.Services {
+			if report.Status != health.StatusUp {
+				status.Status = health.StatusDown
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if status.Status != health.StatusUp {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			h.Logger.WarnContext(r.Context(), "error encoding platform status", "error", err)
+		}
+	}
+}
+
+// fetchReadiness queries the /readyz endpoint of a service. An unreachable
+// service is reported as down with a single "http" check.
+func fetchReadiness(ctx context.Context, baseURL string) health.Report {
+	down := func(err error) health.Report {
+		return health.Report{
+			Status: health.StatusDown,
+			Checks: map[string]health.Result{
+				"http": {Status: health.StatusDown, Error: err.Error()},
+			},
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/readyz", nil)
+	if err != nil {
+		return down(err)
+	}
+	resp, err := statusClient.Do(req)
+	if err != nil {
+		return down(err)
+	}
+	defer resp.Body.Close()
+
+	var report health.Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return down(fmt.Errorf("invalid readiness response (status %d): %w", resp.StatusCode, err))
+	}
+	return report
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/api-gateway/metrics"
+	inventoryv1 "github.com/n-nourdine/play-with-containers/api-gateway/proto/inventory/v1"
+	"github.com/n-nourdine/play-with-containers/shared/logging"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// dialInventory returns a client of the gRPC API of the inventory service.
+// The connection is established on first use.
+func dialInventory(target string) (*grpc.ClientConn, error) {
+	conn, err := grpc.NewClient(target,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inventory gRPC target %q: %w", target, err)
+	}
+	return conn, nil
+}
+
+// checkInventoryGRPC asks the gRPC health service of the inventory service
+// whether the catalog is served
+func (h *Handler) checkInventoryGRPC(ctx context.Context) error {
+	resp, err := healthpb.NewHealthClient(h.inventoryConn).Check(ctx, &healthpb.HealthCheckRequest{
+		Service: inventoryv1.InventoryService_ServiceDesc.ServiceName,
+	})
+	if err != nil {
+		return fmt.Errorf("inventory gRPC unreachable: %w", err)
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("inventory gRPC status %s", resp.GetStatus())
+	}
+	return nil
+}
+
+// grpcStatus maps a gRPC status code to the HTTP status the REST routes of
+// the inventory service would answer
+func grpcStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// transcode calls the inventory service over gRPC for a REST request and
+// writes the response as the REST route would. call returns the value to
+// encode as JSON, or nil for an empty body.
+func (h *Handler) transcode(w http.ResponseWriter, r *http.Request, call func(ctx context.Context) (any, error)) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	if id := logging.RequestID(ctx); id != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, logging.RequestIDHeader, id)
+	}
+
+	start := time.Now()
+	v, err := call(ctx)
+	code := status.Code(err)
+	metrics.ObserveUpstream("inventory", r.Method, grpcStatus(code), start)
+
+	var reqErr *transcodeError
+	switch {
+	case errors.As(err, &reqErr):
+		http.Error(w, reqErr.msg, http.StatusBadRequest)
+		return
+	case code == codes.Unavailable:
+		h.Logger.ErrorContext(r.Context(), "upstream service unavailable", "upstream", "inventory", "error", err)
+		http.Error(w, "inventory service unavailable", http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		h.Logger.DebugContext(r.Context(), "inventory gRPC call failed", "code", code.String(), "error", err)
+		http.Error(w, status.Convert(err).Message(), grpcStatus(code))
+		return
+	}
+
+	if v == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.Logger.WarnContext(r.Context(), "error encoding response", "error", err)
+	}
+}
+
+// transcodeError is a REST request that cannot be turned into a gRPC call
+type transcodeError struct {
+	msg string
+}
+
+func (e *transcodeError) Error() string {
+	return e.msg
+}
+
+func fromProto(m *inventoryv1.Movie) Movie {
+	return Movie{ID: m.GetId(), Title: m.GetTitle(), Description: m.GetDescription()}
+}
+
+// readMovie decodes the body of POST and PUT /api/movies
+func readMovie(r *http.Request) (Movie, error) {
+	var m Movie
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return m, &transcodeError{"Error reading request body"}
+	}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return m, &transcodeError{"invalide movie"}
+	}
+	return m, nil
+}
+
+// TranscodeGetMovies serves GET /api/movies, with the title and ids filters,
+// from the ListMovies stream
+func (h *Handler) TranscodeGetMovies(w http.ResponseWriter, r *http.Request) {
+	h.transcode(w, r, func(ctx context.Context) (any, error) {
+		req := &inventoryv1.ListMoviesRequest{Title: r.URL.Query().Get("title")}
+		if ids := r.URL.Query().Get("ids"); ids != "" {
+			req.Ids = strings.Split(ids, ",")
+		}
+		stream, err := h.Inventory.ListMovies(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		movies := []Movie{}
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				return movies, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			movies = append(movies, fromProto(resp.GetMovie()))
+		}
+	})
+}
+
+// TranscodeGetMovie serves GET /api/movies/{id}
+func (h *Handler) TranscodeGetMovie(w http.ResponseWriter, r *http.Request) {
+	h.transcode(w, r, func(ctx context.Context) (any, error) {
+		resp, err := h.Inventory.GetMovie(ctx, &inventoryv1.GetMovieRequest{Id: r.PathValue("id")})
+		if err != nil {
+			return nil, err
+		}
+		return fromProto(resp.GetMovie()), nil
+	})
+}
+
+// TranscodeCreateMovie serves POST /api/movies
+func (h *Handler) TranscodeCreateMovie(w http.ResponseWriter, r *http.Request) {
+	h.transcode(w, r, func(ctx context.Context) (any, error) {
+		m, err := readMovie(r)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := h.Inventory.CreateMovie(ctx, &inventoryv1.CreateMovieRequest{Title: m.Title, Description: m.Description})
+		if err != nil {
+			return nil, err
+		}
+		return fromProto(resp.GetMovie()), nil
+	})
+}
+
+// TranscodeUpdateMovie serves PUT /api/movies/{id}
+func (h *Handler) TranscodeUpdateMovie(w http.ResponseWriter, r *http.Request) {
+	h.transcode(w, r, func(ctx context.Context) (any, error) {
+		m, err := readMovie(r)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := h.Inventory.UpdateMovie(ctx, &inventoryv1.UpdateMovieRequest{Id: r.PathValue("id"), Title: m.Title, Description: m.Description})
+		if err != nil {
+			return nil, err
+		}
+		return fromProto(resp.GetMovie()), nil
+	})
+}
+
+// TranscodeDeleteMovie serves DELETE /api/movies/{id}
+func (h *Handler) TranscodeDeleteMovie(w http.ResponseWriter, r *http.Request) {
+	h.transcode(w, r, func(ctx context.Context) (any, error) {
+		_, err := h.Inventory.DeleteMovie(ctx, &inventoryv1.DeleteMovieRequest{Id: r.PathValue("id")})
+		return nil, err
+	})
+}
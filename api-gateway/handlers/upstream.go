@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/api-gateway/money"
+)
+
+// The types below are payloads of the upstream services that the gateway
+// proxies without decoding them. They are declared for the OpenAPI document
+// and must follow the JSON of the services.
+
+// Offer is the rental or purchase price of a movie
+type Offer struct {
+	MovieID    string       `json:"movie_id" readonly:"true"`
+	Kind       string       `json:"kind" readonly:"true" enum:"rental,purchase"`
+	Price      money.Amount `json:"price" required:"true" example:"3.99"`
+	Currency   string       `json:"currency" required:"true" example:"EUR"`
+	RentalDays *int         `json:"rental_days,omitempty" min:"1" doc:"Rentals only"`
+}
+
+// Promotion discounts the offers of a movie for a period
+type Promotion struct {
+	ID         int64     `json:"id" readonly:"true"`
+	MovieID    string    `json:"movie_id" readonly:"true"`
+	Kind       string    `json:"kind,omitempty" enum:"rental,purchase" doc:"Omit to discount every offer of the movie"`
+	PercentOff int       `json:"percent_off" required:"true" min:"1" max:"100"`
+	StartsAt   time.Time `json:"starts_at" required:"true"`
+	EndsAt     time.Time `json:"ends_at" required:"true" doc:"Exclusive"`
+}
+
+// PricedOffer is an offer with the price to pay at a given time
+type PricedOffer struct {
+	Offer
+	FinalPrice money.Amount `json:"final_price" doc:"Price after the best promotion in force"`
+	Promotion  *Promotion   `json:"promotion,omitempty"`
+}
+
+// MovieOffers is the response of GET /api/movies/{id}/offers
+type MovieOffers struct {
+	MovieID string        `json:"movie_id"`
+	At      time.Time     `json:"at"`
+	Offers  []PricedOffer `json:"offers"`
+}
+
+// OrderEvent is a status change of an order
+type OrderEvent struct {
+	ID         int64     `json:"id"`
+	OrderID    string    `json:"order_id"`
+	UserID     string    `json:"user_id"`
+	From       string    `json:"from,omitempty" enum:"pending,paid,fulfilled,cancelled,refunded"`
+	To         string    `json:"to" enum:"pending,paid,fulfilled,cancelled,refunded"`
+	Reason     string    `json:"reason,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// StatusChange is the optional body of the cancel, fulfill and refund routes
+type StatusChange struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// Invoice is the invoice of a paid order
+type Invoice struct {
+	Number   string    `json:"number" doc:"Sequential invoice number, without gaps" example:"INV-000042"`
+	OrderID  string    `json:"order_id"`
+	UserID   string    `json:"user_id"`
+	IssuedAt time.Time `json:"issued_at"`
+	Currency string    `json:"currency" example:"EUR"`
+	Seller   struct {
+		Name    string `json:"name"`
+		Address string `json:"address,omitempty"`
+		TaxID   string `json:"tax_id,omitempty"`
+	} `json:"seller"`
+	PricesIncludeTax bool          `json:"prices_include_tax" doc:"Whether line prices include tax; subtotal is always before tax"`
+	Lines            []InvoiceLine `json:"lines"`
+	Subtotal         money.Amount  `json:"subtotal" example:"14.97"`
+	TaxLines         []TaxLine     `json:"tax_lines"`
+	TaxTotal         money.Amount  `json:"tax_total" example:"0.00"`
+	Total            money.Amount  `json:"total" example:"14.97"`
+}
+
+type InvoiceLine struct {
+	Description string       `json:"description"`
+	MovieID     string       `json:"movie_id,omitempty"`
+	Kind        string       `json:"kind,omitempty" enum:"rental,purchase"`
+	Quantity    int          `json:"quantity"`
+	UnitPrice   money.Amount `json:"unit_price" example:"4.99"`
+	Amount      money.Amount `json:"amount" example:"9.98"`
+}
+
+type TaxLine struct {
+	Name   string       `json:"name"`
+	Rate   string       `json:"rate" doc:"Rate in percent" example:"20"`
+	Base   money.Amount `json:"base"`
+	Amount money.Amount `json:"amount"`
+}
+
+// Payment is a payment of an order at the payment provider
+type Payment struct {
+	ID        string       `json:"id"`
+	OrderID   string       `json:"order_id"`
+	Provider  string       `json:"provider" example:"fake"`
+	Status    string       `json:"status" enum:"pending,authorized,captured,refunded,voided,failed"`
+	Amount    money.Amount `json:"amount" example:"14.99"`
+	Currency  string       `json:"currency" example:"EUR"`
+	Reason    string       `json:"reason,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// PayRequest is the optional body of POST /api/orders/{id}/pay
+type PayRequest struct {
+	PaymentMethod string `json:"payment_method,omitempty" doc:"Means of payment; the fake provider declines fake_declined and answers fake_async by webhook" example:"card"`
+}
+
+// PaymentWebhook is an asynchronous payment result sent by the provider
+type PaymentWebhook struct {
+	ID        string `json:"id" required:"true"`
+	PaymentID string `json:"payment_id" required:"true"`
+	Status    string `json:"status" required:"true" enum:"pending,authorized,captured,refunded,voided,failed"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// UserSpending sums the orders of a user in one currency
+type UserSpending struct {
+	Currency     string       `json:"currency" example:"EUR"`
+	Orders       int64        `json:"orders"`
+	PaidOrders   int64        `json:"paid_orders"`
+	Spent        money.Amount `json:"spent"`
+	TaxPaid      money.Amount `json:"tax_paid"`
+	Refunded     money.Amount `json:"refunded"`
+	Outstanding  money.Amount `json:"outstanding"`
+	FirstOrderAt time.Time    `json:"first_order_at"`
+	LastOrderAt  time.Time    `json:"last_order_at"`
+}
+
+type UserSummary struct {
+	UserID     string         `json:"user_id"`
+	Currencies []UserSpending `json:"currencies"`
+}
+
+// RevenueTotal is the revenue of a currency over a period
+type RevenueTotal struct {
+	Currency   string       `json:"currency" example:"EUR"`
+	PaidOrders int64        `json:"paid_orders"`
+	Net        money.Amount `json:"net"`
+	Tax        money.Amount `json:"tax"`
+	Gross      money.Amount `json:"gross"`
+	Refunds    int64        `json:"refunds"`
+	Refunded   money.Amount `json:"refunded"`
+	Revenue    money.Amount `json:"revenue"`
+}
+
+type RevenuePeriod struct {
+	Period time.Time `json:"period"`
+	RevenueTotal
+}
+
+type RevenueReport struct {
+	GroupBy string          `json:"group_by" enum:"day,week,month"`
+	From    *time.Time      `json:"from,omitempty"`
+	To      *time.Time      `json:"to,omitempty"`
+	Periods []RevenuePeriod `json:"periods"`
+	Totals  []RevenueTotal  `json:"totals"`
+}
@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Check reports whether a dependency is usable. A nil error means healthy.
+type Check func(ctx context.Context) error
+
+// Result is the outcome of a single dependency check
+type Result struct {
+	Status    string  `json:"status" enum:"up,down"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report aggregates the results of every registered check
+type Report struct {
+	Status string            `json:"status" enum:"up,down"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs the readiness checks of a service
+type Checker struct {
+	timeout time.Duration
+	checks  []namedCheck
+}
+
+// NewChecker creates a checker whose checks each get at most timeout to run
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a named dependency check
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Run executes all checks concurrently and reports the overall status
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{Status: StatusUp, Checks: make(map[string]Result, len(c.checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, nc := range c.checks {
+		wg.Add(1)
+		go func(nc namedCheck) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := nc.check(ctx)
+			res := Result{
+				Status:    StatusUp,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				res.Status = StatusDown
+				res.Error = err.Error()
+			}
+
+			mu.Lock()
+			report.Checks[nc.name] = res
+			if err != nil {
+				report.Status = StatusDown
+			}
+			mu.Unlock()
+		}(nc)
+	}
+	wg.Wait()
+
+	return report
+}
+
+// Live answers the liveness probe: the process is up and serving HTTP
+func (c *Checker) Live(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, Report{Status: StatusUp})
+}
+
+// Ready answers the readiness probe with the status of every dependency,
+// returning 503 when any of them is down
+func (c *Checker) Ready(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, c.Run(r.Context()))
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != StatusUp {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
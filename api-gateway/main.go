@@ -3,71 +3,102 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/n-nourdine/play-with-containers/api-gateway/config"
 	"github.com/n-nourdine/play-with-containers/api-gateway/handlers"
+	"github.com/n-nourdine/play-with-containers/api-gateway/health"
 	"github.com/n-nourdine/play-with-containers/api-gateway/middleware"
+	"github.com/n-nourdine/play-with-containers/api-gateway/openapi"
+	"github.com/n-nourdine/play-with-containers/api-gateway/tracing"
+	"github.com/n-nourdine/play-with-containers/shared/logging"
 )
 
 func main() {
-	logger := log.New(os.Stdout, fmt.Sprintf("api-gateway running on port %s -> ",
-		os.Getenv("API_GATEWAY_PORT")), log.LstdFlags)
+	cfg, err := config.Load()
+	if err != nil {
+		logging.New("api-gateway", "info").Error("failed to load configuration", "error", err)
+		os.Exit(1)
+	}
+
+	logger := logging.New("api-gateway", cfg.LogLevel)
+	logger.Info("configuration loaded", "config", cfg.Redacted())
+
+	// Set up distributed tracing
+	shutdownTracing, err := tracing.Init(context.Background(), "api-gateway", cfg.Tracing)
+	if err != nil {
+		logger.Error("failed to initialize tracing", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("tracing shutdown error", "error", err)
+		}
+	}()
 
 	// Create handlers
-	h, err := handlers.NewHandler(logger)
+	h, err := handlers.NewHandler(logger, cfg)
 	if err != nil {
-		logger.Fatalf("Failed to create handlers: %v", err)
+		logger.Error("failed to create handlers", "error", err)
+		os.Exit(1)
 	}
 	defer h.Close()
 
 	// Create HTTP multiplexer
 	mux := http.NewServeMux()
 
-	// Health check endpoint
-	mux.HandleFunc("GET /api/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("API Gateway is healthy"))
-	})
-
-	// Inventory API routes - proxy all /api/movies requests to inventory service
-	mux.HandleFunc("GET /api/movies", h.ProxyToInventory)
-	mux.HandleFunc("GET /api/movies/{id}", h.ProxyToInventory)
-	mux.HandleFunc("POST /api/movies", h.ProxyToInventory)
-	mux.HandleFunc("PUT /api/movies/{id}", h.ProxyToInventory)
-	mux.HandleFunc("DELETE /api/movies/{id}", h.ProxyToInventory)
-	mux.HandleFunc("DELETE /api/movies", h.ProxyToInventory)
-
-	// Billing API route - send messages to RabbitMQ
-	mux.HandleFunc("POST /api/billing", h.HandleBilling)
-
-	// Serve OpenAPI documentation
-	mux.HandleFunc("GET /api/docs", h.ServeOpenAPIDoc)
-	mux.HandleFunc("GET /", h.ServeSwaggerUI)
+	// Readiness depends on RabbitMQ and on the inventory upstream
+	checker := health.NewChecker(2 * time.Second)
+	checker.Add("rabbitmq", h.Publisher.Ping)
+	checker.Add("rabbitmq-outcomes", h.Outcomes.Ping)
+	checker.Add("rabbitmq-changes", h.Changes.Ping)
+	checker.Add("inventory", h.CheckInventory)
+
+	// Routes are registered with their documentation, which must be
+	// complete for the gateway to start
+	api := openapi.NewRouter(mux, handlers.APIInfo)
+	h.Register(api, checker, cfg.GraphQL.Enabled)
+	if err := api.Build(); err != nil {
+		logger.Error("invalid API documentation", "error", err)
+		os.Exit(1)
+	}
+	if cfg.OpenAPI.Validation != "off" {
+		api.Validate(logger, cfg.OpenAPI.Validation == "debug")
+	}
 
 	// Apply middleware
-	handler := middleware.LoggingMiddleware(logger)(
-		middleware.CORSMiddleware()(mux))
+	handler := middleware.TracingMiddleware("api-gateway")(
+		middleware.RequestIDMiddleware()(
+			middleware.LoggingMiddleware(logger)(
+				middleware.MetricsMiddleware()(
+					middleware.CORSMiddleware()(
+						middleware.RouteMiddleware()(mux))))))
 
 	// Create HTTP server
 	server := &http.Server{
-		Addr:         fmt.Sprintf(":%v", os.Getenv("API_GATEWAY_PORT")),
+		Addr:         fmt.Sprintf(":%v", cfg.Port),
 		Handler:      handler,
-		ErrorLog:     logger,
+		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	// End the event streams, which would otherwise hold up the shutdown
+	server.RegisterOnShutdown(h.Events.Close)
 
 	// Start server in goroutine
 	go func() {
-		logger.Printf("Starting API Gateway on port %s", os.Getenv("API_GATEWAY_PORT"))
+		logger.Info("starting API Gateway", "port", cfg.Port)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatalf("HTTP server error: %v", err)
+			logger.Error("HTTP server error", "error", err)
+			os.Exit(1)
 		}
 	}()
 
@@ -76,15 +107,15 @@ func main() {
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	sig := <-c
-	logger.Printf("Received signal: %v", sig)
+	logger.Info("received signal", "signal", sig.String())
 
 	// Shutdown server gracefully
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := server.Shutdown(shutdownCtx); err != nil {
-		logger.Printf("Server shutdown error: %v", err)
+		logger.Error("server shutdown error", "error", err)
 	}
 
-	logger.Println("API Gateway stopped gracefully")
+	logger.Info("API Gateway stopped gracefully")
 }
@@ -0,0 +1,130 @@
+package message
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+
+	"github.com/hamba/avro/v2"
+)
+
+// The Avro types are generated from the .avsc schemas
+//
+//go:generate go tool avrogen -pkg message -o avro_gen.go -tags json:snake schemas/billing.order.created.v1.avsc
+
+// avroValues returns a new generated value for each Avro schema
+var avroValues = map[schemaKey]func() any{
+	{OrderCreated, 1}: func() any { return new(OrderCreatedV1) },
+}
+
+// avroSchemas holds the parsed Avro schema of every type and version that
+// has one; version 0 has none
+var avroSchemas = parseAvroSchemas()
+
+// SupportsAvro reports whether messages of the type and version can be
+// encoded in Avro
+func SupportsAvro(typ string, version int) bool {
+	_, ok := avroSchemas[schemaKey{typ, version}]
+	return ok
+}
+
+// ToAvro converts a message in JSON, as returned by Encode, to Avro
+func ToAvro(typ string, version int, body []byte) ([]byte, error) {
+	schema, v, err := avroFor(typ, version)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	b, err := avro.Marshal(schema, v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return b, nil
+}
+
+// FromAvro converts a message in Avro to JSON, to be read by Decode. Avro
+// has no absent fields: empty strings and arrays are left out.
+func FromAvro(typ string, version int, body []byte) ([]byte, error) {
+	schema, v, err := avroFor(typ, version)
+	if err != nil {
+		return nil, err
+	}
+	if err := avro.Unmarshal(schema, body, v); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return json.Marshal(dropEmpty(doc))
+}
+
+func avroFor(typ string, version int) (avro.Schema, any, error) {
+	key := schemaKey{typ, version}
+	schema, ok := avroSchemas[key]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s version %d in Avro", ErrUnsupportedVersion, typ, version)
+	}
+	return schema, avroValues[key](), nil
+}
+
+// dropEmpty removes the empty strings and arrays of a JSON document
+func dropEmpty(doc any) any {
+	switch v := doc.(type) {
+	case map[string]any:
+		for k, field := range v {
+			switch f := field.(type) {
+			case string:
+				if f == "" {
+					delete(v, k)
+				}
+			case []any:
+				if len(f) == 0 {
+					delete(v, k)
+				}
+			}
+			if _, ok := v[k]; ok {
+				v[k] = dropEmpty(field)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = dropEmpty(item)
+		}
+	}
+	return doc
+}
+
+func parseAvroSchemas() map[schemaKey]avro.Schema {
+	names, err := fs.Glob(schemaFiles, "schemas/*.avsc")
+	if err != nil {
+		panic(err)
+	}
+	parsed := make(map[schemaKey]avro.Schema, len(names))
+	for _, name := range names {
+		key := parseSchemaName(name)
+		if _, ok := avroValues[key]; !ok {
+			panic(fmt.Sprintf("message: no generated type for %s", name))
+		}
+		b, err := schemaFiles.ReadFile(name)
+		if err != nil {
+			panic(err)
+		}
+		schema, err := avro.ParseBytesWithCache(b, "", &avro.SchemaCache{})
+		if err != nil {
+			panic(fmt.Sprintf("message: schema %s: %v", name, err))
+		}
+		parsed[key] = schema
+	}
+	return parsed
+}
@@ -0,0 +1,33 @@
+// Code generated by avro/gen. DO NOT EDIT.
+package message
+
+import (
+	"time"
+)
+
+// OrderItemV1 is a generated struct.
+type OrderItemV1 struct {
+	MovieID   string `avro:"movie_id" json:"movie_id"`
+	Kind      string `avro:"kind" json:"kind"`
+	Quantity  int    `avro:"quantity" json:"quantity"`
+	UnitPrice string `avro:"unit_price" json:"unit_price"`
+}
+
+// OrderV1 is a generated struct.
+type OrderV1 struct {
+	UserID        string        `avro:"user_id" json:"user_id"`
+	NumberOfItems string        `avro:"number_of_items" json:"number_of_items"`
+	TotalAmount   string        `avro:"total_amount" json:"total_amount"`
+	Currency      string        `avro:"currency" json:"currency"`
+	TaxRegion     string        `avro:"tax_region" json:"tax_region"`
+	Items         []OrderItemV1 `avro:"items" json:"items"`
+}
+
+// billing.order.created, version 1, in Avro. Mirrors billing.order.created.v1.json; optional strings are empty when absent.
+type OrderCreatedV1 struct {
+	Type       string    `avro:"type" json:"type"`
+	Version    int       `avro:"version" json:"version"`
+	ID         string    `avro:"id" json:"id"`
+	OccurredAt time.Time `avro:"occurred_at" json:"occurred_at"`
+	Payload    OrderV1   `avro:"payload" json:"payload"`
+}
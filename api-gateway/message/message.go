@@ -0,0 +1,137 @@
+// Package message defines the contract of the billing messages exchanged
+// through RabbitMQ: a versioned envelope whose content is checked against the
+// JSON Schema of its type and version.
+//
+// The billing service holds a copy of this package; both must list the same
+// schemas. Consumers accept every version they have a schema for, so a new
+// version is rolled out by first deploying consumers that know it, then
+// switching the publisher to it.
+package message
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// Message types
+const (
+	OrderCreated = "billing.order.created"
+)
+
+const (
+	// VersionLegacy is the bare payload published before messages had an
+	// envelope
+	VersionLegacy = 0
+	// CurrentVersion is the latest envelope version
+	CurrentVersion = 1
+)
+
+// VersionHeader is the AMQP header carrying the version of a message; the
+// type is carried by the AMQP type property
+const VersionHeader = "x-message-version"
+
+var (
+	// ErrMalformed means the message is not JSON
+	ErrMalformed = errors.New("malformed message")
+	// ErrUnsupportedVersion means no schema is known for the type and
+	// version of the message
+	ErrUnsupportedVersion = errors.New("unsupported message version")
+	// ErrInvalid means the message does not match its schema
+	ErrInvalid = errors.New("message does not match its schema")
+)
+
+// Envelope wraps the payload of a message with what identifies it. A legacy
+// message is decoded as an envelope of version 0 whose payload is the whole
+// message.
+type Envelope struct {
+	Type       string          `json:"type"`
+	Version    int             `json:"version"`
+	ID         string          `json:"id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// Encode wraps payload in a new envelope of the given type and version and
+// returns the envelope and the message to publish, after checking it against
+// its schema. Version 0 publishes the bare payload.
+func Encode(typ string, version int, payload any) (Envelope, []byte, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return Envelope{}, nil, fmt.Errorf("encoding %s payload: %w", typ, err)
+	}
+	env := Envelope{
+		Type:       typ,
+		Version:    version,
+		ID:         uuid.NewString(),
+		OccurredAt: time.Now().UTC(),
+		Payload:    raw,
+	}
+
+	body := raw
+	if version != VersionLegacy {
+		if body, err = json.Marshal(env); err != nil {
+			return Envelope{}, nil, fmt.Errorf("encoding %s envelope: %w", typ, err)
+		}
+	}
+	if err := validate(typ, version, body); err != nil {
+		return Envelope{}, nil, err
+	}
+	return env, body, nil
+}
+
+// Decode reads a message, enveloped or legacy, after checking it against the
+// schema of its type and version. Legacy messages carry no type and are
+// taken as fallbackType.
+func Decode(body []byte, fallbackType string) (Envelope, error) {
+	var head struct {
+		Type    *string         `json:"type"`
+		Version *int            `json:"version"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &head); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return Envelope{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		// A legacy payload may use these names for its own fields
+		head.Type, head.Version, head.Payload = nil, nil, nil
+	}
+
+	if head.Type == nil || head.Version == nil || head.Payload == nil {
+		if err := validate(fallbackType, VersionLegacy, body); err != nil {
+			return Envelope{}, err
+		}
+		return Envelope{Type: fallbackType, Version: VersionLegacy, Payload: body}, nil
+	}
+
+	if err := validate(*head.Type, *head.Version, body); err != nil {
+		return Envelope{}, err
+	}
+	var env Envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return Envelope{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return env, nil
+}
+
+// validate checks a message against the schema of its type and version
+func validate(typ string, version int, body []byte) error {
+	schema, ok := schemas[schemaKey{typ, version}]
+	if !ok {
+		return fmt.Errorf("%w: %s version %d", ErrUnsupportedVersion, typ, version)
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if err := schema.Validate(doc); err != nil {
+		return fmt.Errorf("%w: %s version %d: %v", ErrInvalid, typ, version, err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package message
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// The schemas are named <type>.v<version>.json, and the Avro schemas
+// <type>.v<version>.avsc
+//
+//go:embed schemas/*.json schemas/*.avsc
+var schemaFiles embed.FS
+
+var schemaName = regexp.MustCompile(`^(.+)\.v(\d+)\.(json|avsc)$`)
+
+// schemaBase locates the embedded schemas, so that the references between
+// them resolve without touching the file system
+const schemaBase = "file:///schemas/"
+
+type schemaKey struct {
+	typ     string
+	version int
+}
+
+// schemas holds the compiled schema of every known type and version
+var schemas = compileSchemas()
+
+// Versions lists the versions known for a message type, oldest first
+func Versions(typ string) []int {
+	var versions []int
+	for k := range schemas {
+		if k.typ == typ {
+			versions = append(versions, k.version)
+		}
+	}
+	slices.Sort(versions)
+	return versions
+}
+
+// Supports reports whether a schema is known for the type and version
+func Supports(typ string, version int) bool {
+	_, ok := schemas[schemaKey{typ, version}]
+	return ok
+}
+
+func compileSchemas() map[schemaKey]*jsonschema.Schema {
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat()
+
+	names, err := fs.Glob(schemaFiles, "schemas/*.json")
+	if err != nil {
+		panic(err)
+	}
+	keys := make(map[string]schemaKey, len(names))
+	for _, name := range names {
+		base := name[len("schemas/"):]
+		keys[base] = parseSchemaName(name)
+
+		b, err := schemaFiles.ReadFile(name)
+		if err != nil {
+			panic(err)
+		}
+		doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(b))
+		if err != nil {
+			panic(fmt.Sprintf("message: schema %s: %v", name, err))
+		}
+		if err := compiler.AddResource(schemaBase+base, doc); err != nil {
+			panic(fmt.Sprintf("message: schema %s: %v", name, err))
+		}
+	}
+
+	compiled := make(map[schemaKey]*jsonschema.Schema, len(keys))
+	for base, key := range keys {
+		compiled[key] = compiler.MustCompile(schemaBase + base)
+	}
+	return compiled
+}
+
+// parseSchemaName returns the type and version of a schema file
+func parseSchemaName(name string) schemaKey {
+	m := schemaName.FindStringSubmatch(path.Base(name))
+	if m == nil {
+		panic(fmt.Sprintf("message: schema file %s is not named <type>.v<version>.json or .avsc", name))
+	}
+	version, _ := strconv.Atoi(m[2])
+	return schemaKey{m[1], version}
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// HTTPRequests counts handled requests by method, route pattern and status
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "api_gateway",
+		Name:      "http_requests_total",
+		Help:      "Total number of HTTP requests handled by the gateway.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPDuration observes request latency by method, route pattern and status
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "api_gateway",
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests handled by the gateway.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// UpstreamDuration observes the latency of proxied calls to backend services
+	UpstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "api_gateway",
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Latency of requests proxied to upstream services.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"upstream", "method", "status"})
+
+	// AMQPPublished counts messages successfully published to RabbitMQ, by
+	// routing key
+	AMQPPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "api_gateway",
+		Name:      "amqp_messages_published_total",
+		Help:      "Total number of messages published to RabbitMQ.",
+	}, []string{"routing_key"})
+
+	// AMQPPublishFailures counts messages that could not be published
+	AMQPPublishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "api_gateway",
+		Name:      "amqp_publish_failures_total",
+		Help:      "Total number of failed publish attempts to RabbitMQ.",
+	}, []string{"routing_key"})
+
+	// EventStreams counts the clients connected to GET /api/events, by
+	// transport (sse or websocket)
+	EventStreams = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "api_gateway",
+		Name:      "event_streams",
+		Help:      "Number of clients streaming events.",
+	}, []string{"transport"})
+
+	// EventStreamsDropped counts the clients cut off for not reading their
+	// events fast enough
+	EventStreamsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "api_gateway",
+		Name:      "event_streams_dropped_total",
+		Help:      "Total number of event streams closed because the client was too slow.",
+	}, []string{"transport"})
+
+	// AMQPUnroutable counts messages returned by the broker because no queue
+	// was bound for their routing key
+	AMQPUnroutable = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "api_gateway",
+		Name:      "amqp_messages_unroutable_total",
+		Help:      "Total number of published messages no queue was bound for.",
+	}, []string{"routing_key"})
+)
+
+// Handler exposes the registered metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveUpstream records the duration of an upstream call. A status of 0
+// means the upstream could not be reached.
+func ObserveUpstream(upstream, method string, status int, start time.Time) {
+	label := "error"
+	if status != 0 {
+		label = strconv.Itoa(status)
+	}
+	UpstreamDuration.WithLabelValues(upstream, method, label).Observe(time.Since(start).Seconds())
+}
@@ -1,13 +1,43 @@
 package middleware
 
 import (
-	"log"
+	"bufio"
+	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/n-nourdine/play-with-containers/api-gateway/metrics"
+	"github.com/n-nourdine/play-with-containers/shared/logging"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// RequestIDMiddleware propagates the X-Request-ID header, generating one when
+// the client did not send a usable value. The ID is echoed in the response,
+// kept on the request headers so proxied calls forward it, and stored in the
+// request context for logging.
+func RequestIDMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(logging.RequestIDHeader)
+			if id == "" || len(id) > 128 {
+				id = uuid.NewString()
+			}
+
+			r.Header.Set(logging.RequestIDHeader, id)
+			w.Header().Set(logging.RequestIDHeader, id)
+
+			next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+		})
+	}
+}
+
 // LoggingMiddleware logs all incoming requests
-func LoggingMiddleware(logger *log.Logger) func(http.Handler) http.Handler {
+func LoggingMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
@@ -23,17 +53,71 @@ func LoggingMiddleware(logger *log.Logger) func(http.Handler) http.Handler {
 
 			// Log the request
 			duration := time.Since(start)
-			logger.Printf("%s %s %d %v %s",
-				r.Method,
-				r.URL.Path,
-				wrapped.statusCode,
-				duration,
-				r.RemoteAddr,
+			logger.InfoContext(r.Context(), "request completed",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", wrapped.statusCode,
+				"duration_ms", duration.Milliseconds(),
+				"remote_addr", r.RemoteAddr,
 			)
 		})
 	}
 }
 
+// MetricsMiddleware records request counts and latencies by route and status
+func MetricsMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			wrapped := &responseWriter{
+				ResponseWriter: w,
+				statusCode:     http.StatusOK,
+			}
+
+			next.ServeHTTP(wrapped, r)
+
+			// The mux fills in the matched pattern; keep cardinality bounded
+			// by grouping everything it did not match.
+			route := r.Pattern
+			if route == "" {
+				route = "unmatched"
+			}
+			status := strconv.Itoa(wrapped.statusCode)
+
+			metrics.HTTPRequests.WithLabelValues(r.Method, route, status).Inc()
+			metrics.HTTPDuration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// TracingMiddleware starts a server span for every request, continuing any
+// trace context received in the W3C traceparent header. The span is named
+// after the route by RouteMiddleware.
+func TracingMiddleware(service string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return otelhttp.NewHandler(next, service)
+	}
+}
+
+// RouteMiddleware names the span of the request after the pattern matched by
+// the mux. It must wrap the mux directly: the mux sets the pattern on the
+// request it is given, and middleware such as RequestIDMiddleware pass copies
+// of the request on, so the pattern never reaches TracingMiddleware.
+func RouteMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+
+			if r.Pattern != "" {
+				span := trace.SpanFromContext(r.Context())
+				span.SetName(r.Pattern)
+				span.SetAttributes(attribute.String("http.route", r.Pattern))
+			}
+		})
+	}
+}
+
 // CORSMiddleware adds CORS headers
 func CORSMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -41,7 +125,8 @@ func CORSMiddleware() func(http.Handler) http.Handler {
 			// Set CORS headers
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Confirm-Delete")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Confirm-Delete, X-Request-ID")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 			w.Header().Set("Access-Control-Max-Age", "86400")
 
 			// Handle preflight requests
@@ -65,3 +150,15 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap gives http.ResponseController access to the flushing and hijacking
+// of the underlying writer, for streamed responses and WebSockets
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Hijack hands the connection over for WebSockets, whose upgrader needs the
+// writer itself to be an http.Hijacker
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(rw.ResponseWriter).Hijack()
+}
@@ -0,0 +1,100 @@
+// Package money represents amounts of money exactly, as integer counts of
+// minor units (cents), so that totals never suffer from float rounding.
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Amount is a sum of money in minor units: Amount(1250) is 12.50.
+// It is written as a decimal string, e.g. "12.50", in JSON.
+type Amount int64
+
+const scale = 100
+
+var (
+	ErrInvalid  = errors.New("invalid amount")
+	ErrOverflow = errors.New("amount too large")
+)
+
+// Parse reads a decimal amount with at most two fractional digits, such as
+// "12", "12.5" or "-3.99"
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || len(frac) > 2 || !digits(whole) || !digits(frac) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalid, s)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || w > (math.MaxInt64-99)/scale {
+		return 0, fmt.Errorf("%w: %q", ErrOverflow, s)
+	}
+	f, _ := strconv.ParseInt(frac, 10, 64)
+
+	a := Amount(w*scale + f)
+	if neg {
+		a = -a
+	}
+	return a, nil
+}
+
+func digits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the amount with exactly two fractional digits
+func (a Amount) String() string {
+	sign := ""
+	v := int64(a)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/scale, v%scale)
+}
+
+// Mul returns a*n, or ErrOverflow
+func (a Amount) Mul(n int64) (Amount, error) {
+	if n != 0 && (int64(a) > math.MaxInt64/n || int64(a) < math.MinInt64/n) {
+		return 0, ErrOverflow
+	}
+	return a * Amount(n), nil
+}
+
+// Add returns a+b, or ErrOverflow
+func (a Amount) Add(b Amount) (Amount, error) {
+	if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+		return 0, ErrOverflow
+	}
+	return a + b, nil
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON accepts both "12.50" and 12.50
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
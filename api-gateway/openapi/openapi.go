@@ -0,0 +1,385 @@
+// Package openapi documents the routes of the gateway as they are
+// registered. Each route is given with its operation, the parameters,
+// request body and responses it accepts and returns, and the OpenAPI 3.0
+// document is derived from these and from the Go types of the bodies.
+//
+// A route registered without documentation, or whose path parameters do not
+// match its pattern, makes Build fail, so the document cannot fall behind
+// the routes. Once Validate is called, requests are also checked against
+// the document before reaching their handler.
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Locations of a parameter
+const (
+	InPath   = "path"
+	InQuery  = "query"
+	InHeader = "header"
+)
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Operation documents a route
+type Operation struct {
+	Summary     string
+	Description string
+	Tags        []string
+	Params      []Param
+	Body        *Body
+	Responses   []Response
+}
+
+// Param is a parameter of an operation
+type Param struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	// Schema defaults to a string
+	Schema *Schema
+}
+
+// PathParam is a string parameter of the path
+func PathParam(name, description string) Param {
+	return Param{Name: name, In: InPath, Description: description, Required: true}
+}
+
+// QueryParam is an optional parameter of the query string
+func QueryParam(name, description string, schema *Schema) Param {
+	return Param{Name: name, In: InQuery, Description: description, Schema: schema}
+}
+
+// Body is the JSON request body of an operation
+type Body struct {
+	Description string
+	Required    bool
+	// Type is a value of the Go type the body decodes to
+	Type any
+}
+
+// Response is a possible response of an operation
+type Response struct {
+	Status      int
+	Description string
+	// Type is a value of the Go type of the JSON body, if any
+	Type any
+	// Content gives the schema of bodies of other media types. Error
+	// responses without a body are documented as text/plain, as written by
+	// http.Error.
+	Content map[string]*Schema
+}
+
+// route is a registered route with its documentation
+type route struct {
+	method string
+	path   string
+	op     Operation
+
+	// doc is the operation in the document, set by Build
+	doc *operation
+}
+
+// Router registers the routes of the gateway on a ServeMux and builds their
+// OpenAPI document
+type Router struct {
+	mux    *http.ServeMux
+	info   Info
+	routes []*route
+	errs   []error
+
+	// validation is set when requests are checked against the document
+	validation *validation
+
+	schemas *schemas
+	docJSON []byte
+	docYAML []byte
+}
+
+func NewRouter(mux *http.ServeMux, info Info) *Router {
+	return &Router{mux: mux, info: info, schemas: newSchemas()}
+}
+
+// Handle registers a route, given as a ServeMux pattern with a method, with
+// its documentation
+func (r *Router) Handle(pattern string, handler http.Handler, op Operation) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok || !strings.HasPrefix(path, "/") {
+		r.errs = append(r.errs, fmt.Errorf("route %q: the pattern must be a method and a path", pattern))
+		r.mux.Handle(pattern, handler)
+		return
+	}
+
+	rt := &route{method: strings.ToLower(method), path: path, op: op}
+	r.routes = append(r.routes, rt)
+	r.mux.Handle(pattern, &validated{router: r, route: rt, next: handler})
+}
+
+func (r *Router) HandleFunc(pattern string, handler http.HandlerFunc, op Operation) {
+	r.Handle(pattern, handler, op)
+}
+
+// Define sets the schema of a Go type whose JSON encoding differs from its
+// definition, given by a value of the type
+func (r *Router) Define(v any, schema *Schema) {
+	r.schemas.defined[reflect.TypeOf(v)] = schema
+}
+
+// Component names and describes the component of a struct type, which is
+// otherwise named after the type
+func (r *Router) Component(name string, v any, description string) {
+	t := reflect.TypeOf(v)
+	r.schemas.names[t] = name
+	r.schemas.docs[t] = description
+}
+
+// Build checks that every route is documented and renders the document
+// served by ServeJSON and ServeYAML. It is called once every route is
+// registered.
+func (r *Router) Build() error {
+	doc, err := r.document()
+	if err != nil {
+		return err
+	}
+
+	if r.docJSON, err = json.MarshalIndent(doc, "", "  "); err != nil {
+		return fmt.Errorf("encoding OpenAPI document: %w", err)
+	}
+	if r.docYAML, err = toYAML(r.docJSON); err != nil {
+		return fmt.Errorf("encoding OpenAPI document as YAML: %w", err)
+	}
+	return nil
+}
+
+// ServeJSON serves the OpenAPI document as JSON
+func (r *Router) ServeJSON(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(r.docJSON)
+}
+
+// ServeYAML serves the OpenAPI document as YAML
+func (r *Router) ServeYAML(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(r.docYAML)
+}
+
+type document struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       Info                            `json:"info"`
+	Servers    []server                        `json:"servers"`
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components struct {
+		Schemas map[string]*Schema `json:"schemas,omitempty"`
+	} `json:"components"`
+}
+
+type server struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+type operation struct {
+	Summary     string              `json:"summary"`
+	Description string              `json:"description,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []parameter         `json:"parameters,omitempty"`
+	RequestBody *requestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]response `json:"responses"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type requestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// document checks the routes and derives their document
+func (r *Router) document() (*document, error) {
+	errs := slices.Clone(r.errs)
+	doc := &document{
+		OpenAPI: "3.0.3",
+		Info:    r.info,
+		// Relative to the URL the document is served from, whichever host
+		// the gateway is reached on
+		Servers: []server{{URL: "/", Description: "This gateway"}},
+		Paths:   make(map[string]map[string]operation),
+	}
+
+	for _, rt := range r.routes {
+		op, err := r.operation(rt)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("route %q: %w", strings.ToUpper(rt.method)+" "+rt.path, err))
+			continue
+		}
+		if doc.Paths[rt.path] == nil {
+			doc.Paths[rt.path] = make(map[string]operation)
+		}
+		doc.Paths[rt.path][rt.method] = op
+		rt.doc = &op
+	}
+
+	errs = append(errs, r.schemas.errs...)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	doc.Components.Schemas = make(map[string]*Schema, len(r.schemas.components))
+	for name, c := range r.schemas.components {
+		doc.Components.Schemas[name] = c.schema
+	}
+	return doc, nil
+}
+
+func (r *Router) operation(rt *route) (operation, error) {
+	if rt.op.Summary == "" || len(rt.op.Responses) == 0 {
+		return operation{}, errors.New("not documented: a summary and the responses are required")
+	}
+	if err := checkPathParams(rt.path, rt.op.Params); err != nil {
+		return operation{}, err
+	}
+
+	op := operation{
+		Summary:     rt.op.Summary,
+		Description: rt.op.Description,
+		Tags:        rt.op.Tags,
+		Responses:   make(map[string]response, len(rt.op.Responses)),
+	}
+
+	for _, p := range rt.op.Params {
+		schema := p.Schema
+		if schema == nil {
+			schema = &Schema{Type: "string"}
+		}
+		op.Parameters = append(op.Parameters, parameter{
+			Name:        p.Name,
+			In:          p.In,
+			Description: p.Description,
+			Required:    p.Required,
+			Schema:      schema,
+		})
+	}
+
+	if b := rt.op.Body; b != nil {
+		op.RequestBody = &requestBody{
+			Description: b.Description,
+			Required:    b.Required,
+			Content:     map[string]mediaType{"application/json": {Schema: r.schemas.of(b.Type)}},
+		}
+	}
+
+	for _, resp := range rt.op.Responses {
+		status := strconv.Itoa(resp.Status)
+		if _, ok := op.Responses[status]; ok {
+			return operation{}, fmt.Errorf("response %s is documented twice", status)
+		}
+		if resp.Description == "" {
+			return operation{}, fmt.Errorf("response %s has no description", status)
+		}
+
+		out := response{Description: resp.Description, Content: make(map[string]mediaType)}
+		if resp.Type != nil {
+			out.Content["application/json"] = mediaType{Schema: r.schemas.of(resp.Type)}
+		}
+		for media, schema := range resp.Content {
+			out.Content[media] = mediaType{Schema: schema}
+		}
+		if len(out.Content) == 0 && resp.Status >= 400 {
+			out.Content["text/plain"] = mediaType{Schema: &Schema{Type: "string"}}
+		}
+		op.Responses[status] = out
+	}
+	return op, nil
+}
+
+// checkPathParams verifies that the path parameters documented are those of
+// the pattern
+func checkPathParams(path string, params []Param) error {
+	var want []string
+	for _, seg := range strings.Split(path, "/") {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") && seg != "{$}" {
+			want = append(want, strings.TrimSuffix(strings.Trim(seg, "{}"), "..."))
+		}
+	}
+
+	var got []string
+	for _, p := range params {
+		if p.In == InPath {
+			got = append(got, p.Name)
+		}
+	}
+
+	slices.Sort(want)
+	slices.Sort(got)
+	if !slices.Equal(want, got) {
+		return fmt.Errorf("path parameters %v are documented as %v", want, got)
+	}
+	return nil
+}
+
+var yaml11Bools = map[string]bool{"y": true, "yes": true, "n": true, "no": true, "on": true, "off": true}
+
+// toYAML converts a JSON document to YAML, keeping the order of its keys
+func toYAML(b []byte) ([]byte, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return nil, err
+	}
+	// JSON is decoded in flow style with quoted strings. Strings read as
+	// booleans by YAML 1.1 parsers stay quoted.
+	var reset func(n *yaml.Node)
+	reset = func(n *yaml.Node) {
+		n.Style = 0
+		if n.Kind == yaml.ScalarNode && n.Tag == "!!str" && yaml11Bools[strings.ToLower(n.Value)] {
+			n.Style = yaml.DoubleQuotedStyle
+		}
+		for _, c := range n.Content {
+			reset(c)
+		}
+	}
+	reset(&node)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
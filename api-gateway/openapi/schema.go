@@ -0,0 +1,294 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is an OpenAPI 3.0 schema object
+type Schema struct {
+	Ref                  string     `json:"$ref,omitempty"`
+	Type                 string     `json:"type,omitempty"`
+	Format               string     `json:"format,omitempty"`
+	Description          string     `json:"description,omitempty"`
+	Enum                 []string   `json:"enum,omitempty"`
+	Default              any        `json:"default,omitempty"`
+	Example              any        `json:"example,omitempty"`
+	Minimum              *float64   `json:"minimum,omitempty"`
+	Maximum              *float64   `json:"maximum,omitempty"`
+	ReadOnly             bool       `json:"readOnly,omitempty"`
+	Nullable             bool       `json:"nullable,omitempty"`
+	Required             []string   `json:"required,omitempty"`
+	Properties           Properties `json:"properties,omitempty"`
+	AdditionalProperties *Schema    `json:"additionalProperties,omitempty"`
+	Items                *Schema    `json:"items,omitempty"`
+	AllOf                []*Schema  `json:"allOf,omitempty"`
+}
+
+// Property is a named property of an object schema
+type Property struct {
+	Name   string
+	Schema *Schema
+}
+
+// Properties are the properties of an object schema, encoded as a JSON
+// object in the order of the struct fields
+type Properties []Property
+
+func (p Properties) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, prop := range p {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(prop.Name)
+		buf.Write(name)
+		buf.WriteByte(':')
+		s, err := json.Marshal(prop.Schema)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(s)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// component is a named schema of the document
+type component struct {
+	name   string
+	schema *Schema
+}
+
+// schemas derives the schemas of Go types. Named struct types become
+// components referenced by name; other types are described inline.
+//
+// Struct fields are described by their json tag and by the tags
+//
+//	doc:"..."        description
+//	example:"..."    example value
+//	enum:"a,b"       allowed values
+//	format:"..."     string format, e.g. date-time
+//	default:"..."    default value
+//	min:"1" max:"5"  bounds of a number
+//	required:"true"  the property must be given
+//	readonly:"true"  set by the server, ignored in requests
+type schemas struct {
+	// defined holds the schemas given for types that cannot be derived
+	// from their Go definition, e.g. types with a custom JSON encoding
+	defined map[reflect.Type]*Schema
+	names   map[reflect.Type]string
+	docs    map[reflect.Type]string
+
+	components map[string]*component
+	// owners detects distinct types sharing a component name
+	owners map[string]reflect.Type
+	errs   []error
+}
+
+func newSchemas() *schemas {
+	return &schemas{
+		defined: map[reflect.Type]*Schema{
+			reflect.TypeFor[time.Time]():       {Type: "string", Format: "date-time"},
+			reflect.TypeFor[json.RawMessage](): {},
+		},
+		names:      make(map[reflect.Type]string),
+		docs:       make(map[reflect.Type]string),
+		components: make(map[string]*component),
+		owners:     make(map[string]reflect.Type),
+	}
+}
+
+// of returns the schema of the type of v, registering the components it
+// refers to
+func (s *schemas) of(v any) *Schema {
+	return s.schema(reflect.TypeOf(v))
+}
+
+func (s *schemas) schema(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if d, ok := s.defined[t]; ok {
+		c := *d
+		return &c
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		return s.ref(t)
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.schema(t.Elem())}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Interface:
+		return &Schema{}
+	default:
+		s.errs = append(s.errs, fmt.Errorf("no schema for type %s", t))
+		return &Schema{}
+	}
+}
+
+// ref registers the component of a named struct type and returns a
+// reference to it
+func (s *schemas) ref(t reflect.Type) *Schema {
+	name := s.names[t]
+	if name == "" {
+		name = t.Name()
+	}
+	ref := &Schema{Ref: "#/components/schemas/" + name}
+
+	if owner, ok := s.owners[name]; ok {
+		if owner != t {
+			s.errs = append(s.errs, fmt.Errorf("schema %s is used by both %s and %s", name, owner, t))
+		}
+		return ref
+	}
+	s.owners[name] = t
+
+	// Register the component before deriving it, for recursive types
+	c := &component{name: name}
+	s.components[name] = c
+	c.schema = s.object(t)
+	c.schema.Description = s.docs[t]
+	return ref
+}
+
+// object derives the schema of a struct. Embedded structs are combined with
+// allOf.
+func (s *schemas) object(t reflect.Type) *Schema {
+	obj := &Schema{Type: "object"}
+	var embedded []*Schema
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			embedded = append(embedded, s.schema(f.Type))
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop := s.field(f)
+		obj.Properties = append(obj.Properties, Property{Name: name, Schema: prop})
+		if f.Tag.Get("required") == "true" {
+			obj.Required = append(obj.Required, name)
+		}
+	}
+
+	if len(embedded) == 0 {
+		return obj
+	}
+	if len(obj.Properties) > 0 {
+		embedded = append(embedded, obj)
+	}
+	return &Schema{AllOf: embedded}
+}
+
+// field derives the schema of a struct field from its type and tags
+func (s *schemas) field(f reflect.StructField) *Schema {
+	// Nil pointers, slices and maps are encoded as null unless omitted
+	_, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+	nullable := !strings.Contains(opts, "omitempty")
+	switch f.Type.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map:
+	default:
+		nullable = false
+	}
+
+	prop := s.schema(f.Type)
+	if prop.Ref != "" {
+		// Siblings of $ref are ignored, so documented references are
+		// wrapped
+		if f.Tag.Get("doc") == "" && f.Tag.Get("readonly") != "true" && !nullable {
+			return prop
+		}
+		prop = &Schema{AllOf: []*Schema{prop}}
+	}
+	prop.Nullable = nullable
+
+	if v, ok := f.Tag.Lookup("doc"); ok {
+		prop.Description = v
+	}
+	if v, ok := f.Tag.Lookup("format"); ok {
+		prop.Format = v
+	}
+	if v, ok := f.Tag.Lookup("enum"); ok {
+		enum := strings.Split(v, ",")
+		if prop.Type == "array" {
+			prop.Items.Enum = enum
+		} else {
+			prop.Enum = enum
+		}
+	}
+	if v, ok := f.Tag.Lookup("example"); ok {
+		prop.Example = s.value(f, prop.Type, v)
+	}
+	if v, ok := f.Tag.Lookup("default"); ok {
+		prop.Default = s.value(f, prop.Type, v)
+	}
+	if v, ok := f.Tag.Lookup("min"); ok {
+		prop.Minimum = s.bound(f, v)
+	}
+	if v, ok := f.Tag.Lookup("max"); ok {
+		prop.Maximum = s.bound(f, v)
+	}
+	prop.ReadOnly = f.Tag.Get("readonly") == "true"
+	return prop
+}
+
+// value converts the text of a tag to the type of the property
+func (s *schemas) value(f reflect.StructField, typ, v string) any {
+	switch typ {
+	case "integer":
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			s.errs = append(s.errs, fmt.Errorf("field %s: invalid integer %q", f.Name, v))
+		}
+		return n
+	case "number":
+		return s.bound(f, v)
+	case "boolean":
+		return v == "true"
+	default:
+		return v
+	}
+}
+
+func (s *schemas) bound(f reflect.StructField, v string) *float64 {
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		s.errs = append(s.errs, fmt.Errorf("field %s: invalid number %q", f.Name, v))
+	}
+	return &n
+}
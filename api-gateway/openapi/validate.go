@@ -0,0 +1,443 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBody bounds the bodies read for validation. Larger request bodies are
+// refused, larger responses are not checked.
+const maxBody = 1 << 20
+
+// validation checks the requests of the documented routes, and optionally
+// their responses
+type validation struct {
+	logger    *slog.Logger
+	responses bool
+}
+
+// Validate makes the routes check their requests against the document:
+// path, query and header parameters, and JSON bodies. Invalid requests are
+// answered with 400 and RFC 7807 problem details without reaching the
+// handler. With responses set, the responses are checked too, and those
+// that do not match the document are logged; they are still sent.
+func (r *Router) Validate(logger *slog.Logger, responses bool) {
+	r.validation = &validation{logger: logger, responses: responses}
+}
+
+// Violation is a part of a request or response that does not match the
+// document
+type Violation struct {
+	// In is path, query, header or body
+	In string `json:"in"`
+	// Name is the parameter, or a JSON pointer in the body
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s %s: %s", v.In, v.Name, v.Reason)
+}
+
+// Problem is an RFC 7807 problem details object
+type Problem struct {
+	Type       string      `json:"type"`
+	Title      string      `json:"title"`
+	Status     int         `json:"status"`
+	Detail     string      `json:"detail,omitempty"`
+	Instance   string      `json:"instance,omitempty"`
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// WriteProblem answers with problem details
+func WriteProblem(w http.ResponseWriter, p Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// validated is the handler of a route, checking requests when validation
+// is enabled
+type validated struct {
+	router *Router
+	route  *route
+	next   http.Handler
+}
+
+func (v *validated) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	val, op := v.router.validation, v.route.doc
+	if val == nil || op == nil {
+		v.next.ServeHTTP(w, req)
+		return
+	}
+	c := checker{components: v.router.schemas.components}
+	route := strings.ToUpper(v.route.method) + " " + v.route.path
+
+	if p := c.request(req, op); p != nil {
+		p.Instance = req.URL.Path
+		val.logger.DebugContext(req.Context(), "request does not match the API contract",
+			"route", route, "status", p.Status, "detail", p.Detail, "violations", p.Violations)
+		WriteProblem(w, *p)
+		return
+	}
+
+	if !val.responses || streams(op) {
+		v.next.ServeHTTP(w, req)
+		return
+	}
+
+	rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+	v.next.ServeHTTP(rec, req)
+	if violations := c.response(rec, op); len(violations) > 0 {
+		val.logger.WarnContext(req.Context(), "response does not match the API contract",
+			"route", route, "status", rec.status, "violations", violations)
+	}
+}
+
+// streams tells whether an operation answers with a stream, which is not
+// recorded
+func streams(op *operation) bool {
+	for status, resp := range op.Responses {
+		if status == strconv.Itoa(http.StatusSwitchingProtocols) {
+			return true
+		}
+		if _, ok := resp.Content["text/event-stream"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// recorder keeps a copy of the response for validation
+type recorder struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.body.Len()+len(b) > maxBody {
+		r.truncated = true
+	} else {
+		r.body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// checker validates values against the schemas of the document
+type checker struct {
+	components map[string]*component
+	violations []Violation
+}
+
+func (c *checker) add(in, name, format string, args ...any) {
+	c.violations = append(c.violations, Violation{In: in, Name: name, Reason: fmt.Sprintf(format, args...)})
+}
+
+// request checks a request, returning the problem to answer with if it is
+// invalid. A valid body is put back for the handler.
+func (c *checker) request(req *http.Request, op *operation) *Problem {
+	for _, p := range op.Parameters {
+		var value string
+		var present bool
+		switch p.In {
+		case InPath:
+			value = req.PathValue(p.Name)
+			present = value != ""
+		case InQuery:
+			present = req.URL.Query().Has(p.Name)
+			value = req.URL.Query().Get(p.Name)
+		case InHeader:
+			_, present = req.Header[http.CanonicalHeaderKey(p.Name)]
+			value = req.Header.Get(p.Name)
+		}
+		if !present {
+			if p.Required {
+				c.add(p.In, p.Name, "required")
+			}
+			continue
+		}
+		c.param(p, value)
+	}
+
+	if op.RequestBody != nil {
+		if p := c.body(req, op.RequestBody); p != nil {
+			return p
+		}
+	}
+
+	if len(c.violations) == 0 {
+		return nil
+	}
+	return &Problem{
+		Status:     http.StatusBadRequest,
+		Detail:     "The request does not match the API contract",
+		Violations: c.violations,
+	}
+}
+
+// param checks the text of a parameter against its schema
+func (c *checker) param(p parameter, value string) {
+	s := c.resolve(p.Schema)
+	var v any = value
+	switch s.Type {
+	case "integer", "number":
+		v = json.Number(value)
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			c.add(p.In, p.Name, "expected a boolean")
+			return
+		}
+		v = b
+	}
+	c.value(p.In, p.Name, s, v, true)
+}
+
+// body decodes and checks a JSON request body
+func (c *checker) body(req *http.Request, rb *requestBody) *Problem {
+	b, err := io.ReadAll(io.LimitReader(req.Body, maxBody+1))
+	req.Body.Close()
+	if err != nil {
+		return &Problem{Status: http.StatusBadRequest, Detail: "The request body could not be read"}
+	}
+	if len(b) > maxBody {
+		return &Problem{Status: http.StatusRequestEntityTooLarge, Detail: fmt.Sprintf("The request body exceeds %d bytes", maxBody)}
+	}
+	req.Body = io.NopCloser(bytes.NewReader(b))
+
+	if len(bytes.TrimSpace(b)) == 0 {
+		if rb.Required {
+			c.add("body", "", "required")
+		}
+		return nil
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" && !isJSON(ct) {
+		return &Problem{Status: http.StatusUnsupportedMediaType, Detail: "The request body must be application/json"}
+	}
+
+	v, err := decode(b)
+	if err != nil {
+		c.add("body", "", "invalid JSON: %v", err)
+		return nil
+	}
+	c.value("body", "", rb.Content["application/json"].Schema, v, true)
+	return nil
+}
+
+// response checks a recorded response against the documented ones
+func (c *checker) response(rec *recorder, op *operation) []Violation {
+	doc, ok := op.Responses[strconv.Itoa(rec.status)]
+	if !ok {
+		c.add("status", strconv.Itoa(rec.status), "undocumented status")
+		return c.violations
+	}
+
+	media := rec.Header().Get("Content-Type")
+	if rec.body.Len() == 0 || rec.truncated || !isJSON(media) {
+		return nil
+	}
+	content, ok := doc.Content["application/json"]
+	if !ok {
+		c.add("body", "", "undocumented JSON body")
+		return c.violations
+	}
+
+	v, err := decode(rec.body.Bytes())
+	if err != nil {
+		c.add("body", "", "invalid JSON: %v", err)
+		return c.violations
+	}
+	c.value("body", "", content.Schema, v, false)
+	return c.violations
+}
+
+// resolve follows a reference to a component
+func (c *checker) resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		comp, ok := c.components[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+		if !ok {
+			return &Schema{}
+		}
+		s = comp.schema
+	}
+	return s
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// value checks a decoded JSON value against a schema. Properties that are
+// read-only need not be given in requests.
+func (c *checker) value(in, name string, s *Schema, v any, request bool) {
+	s = c.resolve(s)
+	if s == nil {
+		return
+	}
+	if v == nil && s.Nullable {
+		return
+	}
+	if v == nil && s.Type != "" {
+		c.add(in, name, "must not be null")
+		return
+	}
+	for _, sub := range s.AllOf {
+		c.value(in, name, sub, v, request)
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			c.add(in, name, "expected an object")
+			return
+		}
+		for _, req := range s.Required {
+			if _, ok := obj[req]; !ok && !(request && c.readOnly(s, req)) {
+				c.add(in, name+"/"+req, "required")
+			}
+		}
+		for _, key := range slices.Sorted(maps.Keys(obj)) {
+			val := obj[key]
+			if prop := s.property(key); prop != nil {
+				c.value(in, name+"/"+key, prop, val, request)
+			} else if s.AdditionalProperties != nil {
+				c.value(in, name+"/"+key, s.AdditionalProperties, val, request)
+			}
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			c.add(in, name, "expected an array")
+			return
+		}
+		for i, item := range arr {
+			c.value(in, name+"/"+strconv.Itoa(i), s.Items, item, request)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			c.add(in, name, "expected a string")
+			return
+		}
+		c.enum(in, name, s, str)
+		switch s.Format {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				c.add(in, name, "expected an RFC 3339 date-time")
+			}
+		case "uuid":
+			if !uuidPattern.MatchString(str) {
+				c.add(in, name, "expected a UUID")
+			}
+		}
+	case "integer", "number":
+		num, ok := v.(json.Number)
+		if !ok {
+			c.add(in, name, "expected a number")
+			return
+		}
+		f, err := num.Float64()
+		if err != nil {
+			c.add(in, name, "expected a number")
+			return
+		}
+		if s.Type == "integer" {
+			if _, err := num.Int64(); err != nil {
+				c.add(in, name, "expected an integer")
+				return
+			}
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			c.add(in, name, "must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			c.add(in, name, "must be at most %v", *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			c.add(in, name, "expected a boolean")
+		}
+	}
+}
+
+func (c *checker) enum(in, name string, s *Schema, v string) {
+	if len(s.Enum) == 0 {
+		return
+	}
+	for _, e := range s.Enum {
+		if v == e {
+			return
+		}
+	}
+	c.add(in, name, "must be one of %s", strings.Join(s.Enum, ", "))
+}
+
+// readOnly tells whether a property of an object schema is read-only
+func (c *checker) readOnly(s *Schema, name string) bool {
+	prop := s.property(name)
+	if prop == nil {
+		return false
+	}
+	if prop.ReadOnly {
+		return true
+	}
+	return c.resolve(prop).ReadOnly
+}
+
+// property returns the schema of a property, or nil
+func (s *Schema) property(name string) *Schema {
+	for _, p := range s.Properties {
+		if p.Name == name {
+			return p.Schema
+		}
+	}
+	return nil
+}
+
+func isJSON(contentType string) bool {
+	media, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (media == "application/json" || strings.HasSuffix(media, "+json"))
+}
+
+// decode parses JSON keeping numbers as json.Number
+func decode(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return v, nil
+}
@@ -0,0 +1,8 @@
+// Package proto holds a copy of the Protocol Buffers definitions of the
+// inventory service gRPC API, and the Go client generated from them. Keep
+// inventory/v1/inventory.proto in sync with the inventory-app copy, apart
+// from go_package, and regenerate with go generate; it needs buf,
+// protoc-gen-go and protoc-gen-go-grpc on the PATH.
+package proto
+
+//go:generate buf generate
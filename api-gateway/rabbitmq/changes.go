@@ -0,0 +1,38 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+
+	"github.com/n-nourdine/play-with-containers/api-gateway/config"
+	"github.com/n-nourdine/play-with-containers/api-gateway/events"
+	"github.com/streadway/amqp"
+)
+
+// NewChangeSubscriber feeds hub with the order lifecycle events of the
+// billing service and the catalog changes of the inventory service
+func NewChangeSubscriber(logger *slog.Logger, cfg config.RabbitMQ, hub *events.Hub) (*Subscriber, error) {
+	bindings := []Binding{
+		{Exchange: cfg.EventsExchange, Key: "billing.order.*"},
+		{Exchange: cfg.InventoryExchange, Key: "inventory.#"},
+	}
+	return NewSubscriber(logger, cfg, bindings, func(d amqp.Delivery) {
+		if !json.Valid(d.Body) {
+			logger.Warn("invalid change event ignored", "exchange", d.Exchange, "routing_key", d.RoutingKey)
+			return
+		}
+		if strings.HasPrefix(d.RoutingKey, "inventory.") {
+			hub.Publish(events.TopicMovies, d.RoutingKey, "", d.Body)
+			return
+		}
+
+		// Order events are only streamed to their owner and to the keys
+		// allowed to see every user
+		var owner struct {
+			UserID string `json:"user_id"`
+		}
+		json.Unmarshal(d.Body, &owner)
+		hub.Publish(events.TopicOrders, d.RoutingKey, owner.UserID, d.Body)
+	})
+}
@@ -0,0 +1,57 @@
+package rabbitmq
+
+import (
+	"fmt"
+
+	"github.com/n-nourdine/play-with-containers/api-gateway/message"
+)
+
+// Codec converts billing messages between their JSON form, which package
+// message validates, and their encoding on the wire
+type Codec interface {
+	// Name is the encoding name, as set in the EncodingHeader
+	Name() string
+	ContentType() string
+	Encode(typ string, version int, body []byte) ([]byte, error)
+	Decode(typ string, version int, body []byte) ([]byte, error)
+}
+
+// EncodingHeader is the AMQP header naming the encoding of a message, for
+// consumers that do not read the content type
+const EncodingHeader = "x-message-encoding"
+
+var codecs = []Codec{jsonCodec{}, avroCodec{}}
+
+// NewCodec returns the codec of an encoding name, json or avro
+func NewCodec(name string) (Codec, error) {
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown message encoding %q", name)
+}
+
+// jsonCodec sends messages as they are
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string        { return "json" }
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Encode(_ string, _ int, body []byte) ([]byte, error) { return body, nil }
+func (jsonCodec) Decode(_ string, _ int, body []byte) ([]byte, error) { return body, nil }
+
+// avroCodec sends messages in Avro binary encoding, without the schema: the
+// reader picks it from the type and version of the message
+type avroCodec struct{}
+
+func (avroCodec) Name() string        { return "avro" }
+func (avroCodec) ContentType() string { return "application/avro" }
+
+func (avroCodec) Encode(typ string, version int, body []byte) ([]byte, error) {
+	return message.ToAvro(typ, version, body)
+}
+
+func (avroCodec) Decode(typ string, version int, body []byte) ([]byte, error) {
+	return message.FromAvro(typ, version, body)
+}
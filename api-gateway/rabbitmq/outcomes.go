@@ -0,0 +1,44 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/api-gateway/config"
+	"github.com/n-nourdine/play-with-containers/api-gateway/tracking"
+	"github.com/streadway/amqp"
+)
+
+// outcome is the event the billing service publishes once a billing message
+// is persisted or rejected
+type outcome struct {
+	MessageID  string    `json:"message_id"`
+	Status     string    `json:"status"`
+	OrderID    string    `json:"order_id"`
+	Reason     string    `json:"reason"`
+	Detail     string    `json:"detail"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// NewOutcomeSubscriber records in store the outcomes of the billing
+// messages: billing.message.persisted and billing.message.rejected
+func NewOutcomeSubscriber(logger *slog.Logger, cfg config.RabbitMQ, store *tracking.Store) (*Subscriber, error) {
+	bindings := []Binding{{Exchange: cfg.EventsExchange, Key: "billing.message.*"}}
+	return NewSubscriber(logger, cfg, bindings, func(d amqp.Delivery) {
+		var o outcome
+		if err := json.Unmarshal(d.Body, &o); err != nil || o.MessageID == "" {
+			logger.Warn("invalid billing message outcome ignored", "routing_key", d.RoutingKey, "error", err)
+			return
+		}
+		store.Resolve(tracking.Entry{
+			TrackingID: o.MessageID,
+			Status:     tracking.Status(o.Status),
+			OrderID:    o.OrderID,
+			Reason:     o.Reason,
+			Detail:     o.Detail,
+			UpdatedAt:  o.OccurredAt,
+		})
+		logger.Debug("billing message outcome recorded", "tracking_id", o.MessageID, "status", o.Status, "order_id", o.OrderID)
+	})
+}
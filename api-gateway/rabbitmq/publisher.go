@@ -2,43 +2,45 @@ package rabbitmq
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"os"
-	"time"
-
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/n-nourdine/play-with-containers/api-gateway/config"
+	"github.com/n-nourdine/play-with-containers/api-gateway/message"
+	"github.com/n-nourdine/play-with-containers/api-gateway/metrics"
+	"github.com/n-nourdine/play-with-containers/api-gateway/tracing"
+	"github.com/n-nourdine/play-with-containers/shared/logging"
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Publisher struct {
 	conn    *amqp.Connection
 	channel *amqp.Channel
-	logger  *log.Logger
+	logger  *slog.Logger
+	// exchange is the topic exchange billing messages are published to
+	exchange string
+	// version is the message version published, see package message, and
+	// codec its encoding
+	version int
+	codec   Codec
+
+	// premium holds the users whose messages are published with priority
+	premium  map[string]bool
+	priority uint8
+
+	// channelClosed is set once the broker or the client closes the channel
+	channelClosed atomic.Bool
 }
 
-func NewPublisher(logger *log.Logger) (*Publisher, error) {
-	rabbitURL := fmt.Sprintf("amqp://%s:%s@%s:%s/",
-		os.Getenv("RABBITMQ_USER"),
-		os.Getenv("RABBITMQ_PASSWORD"),
-		os.Getenv("RABBITMQ_HOST"),
-		os.Getenv("RABBITMQ_PORT"),
-	)
-
-	// Retry connection with exponential backoff
-	var conn *amqp.Connection
-	var err error
-
-	for i := 0; i < 10; i++ {
-		conn, err = amqp.Dial(rabbitURL)
-		if err == nil {
-			break
-		}
-		logger.Printf("RabbitMQ connection attempt %d/10 failed: %v", i+1, err)
-		time.Sleep(time.Duration(i+1) * time.Second)
-	}
-
+func NewPublisher(logger *slog.Logger, cfg config.RabbitMQ) (*Publisher, error) {
+	conn, err := dial(logger, cfg.URL())
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to RabbitMQ after 10 attempts: %w", err)
+		return nil, err
 	}
 
 	channel, err := conn.Channel()
@@ -47,62 +49,163 @@ func NewPublisher(logger *log.Logger) (*Publisher, error) {
 		return nil, fmt.Errorf("failed to open RabbitMQ channel: %w", err)
 	}
 
-	// Declare the queue (idempotent operation)
-	queueName := os.Getenv("RABBITMQ_QUEUE_NAME")
-	if queueName == "" {
-		queueName = "billing_queue"
+	// Declare the exchange (idempotent operation)
+	err = channel.ExchangeDeclare(
+		cfg.Exchange, // name
+		"topic",      // kind
+		true,         // durable
+		false,        // auto-deleted
+		false,        // internal
+		false,        // no-wait
+		nil,          // arguments
+	)
+	if err != nil {
+		channel.Close()
+		conn.Close()
+		return nil, fmt.Errorf("failed to declare exchange: %w", err)
 	}
 
-	_, err = channel.QueueDeclare(
-		queueName, // name
-		true,      // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
-	)
+	codec, err := NewCodec(cfg.MessageEncoding)
+	if err == nil && codec.Name() == "avro" && !message.SupportsAvro(message.OrderCreated, cfg.MessageVersion) {
+		err = fmt.Errorf("billing messages of version %d cannot be encoded in Avro", cfg.MessageVersion)
+	}
+	if err == nil && (cfg.PremiumPriority < 0 || cfg.PremiumPriority > 255) {
+		err = fmt.Errorf("BILLING_PREMIUM_PRIORITY must be between 0 and 255, got %d", cfg.PremiumPriority)
+	}
 	if err != nil {
 		channel.Close()
 		conn.Close()
-		return nil, fmt.Errorf("failed to declare queue: %w", err)
+		return nil, err
 	}
 
-	logger.Printf("Connected to RabbitMQ successfully")
+	logger.Info("connected to RabbitMQ")
+
+	p := &Publisher{
+		conn:     conn,
+		channel:  channel,
+		logger:   logger,
+		exchange: cfg.Exchange,
+		version:  cfg.MessageVersion,
+		codec:    codec,
+		premium:  make(map[string]bool, len(cfg.PremiumUsers)),
+		priority: uint8(cfg.PremiumPriority),
+	}
+	for _, user := range cfg.PremiumUsers {
+		p.premium[user] = true
+	}
+	watchChannel(channel, &p.channelClosed)
+	p.watchReturns()
 
-	return &Publisher{
-		conn:    conn,
-		channel: channel,
-		logger:  logger,
-	}, nil
+	return p, nil
 }
 
-func (p *Publisher) PublishBillingMessage(ctx context.Context, message string) error {
-	queueName := os.Getenv("RABBITMQ_QUEUE_NAME")
-	if queueName == "" {
-		queueName = "billing_queue"
+// PublishBillingMessage wraps payload in a message of type msgType, in the
+// configured message version, and publishes it to the billing exchange with
+// msgType as routing key and the given priority. It returns the message ID,
+// which tracks the message, or an error wrapping message.ErrInvalid when the
+// message does not match its schema.
+func (p *Publisher) PublishBillingMessage(ctx context.Context, msgType string, payload any, priority uint8) (string, error) {
+	key := msgType
+
+	env, body, err := message.Encode(msgType, p.version, payload)
+	if err == nil {
+		body, err = p.codec.Encode(env.Type, env.Version, body)
+	}
+	if err != nil {
+		metrics.AMQPPublishFailures.WithLabelValues(key).Inc()
+		return "", err
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, p.exchange+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", p.exchange),
+			attribute.String("messaging.rabbitmq.destination.routing_key", key),
+		))
+	defer span.End()
+
+	// Carry the trace context and request ID to the consumer
+	headers := amqp.Table{
+		message.VersionHeader: int32(env.Version),
+		EncodingHeader:        p.codec.Name(),
+	}
+	tracing.InjectAMQP(ctx, headers)
+	if id := logging.RequestID(ctx); id != "" {
+		headers[logging.AMQPRequestIDHeader] = id
 	}
 
-	err := p.channel.Publish(
-		"",        // exchange
-		queueName, // routing key (queue name)
-		false,     // mandatory
-		false,     // immediate
+	err = p.channel.Publish(
+		p.exchange, // exchange
+		key,        // routing key (message type)
+		true,       // mandatory: returned when no queue is bound for the key
+		false,      // immediate
 		amqp.Publishing{
-			ContentType:  "application/json",
+			Headers:      headers,
+			ContentType:  p.codec.ContentType(),
 			DeliveryMode: amqp.Persistent, // Make message persistent
-			Body:         []byte(message),
-			Timestamp:    time.Now(),
+			Priority:     priority,
+			Type:         env.Type,
+			MessageId:    env.ID,
+			Body:         body,
+			Timestamp:    env.OccurredAt,
 		},
 	)
 
 	if err != nil {
-		return fmt.Errorf("failed to publish message: %w", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "publish failed")
+		metrics.AMQPPublishFailures.WithLabelValues(key).Inc()
+		return "", fmt.Errorf("failed to publish message: %w", err)
+	}
+	metrics.AMQPPublished.WithLabelValues(key).Inc()
+
+	p.logger.DebugContext(ctx, "message published", "exchange", p.exchange, "routing_key", key, "type", env.Type, "version", env.Version, "encoding", p.codec.Name(), "priority", priority, "message_id", env.ID, "size", len(body))
+	return env.ID, nil
+}
+
+// Priority returns the priority of the messages of a user: the premium
+// priority for premium users, 0 otherwise
+func (p *Publisher) Priority(userID string) uint8 {
+	if p.premium[userID] {
+		return p.priority
 	}
+	return 0
+}
 
-	p.logger.Printf("Message published to queue '%s': %s", queueName, message)
+// Ping reports whether the AMQP connection and channel are still open
+func (p *Publisher) Ping(ctx context.Context) error {
+	if p.conn == nil || p.conn.IsClosed() {
+		return errors.New("AMQP connection closed")
+	}
+	if p.channelClosed.Load() {
+		return errors.New("AMQP channel closed")
+	}
 	return nil
 }
 
+// watchReturns reports the messages the broker returns because no queue is
+// bound for their routing key; they are lost
+func (p *Publisher) watchReturns() {
+	returns := p.channel.NotifyReturn(make(chan amqp.Return, 1))
+	go func() {
+		for r := range returns {
+			metrics.AMQPUnroutable.WithLabelValues(r.RoutingKey).Inc()
+			p.logger.Error("billing message not routed to any queue",
+				"exchange", r.Exchange, "routing_key", r.RoutingKey, "message_id", r.MessageId, "reason", r.ReplyText)
+		}
+	}()
+}
+
+// watchChannel flags the channel as closed as soon as it stops being usable
+func watchChannel(ch *amqp.Channel, closed *atomic.Bool) {
+	notify := ch.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		<-notify
+		closed.Store(true)
+	}()
+}
+
 func (p *Publisher) Close() {
 	if p.channel != nil {
 		p.channel.Close()
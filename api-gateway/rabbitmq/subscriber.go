@@ -0,0 +1,132 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+
+	"github.com/n-nourdine/play-with-containers/api-gateway/config"
+	"github.com/streadway/amqp"
+)
+
+// Binding selects messages of a topic exchange
+type Binding struct {
+	Exchange string
+	Key      string
+}
+
+// Subscriber hands to a function the messages its bindings select. Each
+// gateway consumes an exclusive queue of its own, deleted with the
+// connection, so that every instance receives every message; messages
+// published while no gateway runs are not kept.
+type Subscriber struct {
+	conn    *amqp.Connection
+	channel *amqp.Channel
+	logger  *slog.Logger
+
+	// channelClosed is set once the broker or the client closes the channel
+	channelClosed atomic.Bool
+}
+
+func NewSubscriber(logger *slog.Logger, cfg config.RabbitMQ, bindings []Binding, handle func(amqp.Delivery)) (*Subscriber, error) {
+	conn, err := dial(logger, cfg.URL())
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Subscriber{conn: conn, logger: logger}
+	if err := s.subscribe(bindings, handle); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Subscriber) subscribe(bindings []Binding, handle func(amqp.Delivery)) error {
+	channel, err := s.conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open RabbitMQ channel: %w", err)
+	}
+	s.channel = channel
+	watchChannel(channel, &s.channelClosed)
+
+	// A server-named queue deleted with the connection
+	queue, err := channel.QueueDeclare(
+		"",    // name
+		false, // durable
+		true,  // delete when unused
+		true,  // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare queue: %w", err)
+	}
+
+	var declared []string
+	for _, b := range bindings {
+		// Same declaration as the publishing service, whichever starts first
+		if !slices.Contains(declared, b.Exchange) {
+			err = channel.ExchangeDeclare(
+				b.Exchange, // name
+				"topic",    // kind
+				true,       // durable
+				false,      // auto-deleted
+				false,      // internal
+				false,      // no-wait
+				nil,        // arguments
+			)
+			if err != nil {
+				return fmt.Errorf("failed to declare exchange: %w", err)
+			}
+			declared = append(declared, b.Exchange)
+		}
+		if err := channel.QueueBind(queue.Name, b.Key, b.Exchange, false, nil); err != nil {
+			return fmt.Errorf("failed to bind queue to %s: %w", b.Exchange, err)
+		}
+	}
+
+	deliveries, err := channel.Consume(
+		queue.Name, // queue
+		"",         // consumer
+		true,       // auto-ack: the queue is gone with the gateway anyway
+		true,       // exclusive
+		false,      // no-local
+		false,      // no-wait
+		nil,        // args
+	)
+	if err != nil {
+		return fmt.Errorf("failed to consume queue: %w", err)
+	}
+
+	go func() {
+		for d := range deliveries {
+			handle(d)
+		}
+	}()
+	s.logger.Info("subscribed to RabbitMQ", "bindings", bindings)
+	return nil
+}
+
+// Ping reports whether the AMQP connection and channel are still open
+func (s *Subscriber) Ping(ctx context.Context) error {
+	if s.conn == nil || s.conn.IsClosed() {
+		return errors.New("AMQP connection closed")
+	}
+	if s.channelClosed.Load() {
+		return errors.New("AMQP channel closed")
+	}
+	return nil
+}
+
+func (s *Subscriber) Close() {
+	if s.channel != nil {
+		s.channel.Close()
+	}
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
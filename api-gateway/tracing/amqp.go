@@ -0,0 +1,37 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+)
+
+// AMQPHeaders adapts AMQP message headers to the OpenTelemetry carrier
+// interface so trace context can travel with a message.
+type AMQPHeaders amqp.Table
+
+func (h AMQPHeaders) Get(key string) string {
+	v, ok := h[key].(string)
+	if !ok {
+		return ""
+	}
+	return v
+}
+
+func (h AMQPHeaders) Set(key, value string) {
+	h[key] = value
+}
+
+func (h AMQPHeaders) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// InjectAMQP writes the trace context of ctx into the message headers
+func InjectAMQP(ctx context.Context, headers amqp.Table) {
+	otel.GetTextMapPropagator().Inject(ctx, AMQPHeaders(headers))
+}
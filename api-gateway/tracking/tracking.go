@@ -0,0 +1,121 @@
+// Package tracking remembers what became of the billing requests published
+// by the gateway. A request is queued when published, then persisted or
+// rejected when the billing service announces the outcome of its message.
+//
+// Requests are kept in memory, for a limited time: a restarted gateway
+// forgets the requests it had queued, and only learns the outcomes published
+// while it runs.
+package tracking
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Status of a billing request
+type Status string
+
+const (
+	Queued    Status = "queued"
+	Persisted Status = "persisted"
+	Rejected  Status = "rejected"
+)
+
+// Entry is the status of a billing request, identified by the ID of its
+// message
+type Entry struct {
+	TrackingID string `json:"tracking_id"`
+	Status     Status `json:"status" enum:"queued,persisted,rejected"`
+	// OrderID is set once the order is persisted, Reason and Detail when the
+	// request is rejected
+	OrderID   string    `json:"order_id,omitempty" doc:"Set once persisted"`
+	Reason    string    `json:"reason,omitempty" doc:"Set when rejected, e.g. schema, price_mismatch" example:"schema"`
+	Detail    string    `json:"detail,omitempty" doc:"Error reported by the billing service"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// created is when the entry was first recorded, for expiry
+	created time.Time
+}
+
+// Store holds the entries in the order they were first recorded, so that
+// the expired and the oldest ones are dropped from the front
+type Store struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	ttl     time.Duration
+	max     int
+	now     func() time.Time
+}
+
+// NewStore returns a store keeping entries for ttl, and at most max of them
+func NewStore(ttl time.Duration, max int) *Store {
+	return &Store{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		ttl:     ttl,
+		max:     max,
+		now:     time.Now,
+	}
+}
+
+// Queue records a published request. An outcome received first is kept.
+func (s *Store) Queue(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[id]; ok {
+		return
+	}
+	s.add(Entry{TrackingID: id, Status: Queued})
+}
+
+// Resolve records the outcome of a request, published by this gateway or not
+func (s *Store) Resolve(e Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[e.TrackingID]; ok {
+		prev := el.Value.(*Entry)
+		e.created = prev.created
+		if e.UpdatedAt.IsZero() {
+			e.UpdatedAt = s.now().UTC()
+		}
+		*prev = e
+		return
+	}
+	s.add(e)
+}
+
+// Get returns the entry of a request, if known
+func (s *Store) Get(id string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict()
+	el, ok := s.entries[id]
+	if !ok {
+		return Entry{}, false
+	}
+	return *el.Value.(*Entry), true
+}
+
+func (s *Store) add(e Entry) {
+	e.created = s.now()
+	if e.UpdatedAt.IsZero() {
+		e.UpdatedAt = e.created.UTC()
+	}
+	s.entries[e.TrackingID] = s.order.PushBack(&e)
+	s.evict()
+}
+
+// evict drops the expired entries and the oldest ones past the maximum
+func (s *Store) evict() {
+	deadline := s.now().Add(-s.ttl)
+	for el := s.order.Front(); el != nil; el = s.order.Front() {
+		e := el.Value.(*Entry)
+		if s.order.Len() <= s.max && e.created.After(deadline) {
+			return
+		}
+		s.order.Remove(el)
+		delete(s.entries, e.TrackingID)
+	}
+}
@@ -0,0 +1,113 @@
+package tracking
+
+import (
+	"testing"
+	"time"
+)
+
+// clock is a manual time source for the store
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestStore(ttl time.Duration, max int) (*Store, *clock) {
+	c := &clock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s := NewStore(ttl, max)
+	s.now = c.now
+	return s, c
+}
+
+func status(t *testing.T, s *Store, id string) Status {
+	t.Helper()
+	e, ok := s.Get(id)
+	if !ok {
+		return ""
+	}
+	return e.Status
+}
+
+func TestQueueThenResolve(t *testing.T) {
+	s, c := newTestStore(time.Hour, 10)
+	s.Queue("a")
+	if got := status(t, s, "a"); got != Queued {
+		t.Fatalf("status %q, want queued", got)
+	}
+
+	c.advance(time.Second)
+	s.Resolve(Entry{TrackingID: "a", Status: Persisted, OrderID: "o1"})
+	e, _ := s.Get("a")
+	if e.Status != Persisted || e.OrderID != "o1" {
+		t.Errorf("entry %+v, want persisted as o1", e)
+	}
+	if !e.UpdatedAt.Equal(c.t) {
+		t.Errorf("updated at %v, want %v", e.UpdatedAt, c.t)
+	}
+}
+
+func TestLateQueueKeepsOutcome(t *testing.T) {
+	s, _ := newTestStore(time.Hour, 10)
+	s.Resolve(Entry{TrackingID: "a", Status: Rejected, Reason: "schema"})
+	s.Queue("a")
+
+	e, ok := s.Get("a")
+	if !ok || e.Status != Rejected || e.Reason != "schema" {
+		t.Errorf("entry %+v, want the rejection kept", e)
+	}
+}
+
+func TestQueueTwice(t *testing.T) {
+	s, c := newTestStore(time.Hour, 10)
+	s.Queue("a")
+	first, _ := s.Get("a")
+	c.advance(time.Minute)
+	s.Queue("a")
+	if e, _ := s.Get("a"); !e.UpdatedAt.Equal(first.UpdatedAt) {
+		t.Errorf("second Queue changed the entry to %+v", e)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	s, c := newTestStore(time.Hour, 10)
+	s.Queue("a")
+	c.advance(30 * time.Minute)
+	// An outcome does not extend the life of the entry
+	s.Resolve(Entry{TrackingID: "a", Status: Persisted})
+	s.Queue("b")
+
+	c.advance(30*time.Minute - time.Nanosecond)
+	if status(t, s, "a") != Persisted {
+		t.Fatal("a expired before its TTL")
+	}
+	c.advance(time.Nanosecond)
+	if _, ok := s.Get("a"); ok {
+		t.Error("a kept past its TTL")
+	}
+	if status(t, s, "b") != Queued {
+		t.Error("b expired with a")
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	s, _ := newTestStore(time.Hour, 2)
+	s.Queue("a")
+	s.Queue("b")
+	// Resolving keeps a in its place, the oldest
+	s.Resolve(Entry{TrackingID: "a", Status: Persisted})
+	s.Queue("c")
+
+	if _, ok := s.Get("a"); ok {
+		t.Error("oldest entry kept past the maximum")
+	}
+	for _, id := range []string{"b", "c"} {
+		if _, ok := s.Get(id); !ok {
+			t.Errorf("%s evicted", id)
+		}
+	}
+
+	// Outcomes of requests not seen before count too
+	s.Resolve(Entry{TrackingID: "d", Status: Rejected})
+	if _, ok := s.Get("b"); ok {
+		t.Error("b kept past the maximum")
+	}
+}
@@ -0,0 +1,272 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/shared/envconfig"
+)
+
+// Config holds the settings of the billing service
+type Config struct {
+	Port     string `env:"BILLING_APP_PORT" flag:"port" default:"8080"`
+	LogLevel string `env:"LOG_LEVEL" flag:"log-level" default:"info"`
+
+	Database  Database
+	RabbitMQ  RabbitMQ
+	Inventory Inventory
+	Pricing   Pricing
+	Tax       Tax
+	Invoice   Invoice
+	Payment   Payment
+	Tracing   Tracing
+}
+
+// Database holds the PostgreSQL connection settings
+type Database struct {
+	Host     string `env:"BILLING_DB_HOST" flag:"db-host" required:"true"`
+	Port     string `env:"BILLING_DB_PORT" flag:"db-port" default:"5432"`
+	User     string `env:"BILLING_DB_USER" flag:"db-user" required:"true"`
+	Password string `env:"BILLING_DB_PASSWORD" required:"true" secret:"true"`
+	Name     string `env:"BILLING_DB_NAME" flag:"db-name" required:"true"`
+}
+
+// RabbitMQ holds the broker connection settings
+type RabbitMQ struct {
+	Host     string `env:"RABBITMQ_HOST" flag:"rabbitmq-host" required:"true"`
+	Port     string `env:"RABBITMQ_PORT" flag:"rabbitmq-port" default:"5672"`
+	User     string `env:"RABBITMQ_USER" flag:"rabbitmq-user" required:"true"`
+	Password string `env:"RABBITMQ_PASSWORD" required:"true" secret:"true"`
+	VHost    string `env:"RABBITMQ_VHOST" flag:"rabbitmq-vhost" default:"/"`
+	Queue    string `env:"RABBITMQ_QUEUE_NAME" flag:"rabbitmq-queue" default:"billing_queue"`
+
+	// Consume runs the consumer of Queue, which is how orders reach the
+	// service
+	Consume bool `env:"RABBITMQ_CONSUME" flag:"rabbitmq-consume" default:"true"`
+
+	// Exchange is the topic exchange the gateway publishes billing messages
+	// to; Queue is bound to it with each of BindingKeys
+	Exchange    string   `env:"RABBITMQ_BILLING_EXCHANGE" flag:"rabbitmq-billing-exchange" default:"billing_messages"`
+	BindingKeys []string `env:"RABBITMQ_BINDING_KEYS" flag:"rabbitmq-binding-keys" default:"billing.order.created"`
+
+	// EventsExchange is the topic exchange order lifecycle events are
+	// published to
+	EventsExchange string `env:"RABBITMQ_EVENTS_EXCHANGE" flag:"rabbitmq-events-exchange" default:"billing_events"`
+
+	// Workers is the number of orders processed at once, each worker on its
+	// own channel; Prefetch is the number of deliveries the broker sends a
+	// worker before waiting for acknowledgements
+	Prefetch int `env:"RABBITMQ_PREFETCH" flag:"rabbitmq-prefetch" default:"10"`
+	Workers  int `env:"RABBITMQ_WORKERS" flag:"rabbitmq-workers" default:"4"`
+
+	// A BatchSize above 1 stores orders in batches of up to BatchSize
+	// messages, flushed at the latest BatchTimeout after the first one
+	BatchSize    int           `env:"RABBITMQ_BATCH_SIZE" flag:"rabbitmq-batch-size" default:"1"`
+	BatchTimeout time.Duration `env:"RABBITMQ_BATCH_TIMEOUT" flag:"rabbitmq-batch-timeout" default:"100ms"`
+
+	// DrainTimeout bounds the time given on shutdown to the messages already
+	// received; those not acknowledged by then are requeued
+	DrainTimeout time.Duration `env:"RABBITMQ_DRAIN_TIMEOUT" flag:"rabbitmq-drain-timeout" default:"15s"`
+
+	Topology QueueTopology
+}
+
+// QueueTopology holds the arguments the billing queue is declared with. The
+// RabbitMQ container declares the queue from the same variables, so both
+// declarations agree; RabbitMQ refuses to redeclare a queue with other
+// arguments. Zero values leave the RabbitMQ defaults.
+type QueueTopology struct {
+	Type string `env:"RABBITMQ_QUEUE_TYPE" flag:"rabbitmq-queue-type" default:"classic" oneof:"classic,quorum"`
+
+	// Past MaxLength ready messages, Overflow drops the oldest one or
+	// refuses new ones
+	MaxLength int    `env:"RABBITMQ_QUEUE_MAX_LENGTH" flag:"rabbitmq-queue-max-length" default:"0"`
+	Overflow  string `env:"RABBITMQ_QUEUE_OVERFLOW" flag:"rabbitmq-queue-overflow" default:"drop-head" oneof:"drop-head,reject-publish"`
+
+	// MessageTTL discards the messages not consumed in time
+	MessageTTL time.Duration `env:"RABBITMQ_QUEUE_MESSAGE_TTL" flag:"rabbitmq-queue-message-ttl" default:"0s"`
+
+	// MaxPriority enables message priorities from 0 to MaxPriority; classic
+	// queues only
+	MaxPriority int `env:"RABBITMQ_QUEUE_MAX_PRIORITY" flag:"rabbitmq-queue-max-priority" default:"0"`
+}
+
+// Arguments returns the x-arguments of the queue declaration, omitting
+// those left at their default
+func (q QueueTopology) Arguments() map[string]any {
+	args := map[string]any{}
+	if q.Type != "classic" {
+		args["x-queue-type"] = q.Type
+	}
+	if q.MaxLength > 0 {
+		args["x-max-length"] = int64(q.MaxLength)
+		if q.Overflow != "drop-head" {
+			args["x-overflow"] = q.Overflow
+		}
+	}
+	if q.MessageTTL > 0 {
+		args["x-message-ttl"] = q.MessageTTL.Milliseconds()
+	}
+	if q.MaxPriority > 0 {
+		args["x-max-priority"] = int64(q.MaxPriority)
+	}
+	return args
+}
+
+// Inventory locates the inventory service, which prices order items
+type Inventory struct {
+	Host string `env:"INVENTORY_SERVICE_HOST" flag:"inventory-host" required:"true"`
+	Port string `env:"INVENTORY_SERVICE_PORT" flag:"inventory-port" required:"true"`
+}
+
+// Pricing sets what happens when the prices submitted with an order differ
+// from the catalog: "reject" drops the order, "flag" stores it at the
+// catalog price and marks it for review
+type Pricing struct {
+	MismatchPolicy string `env:"PRICING_MISMATCH_POLICY" flag:"pricing-mismatch-policy" default:"reject" oneof:"reject,flag"`
+}
+
+// Tax sets the rates applied to orders. Rates are percentages keyed by ISO
+// country code, or by country and region such as US-CA; a region without a
+// rate of its own uses the rate of its country. Orders that name no region
+// are taxed in DefaultRegion.
+type Tax struct {
+	Rates            []string `env:"TAX_RATES" flag:"tax-rates" default:"FR=20"`
+	DefaultRegion    string   `env:"TAX_DEFAULT_REGION" flag:"tax-default-region" default:"FR"`
+	PricesIncludeTax bool     `env:"TAX_PRICES_INCLUDE_TAX" flag:"tax-prices-include-tax" default:"true"`
+}
+
+// Invoice sets the numbering of invoices and the seller printed on them.
+// Seller details are copied into each invoice when it is issued, so changing
+// them does not alter invoices already issued.
+type Invoice struct {
+	NumberPrefix  string `env:"INVOICE_NUMBER_PREFIX" flag:"invoice-number-prefix" default:"INV-"`
+	SellerName    string `env:"INVOICE_SELLER_NAME" flag:"invoice-seller-name" default:"play-with-containers"`
+	SellerAddress string `env:"INVOICE_SELLER_ADDRESS" flag:"invoice-seller-address"`
+	SellerTaxID   string `env:"INVOICE_SELLER_TAX_ID" flag:"invoice-seller-tax-id"`
+	// TaxLabel names the tax on invoices, e.g. TVA, VAT or Sales tax
+	TaxLabel string `env:"INVOICE_TAX_LABEL" flag:"invoice-tax-label" default:"TVA"`
+}
+
+// Payment selects the payment provider and the secret webhooks are signed
+// with. The fake provider runs in process and reports asynchronous results to
+// FakeWebhookURL, normally the webhook of this service, after FakeDelay.
+type Payment struct {
+	Provider       string        `env:"PAYMENT_PROVIDER" flag:"payment-provider" default:"fake" oneof:"fake"`
+	WebhookSecret  string        `env:"PAYMENT_WEBHOOK_SECRET" required:"true" secret:"true"`
+	FakeWebhookURL string        `env:"PAYMENT_FAKE_WEBHOOK_URL" flag:"payment-fake-webhook-url" default:"http://localhost:8080/api/payments/webhook"`
+	FakeDelay      time.Duration `env:"PAYMENT_FAKE_DELAY" flag:"payment-fake-delay" default:"2s"`
+}
+
+// Tracing selects the span exporter; the OTLP exporter itself also reads the
+// standard OTEL_EXPORTER_OTLP_* variables
+type Tracing struct {
+	Exporter     string `env:"OTEL_TRACES_EXPORTER" flag:"traces-exporter"`
+	File         string `env:"OTEL_TRACES_FILE" default:"traces.json"`
+	OTLPEndpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+}
+
+// Load reads the configuration from defaults, the optional file given by
+// -config or CONFIG_FILE, the environment and the command-line flags, and
+// validates it
+func Load() (Config, error) {
+	var cfg Config
+	if err := envconfig.Load(&cfg, os.Args[1:]); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := cfg.RabbitMQ.validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// Redacted returns the configuration keyed by variable name with secrets masked
+func (c Config) Redacted() map[string]string {
+	return envconfig.Redact(c)
+}
+
+// String formats the configuration with secrets masked
+func (c Config) String() string {
+	return envconfig.Dump(c)
+}
+
+// validate checks the consumer settings the loader cannot express
+func (r RabbitMQ) validate() error {
+	var errs []error
+	if len(r.BindingKeys) == 0 {
+		errs = append(errs, errors.New("RABBITMQ_BINDING_KEYS must list at least one routing key"))
+	}
+	if r.Prefetch < 1 {
+		errs = append(errs, fmt.Errorf("RABBITMQ_PREFETCH must be at least 1, got %d", r.Prefetch))
+	}
+	if r.Workers < 1 {
+		errs = append(errs, fmt.Errorf("RABBITMQ_WORKERS must be at least 1, got %d", r.Workers))
+	}
+	if r.BatchSize < 1 {
+		errs = append(errs, fmt.Errorf("RABBITMQ_BATCH_SIZE must be at least 1, got %d", r.BatchSize))
+	}
+	if r.BatchSize > r.Prefetch {
+		// The broker would never send enough messages to fill a batch
+		errs = append(errs, fmt.Errorf("RABBITMQ_BATCH_SIZE (%d) must not exceed RABBITMQ_PREFETCH (%d)", r.BatchSize, r.Prefetch))
+	}
+	if r.BatchSize > 1 && r.BatchTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("RABBITMQ_BATCH_TIMEOUT must be positive, got %s", r.BatchTimeout))
+	}
+	if r.DrainTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("RABBITMQ_DRAIN_TIMEOUT must be positive, got %s", r.DrainTimeout))
+	}
+	return errors.Join(append(errs, r.Topology.validate())...)
+}
+
+func (q QueueTopology) validate() error {
+	var errs []error
+	if q.MaxLength < 0 {
+		errs = append(errs, fmt.Errorf("RABBITMQ_QUEUE_MAX_LENGTH must not be negative, got %d", q.MaxLength))
+	}
+	if q.MessageTTL < 0 || q.MessageTTL%time.Millisecond != 0 {
+		errs = append(errs, fmt.Errorf("RABBITMQ_QUEUE_MESSAGE_TTL must be a positive number of milliseconds, got %s", q.MessageTTL))
+	}
+	if q.MaxPriority < 0 || q.MaxPriority > 255 {
+		errs = append(errs, fmt.Errorf("RABBITMQ_QUEUE_MAX_PRIORITY must be between 0 and 255, got %d", q.MaxPriority))
+	}
+	if q.Type == "quorum" && q.MaxPriority > 0 {
+		errs = append(errs, errors.New("RABBITMQ_QUEUE_MAX_PRIORITY is not supported by quorum queues"))
+	}
+	return errors.Join(errs...)
+}
+
+// DSN returns the PostgreSQL connection string
+func (d Database) DSN() string {
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(d.User, d.Password),
+		Host:   d.Host + ":" + d.Port,
+		Path:   "/" + d.Name,
+	}
+	return u.String()
+}
+
+// URL returns the base URL of the inventory service
+func (i Inventory) URL() string {
+	return fmt.Sprintf("http://%s:%s", i.Host, i.Port)
+}
+
+// URL returns the AMQP connection URL
+func (r RabbitMQ) URL() string {
+	return fmt.Sprintf("amqp://%s@%s:%s/%s",
+		url.UserPassword(r.User, r.Password).String(),
+		r.Host, r.Port,
+		url.PathEscape(trimSlash(r.VHost)))
+}
+
+// trimSlash maps the default vhost "/" to the empty path segment expected by
+// the AMQP URI scheme
+func trimSlash(vhost string) string {
+	if vhost == "/" {
+		return ""
+	}
+	return vhost
+}
@@ -2,12 +2,19 @@ package database
 
 import (
 	"context"
-	"database/sql"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/n-nourdine/play-with-containers/billing-app/config"
+	"github.com/n-nourdine/play-with-containers/billing-app/money"
+	"github.com/n-nourdine/play-with-containers/billing-app/tax"
+	"github.com/n-nourdine/play-with-containers/billing-app/tracing"
 )
 
 type OrderStore struct {
@@ -15,33 +22,154 @@ type OrderStore struct {
 }
 
 type Order struct {
-	ID            string `json:"id"`
-	UserID        string `json:"user_id"`
-	NumberOfItems string `json:"number_of_items"`
-	TotalAmount   string `json:"total_amount"`
+	ID            string       `json:"id"`
+	UserID        string       `json:"user_id"`
+	NumberOfItems string       `json:"number_of_items"`
+	TotalAmount   money.Amount `json:"total_amount"`
+	Currency      string       `json:"currency"`
+	Status        OrderStatus  `json:"status"`
+	CreatedAt     time.Time    `json:"created_at"`
+	Items         []OrderItem  `json:"items,omitempty"`
+
+	// PriceMismatch marks orders accepted although the submitted prices
+	// differed from the catalog; SubmittedAmount is the total the client
+	// asked for, TotalAmount the catalog total actually charged
+	PriceMismatch   bool          `json:"price_mismatch,omitempty"`
+	SubmittedAmount *money.Amount `json:"submitted_amount,omitempty"`
+
+	// Tax breakdown: TotalAmount is the amount due, GrossAmount. TaxRegion
+	// is empty for orders taken before taxes were computed, which are
+	// untaxed.
+	NetAmount    money.Amount `json:"net_amount"`
+	TaxAmount    money.Amount `json:"tax_amount"`
+	GrossAmount  money.Amount `json:"gross_amount"`
+	TaxRegion    string       `json:"tax_region,omitempty"`
+	TaxRate      tax.Rate     `json:"tax_rate"`
+	TaxInclusive bool         `json:"tax_inclusive"`
+}
+
+// ApplyTax records the tax breakdown of the order and makes its gross amount
+// the amount due
+func (o *Order) ApplyTax(b tax.Breakdown) {
+	o.NetAmount = b.Net
+	o.TaxAmount = b.Tax
+	o.GrossAmount = b.Gross
+	o.TaxRegion = b.Region
+	o.TaxRate = b.Rate
+	o.TaxInclusive = b.Inclusive
+	o.TotalAmount = b.Gross
 }
 
-func NewConn() (*OrderStore, error) {
+// DefaultCurrency is the currency of orders that do not state one
+const DefaultCurrency = "EUR"
+
+// OrderItem is one line of an order: a movie from the inventory, whether it
+// is rented or bought, how many copies and the price of one
+type OrderItem struct {
+	MovieID   string       `json:"movie_id"`
+	Kind      string       `json:"kind"`
+	Quantity  int          `json:"quantity"`
+	UnitPrice money.Amount `json:"unit_price"`
+}
+
+// Kinds of order items, matching the inventory offers
+const (
+	KindRental   = "rental"
+	KindPurchase = "purchase"
+)
+
+// MaxItemQuantity bounds the quantity of a single line
+const MaxItemQuantity = 1000
+
+var ErrInvalidItem = errors.New("ligne de commande invalide")
+
+// Validate checks that the line names a movie, has a known kind if any and a
+// positive quantity and price
+func (i OrderItem) Validate() error {
+	if i.MovieID == "" {
+		return fmt.Errorf("%w: movie_id manquant", ErrInvalidItem)
+	}
+	if i.Kind != "" && i.Kind != KindRental && i.Kind != KindPurchase {
+		return fmt.Errorf("%w: type %q inconnu", ErrInvalidItem, i.Kind)
+	}
+	if i.Quantity < 1 || i.Quantity > MaxItemQuantity {
+		return fmt.Errorf("%w: quantité %d hors de 1..%d", ErrInvalidItem, i.Quantity, MaxItemQuantity)
+	}
+	if i.UnitPrice <= 0 {
+		return fmt.Errorf("%w: prix unitaire %s", ErrInvalidItem, i.UnitPrice)
+	}
+	return nil
+}
+
+// Totals returns the number of copies and the amount due for the items
+func Totals(items []OrderItem) (int, money.Amount, error) {
+	var (
+		count int
+		total money.Amount
+	)
+	for _, item := range items {
+		if err := item.Validate(); err != nil {
+			return 0, 0, err
+		}
+		line, err := item.UnitPrice.Mul(int64(item.Quantity))
+		if err != nil {
+			return 0, 0, err
+		}
+		if total, err = total.Add(line); err != nil {
+			return 0, 0, err
+		}
+		count += item.Quantity
+	}
+	return count, total, nil
+}
+
+// OrderFilter selects the orders returned by ListOrders. Zero values mean no
+// restriction; From is inclusive and To exclusive.
+type OrderFilter struct {
+	UserID string
+	Status OrderStatus
+	// Flagged keeps only the orders accepted with a price mismatch
+	Flagged bool
+	From    time.Time
+	To      time.Time
+	Limit   int
+	Cursor  string
+}
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+
+	orderColumns = "id, user_id, number_of_items, total_amount, currency, status, created_at, price_mismatch, submitted_amount, " +
+		"net_amount, tax_amount, gross_amount, tax_region, tax_rate, tax_inclusive"
+	insertColumns = "id, user_id, number_of_items, total_amount, currency, status, price_mismatch, submitted_amount, " +
+		"net_amount, tax_amount, gross_amount, tax_region, tax_rate, tax_inclusive"
+)
+
+var (
+	ErrOrderNotFound = errors.New("commande introuvable")
+	ErrInvalidCursor = errors.New("curseur de pagination invalide")
+)
+
+func NewConn(cfg config.Database) (*OrderStore, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	dbpool, err := pgxpool.New(ctx,
-		fmt.Sprintf("postgres://%s:%s@%s:%s/%s",
-			os.Getenv("BILLING_DB_USER"),
-			os.Getenv("BILLING_DB_PASSWORD"),
-			os.Getenv("BILLING_DB_HOST"),
-			os.Getenv("BILLING_DB_PORT"),
-			os.Getenv("BILLING_DB_NAME"),
-		))
+	poolConfig, err := pgxpool.ParseConfig(cfg.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("configuration de la base de données invalide: %w", err)
+	}
+	poolConfig.ConnConfig.Tracer = tracing.QueryTracer{}
 
+	dbpool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
 		return nil, fmt.Errorf("erreur de connexion à la base de données: %w", err)
 	}
 
 	err = dbpool.Ping(ctx)
 	if err != nil {
-		fmt.Printf("user: %v; db: %v, password: %v \n", os.Getenv("BILLING_DB_USER"), os.Getenv("BILLING_DB_NAME"), os.Getenv("BILLING_DB_PASSWORD"))
-		return nil, fmt.Errorf("impossible de ping la base de données: %w", err)
+		dbpool.Close()
+		return nil, fmt.Errorf("impossible de ping la base de données %s sur %s:%s: %w", cfg.Name, cfg.Host, cfg.Port, err)
 	}
 
 	return &OrderStore{db: dbpool}, nil
@@ -51,48 +179,279 @@ func (o *OrderStore) Close() {
 	o.db.Close()
 }
 
-func (o *OrderStore) CreateOrder(ctx context.Context, order Order) error {
+// Ping checks that the database is reachable
+func (o *OrderStore) Ping(ctx context.Context) error {
+	return o.db.Ping(ctx)
+}
+
+// Stat returns a snapshot of the connection pool statistics
+func (o *OrderStore) Stat() *pgxpool.Stat {
+	return o.db.Stat()
+}
+
+// CreateOrder stores a new pending order together with the first entry of
+// its history, and returns that entry
+func (o *OrderStore) CreateOrder(ctx context.Context, order Order) (OrderEvent, error) {
 	tx, err := o.db.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("erreur lors du début de la transaction: %w", err)
+		return OrderEvent{}, fmt.Errorf("erreur lors du début de la transaction: %w", err)
 	}
 	defer tx.Rollback(ctx)
 
-	if _, err := o.db.Exec(ctx, "INSERT INTO orders (id, user_id, number_of_items, total_amount) VALUES ($1, $2, $3, $4)", order.ID, order.UserID, order.NumberOfItems, order.TotalAmount); err != nil {
+	order.setDefaults()
+	if _, err := tx.Exec(ctx, "INSERT INTO orders ("+insertColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)", order.insertValues()...); err != nil {
+
+		return OrderEvent{}, fmt.Errorf("%v erreur lors de l'insertion de la commande: %w", order, err)
+	}
 
-		return fmt.Errorf("%v erreur lors de l'insertion de la commande: %w", order, err)
+	if err := copyItems(ctx, tx, []Order{order}); err != nil {
+		return OrderEvent{}, err
+	}
+
+	event := OrderEvent{OrderID: order.ID, UserID: order.UserID, To: StatusPending}
+	if err := insertEvent(ctx, tx, &event); err != nil {
+		return OrderEvent{}, err
 	}
 
 	if err = tx.Commit(ctx); err != nil {
-		return fmt.Errorf("erreur lors du commit de la transaction: %w", err)
+		return OrderEvent{}, fmt.Errorf("erreur lors du commit de la transaction: %w", err)
 	}
 
+	return event, nil
+}
+
+// CreateOrders stores new pending orders and the first entries of their
+// histories in a single transaction, and returns those entries in the order
+// of orders. Orders and items are sent with COPY; if any order is rejected,
+// none is stored.
+func (o *OrderStore) CreateOrders(ctx context.Context, orders []Order) ([]OrderEvent, error) {
+	tx, err := o.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors du début de la transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows := make([][]any, len(orders))
+	ids := make([]string, len(orders))
+	for i := range orders {
+		orders[i].setDefaults()
+		rows[i] = orders[i].insertValues()
+		ids[i] = orders[i].ID
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"orders"}, strings.Split(insertColumns, ", "), pgx.CopyFromRows(rows))
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de l'insertion des commandes: %w", err)
+	}
+
+	if err := copyItems(ctx, tx, orders); err != nil {
+		return nil, err
+	}
+
+	eventRows, err := tx.Query(ctx,
+		`INSERT INTO order_events (order_id, to_status)
+		SELECT id, $2::text FROM unnest($1::text[]) AS id
+		RETURNING order_id, id, created_at`, ids, StatusPending)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de l'enregistrement des événements: %w", err)
+	}
+	byOrder := make(map[string]OrderEvent, len(orders))
+	var event OrderEvent
+	_, err = pgx.ForEachRow(eventRows, []any{&event.OrderID, &event.ID, &event.OccurredAt}, func() error {
+		byOrder[event.OrderID] = event
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de l'enregistrement des événements: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("erreur lors du commit de la transaction: %w", err)
+	}
+
+	events := make([]OrderEvent, len(orders))
+	for i, order := range orders {
+		events[i] = byOrder[order.ID]
+		events[i].UserID = order.UserID
+		events[i].To = StatusPending
+	}
+	return events, nil
+}
+
+// setDefaults fills in the currency and, for untaxed orders, the tax
+// breakdown of a new order
+func (o *Order) setDefaults() {
+	if o.Currency == "" {
+		o.Currency = DefaultCurrency
+	}
+	if o.TaxRegion == "" {
+		// Untaxed order
+		o.NetAmount, o.TaxAmount, o.GrossAmount = o.TotalAmount, 0, o.TotalAmount
+	}
+}
+
+// insertValues returns the values of a new order matching insertColumns
+func (o *Order) insertValues() []any {
+	return []any{o.ID, o.UserID, o.NumberOfItems, o.TotalAmount, o.Currency, string(StatusPending), o.PriceMismatch, o.SubmittedAmount,
+		o.NetAmount, o.TaxAmount, o.GrossAmount, o.TaxRegion, o.TaxRate, o.TaxInclusive}
+}
+
+// copyItems stores the items of orders, numbered from 1 in each order
+func copyItems(ctx context.Context, tx pgx.Tx, orders []Order) error {
+	var rows [][]any
+	for _, order := range orders {
+		for i, item := range order.Items {
+			kind := item.Kind
+			if kind == "" {
+				kind = KindPurchase
+			}
+			rows = append(rows, []any{order.ID, i + 1, item.MovieID, kind, item.Quantity, item.UnitPrice})
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	_, err := tx.CopyFrom(ctx, pgx.Identifier{"order_items"},
+		[]string{"order_id", "line", "movie_id", "kind", "quantity", "unit_price"},
+		pgx.CopyFromRows(rows))
+	if err != nil {
+		return fmt.Errorf("erreur lors de l'insertion des lignes de commande: %w", err)
+	}
 	return nil
 }
 
-func (o *OrderStore) GetAllOrders(ctx context.Context) ([]Order, error) {
-	rows, err := o.db.Query(ctx, `SELECT id, user_id, number_of_items, total_amount FROM orders`)
+// GetOrder returns the order with the given ID and its items, or
+// ErrOrderNotFound
+func (o *OrderStore) GetOrder(ctx context.Context, id string) (Order, error) {
+	var order Order
+	err := o.db.QueryRow(ctx,
+		`SELECT `+orderColumns+` FROM orders WHERE id = $1`, id,
+	).Scan(order.fields()...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Order{}, ErrOrderNotFound
+	}
+	if err != nil {
+		return Order{}, fmt.Errorf("erreur lors de la récupération de la commande: %w", err)
+	}
+
+	rows, err := o.db.Query(ctx,
+		`SELECT movie_id, kind, quantity, unit_price FROM order_items WHERE order_id = $1 ORDER BY line`, id)
+	if err != nil {
+		return Order{}, fmt.Errorf("erreur lors de la récupération des lignes de commande: %w", err)
+	}
+	order.Items, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (OrderItem, error) {
+		var item OrderItem
+		err := row.Scan(&item.MovieID, &item.Kind, &item.Quantity, &item.UnitPrice)
+		return item, err
+	})
 	if err != nil {
-		if err != sql.ErrNoRows {
-			return nil, fmt.Errorf("sql no row")
+		return Order{}, fmt.Errorf("erreur lors du scan des lignes de commande: %w", err)
+	}
+
+	return order, nil
+}
+
+// ListOrders returns one page of orders matching the filter, newest first.
+// The returned cursor is empty when there are no more pages.
+func (o *OrderStore) ListOrders(ctx context.Context, filter OrderFilter) ([]Order, string, error) {
+	var (
+		conds []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.UserID != "" {
+		conds = append(conds, "user_id = "+arg(filter.UserID))
+	}
+	if filter.Status != "" {
+		conds = append(conds, "status = "+arg(filter.Status))
+	}
+	if filter.Flagged {
+		conds = append(conds, "price_mismatch")
+	}
+	if !filter.From.IsZero() {
+		conds = append(conds, "created_at >= "+arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		conds = append(conds, "created_at < "+arg(filter.To))
+	}
+	if filter.Cursor != "" {
+		createdAt, id, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, "", err
 		}
-		return nil, fmt.Errorf("erreur lors de la récupération des commandes: %w", err)
+		conds = append(conds, fmt.Sprintf("(created_at, id) < (%s, %s)", arg(createdAt), arg(id)))
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	limit = min(limit, MaxPageSize)
+
+	query := `SELECT ` + orderColumns + ` FROM orders`
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	// Fetch one extra row to know whether another page exists
+	query += " ORDER BY created_at DESC, id DESC LIMIT " + arg(limit+1)
+
+	rows, err := o.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("erreur lors de la récupération des commandes: %w", err)
 	}
 	defer rows.Close()
 
-	var orders []Order
+	orders := []Order{}
 	for rows.Next() {
 		var order Order
-		err := rows.Scan(&order.ID, &order.UserID, &order.NumberOfItems, &order.TotalAmount)
+		err := rows.Scan(order.fields()...)
 		if err != nil {
-			return nil, fmt.Errorf("erreur lors du scan de la commande: %w", err)
+			return nil, "", fmt.Errorf("erreur lors du scan de la commande: %w", err)
 		}
 		orders = append(orders, order)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("erreur lors de l'itération des lignes: %w", err)
+		return nil, "", fmt.Errorf("erreur lors de l'itération des lignes: %w", err)
+	}
+
+	var next string
+	if len(orders) > limit {
+		orders = orders[:limit]
+		last := orders[limit-1]
+		next = encodeCursor(last.CreatedAt, last.ID)
 	}
 
-	return orders, nil
+	return orders, next, nil
+}
+
+// fields returns the scan destinations matching orderColumns
+func (o *Order) fields() []any {
+	return []any{&o.ID, &o.UserID, &o.NumberOfItems, &o.TotalAmount, &o.Currency, &o.Status, &o.CreatedAt, &o.PriceMismatch, &o.SubmittedAmount,
+		&o.NetAmount, &o.TaxAmount, &o.GrossAmount, &o.TaxRegion, &o.TaxRate, &o.TaxInclusive}
+}
+
+// encodeCursor builds an opaque keyset pagination cursor
+func encodeCursor(createdAt time.Time, id string) string {
+	raw := strconv.FormatInt(createdAt.UnixNano(), 10) + "|" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	nanos, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return time.Unix(0, n), id, nil
 }
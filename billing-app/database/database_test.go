@@ -0,0 +1,49 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	paris := time.FixedZone("CEST", 2*60*60)
+	tests := []struct {
+		at time.Time
+		id string
+	}{
+		{time.Date(2025, 3, 1, 10, 30, 0, 123456000, time.UTC), "0b6f8c1e-5d3a-4f7b-9c2e-1a2b3c4d5e6f"},
+		{time.Date(2025, 3, 1, 12, 30, 0, 0, paris), "order-1"},
+		{time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC), "before-epoch"},
+		{time.Unix(0, 0), "with|pipe"},
+	}
+	for _, tt := range tests {
+		at, id, err := decodeCursor(encodeCursor(tt.at, tt.id))
+		if err != nil {
+			t.Errorf("decodeCursor(encodeCursor(%v, %q)): %v", tt.at, tt.id, err)
+			continue
+		}
+		if !at.Equal(tt.at) || id != tt.id {
+			t.Errorf("round trip of %v, %q gave %v, %q", tt.at, tt.id, at, id)
+		}
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for _, cursor := range []string{
+		"",
+		"not base64!",
+		base64.StdEncoding.EncodeToString([]byte("1|ab")), // padded
+		encode("1740825000000000000"),
+		encode("1740825000000000000|"),
+		encode("|order-1"),
+		encode("yesterday|order-1"),
+		encode("99999999999999999999|order-1"),
+	} {
+		if _, _, err := decodeCursor(cursor); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("decodeCursor(%q) = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/n-nourdine/play-with-containers/billing-app/money"
+	"github.com/n-nourdine/play-with-containers/billing-app/tax"
+)
+
+// Invoice is the customer-facing document of an order. Once issued it is
+// stored as an immutable snapshot, so that rendering it again always gives
+// the same document whatever happened to the order, the catalog or the
+// configuration since.
+type Invoice struct {
+	Number   string    `json:"number"`
+	OrderID  string    `json:"order_id"`
+	UserID   string    `json:"user_id"`
+	IssuedAt time.Time `json:"issued_at"`
+	Currency string    `json:"currency"`
+	Seller   Seller    `json:"seller"`
+
+	// Lines are priced as in the catalog: including tax when
+	// PricesIncludeTax is set, before tax otherwise. Subtotal is always the
+	// amount before tax.
+	PricesIncludeTax bool          `json:"prices_include_tax"`
+	Lines            []InvoiceLine `json:"lines"`
+	Subtotal         money.Amount  `json:"subtotal"`
+	TaxLines         []TaxLine     `json:"tax_lines"`
+	TaxTotal         money.Amount  `json:"tax_total"`
+	Total            money.Amount  `json:"total"`
+}
+
+// Seller identifies the issuer of an invoice
+type Seller struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+	TaxID   string `json:"tax_id,omitempty"`
+}
+
+// InvoiceLine is one billed line; Amount is Quantity times UnitPrice
+type InvoiceLine struct {
+	Description string       `json:"description"`
+	MovieID     string       `json:"movie_id,omitempty"`
+	Kind        string       `json:"kind,omitempty"`
+	Quantity    int          `json:"quantity"`
+	UnitPrice   money.Amount `json:"unit_price"`
+	Amount      money.Amount `json:"amount"`
+}
+
+// TaxLine is the tax due at one rate on the taxable base
+type TaxLine struct {
+	Name   string       `json:"name"`
+	Rate   tax.Rate     `json:"rate"`
+	Base   money.Amount `json:"base"`
+	Amount money.Amount `json:"amount"`
+}
+
+// InvoiceBuilder drafts the invoice of an order. The store assigns its
+// number and issue date.
+type InvoiceBuilder func(ctx context.Context, order Order) (Invoice, error)
+
+var (
+	ErrInvoiceNotFound = errors.New("facture introuvable")
+	// ErrNotInvoiceable means the order has not been paid, or was cancelled
+	ErrNotInvoiceable = errors.New("commande non facturable")
+)
+
+// Invoiceable reports whether an order in this status can be invoiced
+func (s OrderStatus) Invoiceable() bool {
+	return s == StatusPaid || s == StatusFulfilled || s == StatusRefunded
+}
+
+// Invoice returns the invoice issued for an order, or ErrInvoiceNotFound
+func (o *OrderStore) Invoice(ctx context.Context, orderID string) (Invoice, error) {
+	var doc []byte
+	err := o.db.QueryRow(ctx, `SELECT document FROM invoices WHERE order_id = $1`, orderID).Scan(&doc)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Invoice{}, ErrInvoiceNotFound
+	}
+	if err != nil {
+		return Invoice{}, fmt.Errorf("erreur lors de la récupération de la facture: %w", err)
+	}
+
+	var inv Invoice
+	if err := json.Unmarshal(doc, &inv); err != nil {
+		return Invoice{}, fmt.Errorf("facture %s illisible: %w", orderID, err)
+	}
+	return inv, nil
+}
+
+// IssueInvoice returns the invoice of an order, issuing it first if needed.
+//
+// Numbers are taken from a single counter row updated in the same
+// transaction as the insertion: concurrent issuers wait for each other, and a
+// failed issuance rolls the counter back, so numbers have no gaps.
+func (o *OrderStore) IssueInvoice(ctx context.Context, orderID, prefix string, build InvoiceBuilder) (Invoice, error) {
+	if inv, err := o.Invoice(ctx, orderID); !errors.Is(err, ErrInvoiceNotFound) {
+		return inv, err
+	}
+
+	order, err := o.GetOrder(ctx, orderID)
+	if err != nil {
+		return Invoice{}, err
+	}
+	if !order.Status.Invoiceable() {
+		return Invoice{}, fmt.Errorf("%w: statut %s", ErrNotInvoiceable, order.Status)
+	}
+
+	// Draft outside the transaction: the builder may call other services
+	inv, err := build(ctx, order)
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	tx, err := o.db.Begin(ctx)
+	if err != nil {
+		return Invoice{}, fmt.Errorf("erreur lors du début de la transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var seq int64
+	if err := tx.QueryRow(ctx,
+		`UPDATE invoice_counter SET last_value = last_value + 1 WHERE id = 1 RETURNING last_value`,
+	).Scan(&seq); err != nil {
+		return Invoice{}, fmt.Errorf("erreur lors de l'attribution du numéro de facture: %w", err)
+	}
+
+	// Another issuer may have won the race while we waited for the counter
+	var existing bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM invoices WHERE order_id = $1)`, orderID).Scan(&existing); err != nil {
+		return Invoice{}, fmt.Errorf("erreur lors de la vérification de la facture: %w", err)
+	}
+	if existing {
+		tx.Rollback(ctx)
+		return o.Invoice(ctx, orderID)
+	}
+
+	inv.Number = fmt.Sprintf("%s%06d", prefix, seq)
+	inv.OrderID = order.ID
+	inv.UserID = order.UserID
+	inv.IssuedAt = time.Now().UTC().Truncate(time.Second)
+
+	doc, err := json.Marshal(inv)
+	if err != nil {
+		return Invoice{}, fmt.Errorf("impossible d'encoder la facture: %w", err)
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO invoices (number, order_id, issued_at, document) VALUES ($1, $2, $3, $4)`,
+		inv.Number, inv.OrderID, inv.IssuedAt, doc,
+	); err != nil {
+		return Invoice{}, fmt.Errorf("erreur lors de l'enregistrement de la facture: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Invoice{}, fmt.Errorf("erreur lors du commit de la transaction: %w", err)
+	}
+	return inv, nil
+}
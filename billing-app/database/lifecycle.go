@@ -0,0 +1,169 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// OrderStatus is a step of the order lifecycle
+type OrderStatus string
+
+const (
+	StatusPending   OrderStatus = "pending"
+	StatusPaid      OrderStatus = "paid"
+	StatusFulfilled OrderStatus = "fulfilled"
+	StatusCancelled OrderStatus = "cancelled"
+	StatusRefunded  OrderStatus = "refunded"
+)
+
+// transitions lists, for each status, the statuses an order may move to.
+// Cancelled and refunded are final.
+var transitions = map[OrderStatus][]OrderStatus{
+	StatusPending:   {StatusPaid, StatusCancelled},
+	StatusPaid:      {StatusFulfilled, StatusRefunded},
+	StatusFulfilled: {StatusRefunded},
+}
+
+var ErrInvalidTransition = errors.New("transition de statut invalide")
+
+// Valid reports whether s is a known status
+func (s OrderStatus) Valid() bool {
+	switch s {
+	case StatusPending, StatusPaid, StatusFulfilled, StatusCancelled, StatusRefunded:
+		return true
+	}
+	return false
+}
+
+// CanTransition reports whether an order in status from may move to status to
+func CanTransition(from, to OrderStatus) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderEvent records one status change of an order. From is empty for the
+// event that creates the order.
+type OrderEvent struct {
+	ID         int64       `json:"id"`
+	OrderID    string      `json:"order_id"`
+	UserID     string      `json:"user_id"`
+	From       OrderStatus `json:"from,omitempty"`
+	To         OrderStatus `json:"to"`
+	Reason     string      `json:"reason,omitempty"`
+	OccurredAt time.Time   `json:"occurred_at"`
+}
+
+// Transition moves the order to status to and records the change in the
+// order history. The order row is locked for the duration of the transaction
+// so that concurrent transitions are applied one after the other.
+func (o *OrderStore) Transition(ctx context.Context, id string, to OrderStatus, reason string) (OrderEvent, error) {
+	tx, err := o.db.Begin(ctx)
+	if err != nil {
+		return OrderEvent{}, fmt.Errorf("erreur lors du début de la transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	event, err := transition(ctx, tx, id, to, reason)
+	if err != nil {
+		return OrderEvent{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return OrderEvent{}, fmt.Errorf("erreur lors du commit de la transaction: %w", err)
+	}
+
+	return event, nil
+}
+
+// transition applies Transition within tx
+func transition(ctx context.Context, tx pgx.Tx, id string, to OrderStatus, reason string) (OrderEvent, error) {
+	var (
+		userID string
+		from   OrderStatus
+	)
+	err := tx.QueryRow(ctx, `SELECT user_id, status FROM orders WHERE id = $1 FOR UPDATE`, id).Scan(&userID, &from)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return OrderEvent{}, ErrOrderNotFound
+	}
+	if err != nil {
+		return OrderEvent{}, fmt.Errorf("erreur lors du verrouillage de la commande: %w", err)
+	}
+
+	if !CanTransition(from, to) {
+		return OrderEvent{}, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE orders SET status = $2 WHERE id = $1`, id, to); err != nil {
+		return OrderEvent{}, fmt.Errorf("erreur lors de la mise à jour du statut: %w", err)
+	}
+
+	event := OrderEvent{OrderID: id, UserID: userID, From: from, To: to, Reason: reason}
+	if err := insertEvent(ctx, tx, &event); err != nil {
+		return OrderEvent{}, err
+	}
+	return event, nil
+}
+
+// OrderEvents returns the status history of an order, oldest first
+func (o *OrderStore) OrderEvents(ctx context.Context, id string) ([]OrderEvent, error) {
+	rows, err := o.db.Query(ctx, `
+		SELECT e.id, e.order_id, o.user_id, COALESCE(e.from_status, ''), e.to_status, COALESCE(e.reason, ''), e.created_at
+		FROM order_events e JOIN orders o ON o.id = e.order_id
+		WHERE e.order_id = $1
+		ORDER BY e.id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la récupération de l'historique: %w", err)
+	}
+	defer rows.Close()
+
+	events := []OrderEvent{}
+	for rows.Next() {
+		var e OrderEvent
+		if err := rows.Scan(&e.ID, &e.OrderID, &e.UserID, &e.From, &e.To, &e.Reason, &e.OccurredAt); err != nil {
+			return nil, fmt.Errorf("erreur lors du scan de l'événement: %w", err)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erreur lors de l'itération des lignes: %w", err)
+	}
+
+	if len(events) == 0 {
+		// Distinguish an unknown order from one without history
+		if _, err := o.GetOrder(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	return events, nil
+}
+
+// insertEvent appends event to the order history and fills in its ID and
+// timestamp
+func insertEvent(ctx context.Context, tx pgx.Tx, event *OrderEvent) error {
+	var from *OrderStatus
+	if event.From != "" {
+		from = &event.From
+	}
+	var reason *string
+	if event.Reason != "" {
+		reason = &event.Reason
+	}
+
+	err := tx.QueryRow(ctx,
+		`INSERT INTO order_events (order_id, from_status, to_status, reason) VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		event.OrderID, from, event.To, reason,
+	).Scan(&event.ID, &event.OccurredAt)
+	if err != nil {
+		return fmt.Errorf("erreur lors de l'enregistrement de l'événement: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/n-nourdine/play-with-containers/billing-app/money"
+	"github.com/n-nourdine/play-with-containers/billing-app/payment"
+)
+
+// Payment is an attempt to pay an order through a payment provider. An order
+// has at most one payment in progress (pending, authorized or captured);
+// failed, voided and refunded payments are kept for history.
+type Payment struct {
+	ID        string         `json:"id"`
+	OrderID   string         `json:"order_id"`
+	Provider  string         `json:"provider"`
+	Status    payment.Status `json:"status"`
+	Amount    money.Amount   `json:"amount"`
+	Currency  string         `json:"currency"`
+	Reason    string         `json:"reason,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// PaymentUpdate is a new status of a payment reported by the provider.
+// EventID, if set, identifies the provider notification so that it is
+// applied only once.
+type PaymentUpdate struct {
+	PaymentID string
+	EventID   string
+	Status    payment.Status
+	Reason    string
+}
+
+// paymentTransitions lists, for each payment status, the statuses it may
+// move to
+var paymentTransitions = map[payment.Status][]payment.Status{
+	payment.StatusPending:    {payment.StatusAuthorized, payment.StatusFailed},
+	payment.StatusAuthorized: {payment.StatusCaptured, payment.StatusVoided, payment.StatusFailed},
+	payment.StatusCaptured:   {payment.StatusRefunded},
+}
+
+// paymentOrderStatus is the status an order moves to when its payment
+// reaches a status: only a captured payment makes the order paid. Other
+// payment statuses leave the order as it is.
+var paymentOrderStatus = map[payment.Status]OrderStatus{
+	payment.StatusCaptured: StatusPaid,
+	payment.StatusVoided:   StatusCancelled,
+	payment.StatusRefunded: StatusRefunded,
+}
+
+var (
+	ErrPaymentNotFound   = errors.New("paiement introuvable")
+	ErrPaymentInProgress = errors.New("un paiement est déjà en cours pour cette commande")
+	ErrNotPayable        = errors.New("commande non payable")
+	ErrPaymentTransition = errors.New("changement de statut du paiement invalide")
+	// ErrDuplicatePaymentEvent means the provider notification was already
+	// applied
+	ErrDuplicatePaymentEvent = errors.New("notification de paiement déjà traitée")
+)
+
+const (
+	paymentColumns = "id, order_id, provider, status, amount, currency, COALESCE(reason, ''), created_at, updated_at"
+
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+)
+
+// Authorizer asks the payment provider to authorize the payment of an order
+// and returns the payment to store
+type Authorizer func(ctx context.Context, order Order) (Payment, error)
+
+// AddPayment authorizes the payment of a pending order with authorize and
+// stores it. The order row stays locked from the checks to the insert, so
+// that concurrent requests for one order reach the provider one after the
+// other: the first one stores its payment and the others get
+// ErrPaymentInProgress, as does an order that already has a payment in
+// progress. Orders that are not pending get ErrNotPayable.
+func (o *OrderStore) AddPayment(ctx context.Context, orderID string, authorize Authorizer) (Payment, error) {
+	tx, err := o.db.Begin(ctx)
+	if err != nil {
+		return Payment{}, fmt.Errorf("erreur lors du début de la transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var order Order
+	err = tx.QueryRow(ctx, `SELECT `+orderColumns+` FROM orders WHERE id = $1 FOR UPDATE`, orderID).Scan(order.fields()...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Payment{}, ErrOrderNotFound
+	}
+	if err != nil {
+		return Payment{}, fmt.Errorf("erreur lors du verrouillage de la commande: %w", err)
+	}
+	if order.Status != StatusPending {
+		return Payment{}, fmt.Errorf("%w: statut %s", ErrNotPayable, order.Status)
+	}
+
+	var inProgress bool
+	err = tx.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM payments WHERE order_id = $1 AND status IN ('pending', 'authorized', 'captured'))`,
+		orderID,
+	).Scan(&inProgress)
+	if err != nil {
+		return Payment{}, fmt.Errorf("erreur lors de la récupération du paiement: %w", err)
+	}
+	if inProgress {
+		return Payment{}, ErrPaymentInProgress
+	}
+
+	p, err := authorize(ctx, order)
+	if err != nil {
+		return Payment{}, err
+	}
+
+	var reason *string
+	if p.Reason != "" {
+		reason = &p.Reason
+	}
+	err = tx.QueryRow(ctx,
+		`INSERT INTO payments (id, order_id, provider, status, amount, currency, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING created_at, updated_at`,
+		p.ID, p.OrderID, p.Provider, p.Status, p.Amount, p.Currency, reason,
+	).Scan(&p.CreatedAt, &p.UpdatedAt)
+	// The unique index on the payments in progress backs up the lock
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return Payment{}, ErrPaymentInProgress
+	}
+	if err != nil {
+		return Payment{}, fmt.Errorf("erreur lors de l'enregistrement du paiement: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Payment{}, fmt.Errorf("erreur lors du commit de la transaction: %w", err)
+	}
+	return p, nil
+}
+
+// ActivePayment returns the payment in progress of an order, or
+// ErrPaymentNotFound
+func (o *OrderStore) ActivePayment(ctx context.Context, orderID string) (Payment, error) {
+	var p Payment
+	err := o.db.QueryRow(ctx,
+		`SELECT `+paymentColumns+` FROM payments
+		WHERE order_id = $1 AND status IN ('pending', 'authorized', 'captured')`, orderID,
+	).Scan(p.fields()...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Payment{}, ErrPaymentNotFound
+	}
+	if err != nil {
+		return Payment{}, fmt.Errorf("erreur lors de la récupération du paiement: %w", err)
+	}
+	return p, nil
+}
+
+// Payments returns the payments of an order, oldest first
+func (o *OrderStore) Payments(ctx context.Context, orderID string) ([]Payment, error) {
+	rows, err := o.db.Query(ctx,
+		`SELECT `+paymentColumns+` FROM payments WHERE order_id = $1 ORDER BY created_at, id`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la récupération des paiements: %w", err)
+	}
+	payments, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Payment, error) {
+		var p Payment
+		err := row.Scan(p.fields()...)
+		return p, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors du scan des paiements: %w", err)
+	}
+
+	if len(payments) == 0 {
+		// Distinguish an unknown order from one without payments
+		if _, err := o.GetOrder(ctx, orderID); err != nil {
+			return nil, err
+		}
+	}
+	return payments, nil
+}
+
+// SettlePayment applies a status reported by the provider. A captured
+// payment makes its order paid, a voided one cancels it and a refunded one
+// refunds it, in the same transaction; the order event is returned when the
+// order changed.
+//
+// Reporting the current status again is a no-op. If the order can no longer
+// become paid, e.g. it was cancelled in the meantime, the capture is still
+// recorded and no event is returned: the payment must then be refunded. A
+// void is likewise recorded for an order that can no longer be cancelled.
+func (o *OrderStore) SettlePayment(ctx context.Context, u PaymentUpdate) (Payment, *OrderEvent, error) {
+	tx, err := o.db.Begin(ctx)
+	if err != nil {
+		return Payment{}, nil, fmt.Errorf("erreur lors du début de la transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if u.EventID != "" {
+		tag, err := tx.Exec(ctx,
+			`INSERT INTO payment_events (id, payment_id, status) VALUES ($1, $2, $3) ON CONFLICT (id) DO NOTHING`,
+			u.EventID, u.PaymentID, u.Status)
+		if err != nil {
+			if isForeignKeyViolation(err) {
+				return Payment{}, nil, ErrPaymentNotFound
+			}
+			return Payment{}, nil, fmt.Errorf("erreur lors de l'enregistrement de la notification: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return Payment{}, nil, ErrDuplicatePaymentEvent
+		}
+	}
+
+	var p Payment
+	err = tx.QueryRow(ctx, `SELECT `+paymentColumns+` FROM payments WHERE id = $1 FOR UPDATE`, u.PaymentID).Scan(p.fields()...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Payment{}, nil, ErrPaymentNotFound
+	}
+	if err != nil {
+		return Payment{}, nil, fmt.Errorf("erreur lors du verrouillage du paiement: %w", err)
+	}
+
+	if p.Status == u.Status {
+		return p, nil, tx.Commit(ctx)
+	}
+	if !canSettle(p.Status, u.Status) {
+		return Payment{}, nil, fmt.Errorf("%w: %s -> %s", ErrPaymentTransition, p.Status, u.Status)
+	}
+
+	var reason *string
+	if u.Reason != "" {
+		reason = &u.Reason
+	}
+	err = tx.QueryRow(ctx,
+		`UPDATE payments SET status = $2, reason = COALESCE($3, reason), updated_at = now() WHERE id = $1 RETURNING updated_at`,
+		p.ID, u.Status, reason,
+	).Scan(&p.UpdatedAt)
+	if err != nil {
+		return Payment{}, nil, fmt.Errorf("erreur lors de la mise à jour du paiement: %w", err)
+	}
+	p.Status = u.Status
+	if u.Reason != "" {
+		p.Reason = u.Reason
+	}
+
+	var event *OrderEvent
+	if status, ok := paymentOrderStatus[u.Status]; ok {
+		reason := u.Reason
+		if u.Status == payment.StatusCaptured {
+			reason = "paiement " + p.ID + " capturé"
+		}
+		e, err := transition(ctx, tx, p.OrderID, status, reason)
+		switch {
+		case err == nil:
+			event = &e
+		case errors.Is(err, ErrInvalidTransition) && u.Status != payment.StatusRefunded:
+			// The capture or void is kept even though the order moved on
+		default:
+			return Payment{}, nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Payment{}, nil, fmt.Errorf("erreur lors du commit de la transaction: %w", err)
+	}
+	return p, event, nil
+}
+
+func canSettle(from, to payment.Status) bool {
+	for _, next := range paymentTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation
+}
+
+// fields returns the scan destinations matching paymentColumns
+func (p *Payment) fields() []any {
+	return []any{&p.ID, &p.OrderID, &p.Provider, &p.Status, &p.Amount, &p.Currency, &p.Reason, &p.CreatedAt, &p.UpdatedAt}
+}
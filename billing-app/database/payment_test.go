@@ -0,0 +1,59 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/payment"
+)
+
+func TestCanSettle(t *testing.T) {
+	tests := []struct {
+		from, to payment.Status
+		want     bool
+	}{
+		{payment.StatusPending, payment.StatusAuthorized, true},
+		{payment.StatusPending, payment.StatusFailed, true},
+		{payment.StatusPending, payment.StatusCaptured, false},
+		{payment.StatusAuthorized, payment.StatusCaptured, true},
+		{payment.StatusAuthorized, payment.StatusVoided, true},
+		{payment.StatusAuthorized, payment.StatusFailed, true},
+		{payment.StatusAuthorized, payment.StatusRefunded, false},
+		{payment.StatusCaptured, payment.StatusRefunded, true},
+		{payment.StatusCaptured, payment.StatusVoided, false},
+		{payment.StatusCaptured, payment.StatusFailed, false},
+		{payment.StatusVoided, payment.StatusCaptured, false},
+		{payment.StatusFailed, payment.StatusAuthorized, false},
+		{payment.StatusRefunded, payment.StatusCaptured, false},
+	}
+	for _, tt := range tests {
+		if got := canSettle(tt.from, tt.to); got != tt.want {
+			t.Errorf("canSettle(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+// TestOnlyCapturePays checks that an order becomes paid through a captured
+// payment only, and that every order change a payment makes is allowed by
+// the order lifecycle
+func TestOnlyCapturePays(t *testing.T) {
+	statuses := []payment.Status{
+		payment.StatusPending, payment.StatusAuthorized, payment.StatusCaptured,
+		payment.StatusRefunded, payment.StatusVoided, payment.StatusFailed,
+	}
+	for _, s := range statuses {
+		to, ok := paymentOrderStatus[s]
+		if (to == StatusPaid) != (s == payment.StatusCaptured) {
+			t.Errorf("payment %s moves the order to %q", s, to)
+		}
+		if !ok {
+			continue
+		}
+		from := StatusPending
+		if s == payment.StatusRefunded {
+			from = StatusPaid
+		}
+		if !CanTransition(from, to) {
+			t.Errorf("payment %s moves a %s order to %s, which the lifecycle forbids", s, from, to)
+		}
+	}
+}
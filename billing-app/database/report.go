@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/n-nourdine/play-with-containers/billing-app/money"
+)
+
+// UserSpending sums the orders of a user in one currency. Spent and TaxPaid
+// cover paid and fulfilled orders, Refunded the refunded ones and
+// Outstanding the pending ones; cancelled orders only count in Orders.
+type UserSpending struct {
+	Currency     string       `json:"currency"`
+	Orders       int64        `json:"orders"`
+	PaidOrders   int64        `json:"paid_orders"`
+	Spent        money.Amount `json:"spent"`
+	TaxPaid      money.Amount `json:"tax_paid"`
+	Refunded     money.Amount `json:"refunded"`
+	Outstanding  money.Amount `json:"outstanding"`
+	FirstOrderAt time.Time    `json:"first_order_at"`
+	LastOrderAt  time.Time    `json:"last_order_at"`
+}
+
+// RevenuePeriod sums, for one period and currency, the orders paid and
+// refunded during the period
+type RevenuePeriod struct {
+	Period time.Time `json:"period"`
+	RevenueTotal
+}
+
+// RevenueTotal sums paid and refunded orders in one currency. Revenue is
+// Gross minus Refunded.
+type RevenueTotal struct {
+	Currency   string       `json:"currency"`
+	PaidOrders int64        `json:"paid_orders"`
+	Net        money.Amount `json:"net"`
+	Tax        money.Amount `json:"tax"`
+	Gross      money.Amount `json:"gross"`
+	Refunds    int64        `json:"refunds"`
+	Refunded   money.Amount `json:"refunded"`
+	Revenue    money.Amount `json:"revenue"`
+}
+
+// RevenueTotals sums periods per currency, in currency order
+func RevenueTotals(periods []RevenuePeriod) []RevenueTotal {
+	byCurrency := map[string]*RevenueTotal{}
+	for _, p := range periods {
+		t, ok := byCurrency[p.Currency]
+		if !ok {
+			t = &RevenueTotal{Currency: p.Currency}
+			byCurrency[p.Currency] = t
+		}
+		t.PaidOrders += p.PaidOrders
+		t.Net += p.Net
+		t.Tax += p.Tax
+		t.Gross += p.Gross
+		t.Refunds += p.Refunds
+		t.Refunded += p.Refunded
+		t.Revenue += p.Revenue
+	}
+
+	totals := make([]RevenueTotal, 0, len(byCurrency))
+	for _, t := range byCurrency {
+		totals = append(totals, *t)
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Currency < totals[j].Currency })
+	return totals
+}
+
+// Periods of the revenue report, named after the date_trunc field they use
+const (
+	GroupByDay   = "day"
+	GroupByWeek  = "week"
+	GroupByMonth = "month"
+)
+
+var ErrInvalidGroupBy = errors.New("regroupement invalide: 'day', 'week' ou 'month' attendu")
+
+// UserSummary returns what a user spent, per currency. A user without orders
+// has an empty summary.
+func (o *OrderStore) UserSummary(ctx context.Context, userID string) ([]UserSpending, error) {
+	rows, err := o.db.Query(ctx, `
+		SELECT currency,
+			count(*),
+			count(*) FILTER (WHERE status IN ('paid', 'fulfilled')),
+			COALESCE(sum(gross_amount) FILTER (WHERE status IN ('paid', 'fulfilled')), 0),
+			COALESCE(sum(tax_amount) FILTER (WHERE status IN ('paid', 'fulfilled')), 0),
+			COALESCE(sum(gross_amount) FILTER (WHERE status = 'refunded'), 0),
+			COALESCE(sum(gross_amount) FILTER (WHERE status = 'pending'), 0),
+			min(created_at),
+			max(created_at)
+		FROM orders
+		WHERE user_id = $1
+		GROUP BY currency
+		ORDER BY currency`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors du calcul du résumé: %w", err)
+	}
+	summary, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (UserSpending, error) {
+		var s UserSpending
+		err := row.Scan(&s.Currency, &s.Orders, &s.PaidOrders, &s.Spent, &s.TaxPaid, &s.Refunded, &s.Outstanding, &s.FirstOrderAt, &s.LastOrderAt)
+		return s, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors du scan du résumé: %w", err)
+	}
+	return summary, nil
+}
+
+// RevenueByPeriod returns the revenue per period and currency between from
+// (inclusive) and to (exclusive); zero bounds are open. Orders count in the
+// period they were paid in, and refunds in the period they were refunded in.
+// Periods are in UTC and weeks start on Monday.
+func (o *OrderStore) RevenueByPeriod(ctx context.Context, groupBy string, from, to time.Time) ([]RevenuePeriod, error) {
+	switch groupBy {
+	case GroupByDay, GroupByWeek, GroupByMonth:
+	default:
+		return nil, ErrInvalidGroupBy
+	}
+
+	query := `
+		SELECT date_trunc($1, e.created_at AT TIME ZONE 'UTC'),
+			o.currency,
+			count(*) FILTER (WHERE e.to_status = 'paid'),
+			COALESCE(sum(o.net_amount) FILTER (WHERE e.to_status = 'paid'), 0),
+			COALESCE(sum(o.tax_amount) FILTER (WHERE e.to_status = 'paid'), 0),
+			COALESCE(sum(o.gross_amount) FILTER (WHERE e.to_status = 'paid'), 0),
+			count(*) FILTER (WHERE e.to_status = 'refunded'),
+			COALESCE(sum(o.gross_amount) FILTER (WHERE e.to_status = 'refunded'), 0)
+		FROM order_events e
+		JOIN orders o ON o.id = e.order_id
+		WHERE e.to_status IN ('paid', 'refunded')`
+	args := []any{groupBy}
+	if !from.IsZero() {
+		args = append(args, from)
+		query += fmt.Sprintf(" AND e.created_at >= $%d", len(args))
+	}
+	if !to.IsZero() {
+		args = append(args, to)
+		query += fmt.Sprintf(" AND e.created_at < $%d", len(args))
+	}
+	query += " GROUP BY 1, 2 ORDER BY 1, 2"
+
+	rows, err := o.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors du calcul du chiffre d'affaires: %w", err)
+	}
+	periods, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (RevenuePeriod, error) {
+		var p RevenuePeriod
+		err := row.Scan(&p.Period, &p.Currency, &p.PaidOrders, &p.Net, &p.Tax, &p.Gross, &p.Refunds, &p.Refunded)
+		p.Period = p.Period.UTC()
+		p.Revenue = p.Gross - p.Refunded
+		return p, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors du scan du chiffre d'affaires: %w", err)
+	}
+	return periods, nil
+}
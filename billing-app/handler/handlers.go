@@ -2,25 +2,39 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
+	"github.com/n-nourdine/play-with-containers/billing-app/config"
 	"github.com/n-nourdine/play-with-containers/billing-app/database"
+	"github.com/n-nourdine/play-with-containers/billing-app/invoice"
+	"github.com/n-nourdine/play-with-containers/billing-app/ordering"
+	"github.com/n-nourdine/play-with-containers/billing-app/payment"
+	"github.com/n-nourdine/play-with-containers/billing-app/rabbitmq"
 	"github.com/n-nourdine/play-with-containers/billing-app/util"
 )
 
 type Handler struct {
-	C *database.OrderStore
+	L        *slog.Logger
+	C        *database.OrderStore
+	Events   *rabbitmq.Publisher
+	Orders   *ordering.Builder
+	Invoices *invoice.Builder
+	Payments payment.Provider
 }
 
-func NewHandler(l *log.Logger) (*Handler, error) {
-	c, err := database.NewConn()
+func NewHandler(l *slog.Logger, cfg config.Database, events *rabbitmq.Publisher, orders *ordering.Builder, invoices *invoice.Builder, payments payment.Provider) (*Handler, error) {
+	c, err := database.NewConn(cfg)
 	if err != nil {
 		return nil, err
 	}
-	return &Handler{C: c}, nil
+	return &Handler{L: l, C: c, Events: events, Orders: orders, Invoices: invoices, Payments: payments}, nil
 }
 
 func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
@@ -28,22 +42,39 @@ func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Billing service is healthy"))
 }
 
+// Add creates an order the way the consumer does: items are priced from the
+// inventory catalog and the total is taxed, and the amounts of the body are
+// only compared with the catalog. The order is returned with 201.
 func (h *Handler) Add(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), time.Second*3)
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
 	defer cancel()
 
-	order := database.Order{}
-	if err := util.FromJSON(&order, r.Body); err != nil {
-		log.Println(err)
+	var req ordering.Request
+	if err := util.FromJSON(&req, r.Body); err != nil {
+		h.L.WarnContext(r.Context(), "invalid order payload", "error", err)
 		http.Error(w, "invalide order", http.StatusBadRequest)
 		return
 	}
 
-	order.ID = util.NewUUID()
-	order.UserID = util.NewUUID()
+	order, err := h.Orders.Build(ctx, req, time.Now())
+	if err != nil {
+		var oerr *ordering.Error
+		switch {
+		case errors.As(err, &oerr) && oerr.Retry:
+			h.L.ErrorContext(r.Context(), "error pricing order", "reason", oerr.Reason, "error", err)
+			http.Error(w, "Catalogue indisponible", http.StatusServiceUnavailable)
+		case errors.As(err, &oerr):
+			h.L.WarnContext(r.Context(), "order rejected", "user_id", req.UserID, "reason", oerr.Reason, "error", err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
 
-	if err := h.C.CreateOrder(ctx, order); err != nil {
-		log.Println(err)
+	event, err := h.C.CreateOrder(ctx, order)
+	if err != nil {
+		h.L.ErrorContext(r.Context(), "error creating order", "error", err)
 		if ctx.Err() == context.DeadlineExceeded {
 			http.Error(w, "Délai d'attente dépassé ", http.StatusGatewayTimeout)
 			return
@@ -51,26 +82,230 @@ func (h *Handler) Add(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.publish(r.Context(), event)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := util.ToJSON(order, w); err != nil {
+		h.L.ErrorContext(r.Context(), "error encoding order", "error", err)
+	}
+}
+
+// OrderPage is one page of the order listing
+type OrderPage struct {
+	Orders     []database.Order `json:"orders"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// GetOrder returns a single order by ID
+func (h *Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	id := r.PathValue("id")
+	order, err := h.C.GetOrder(ctx, id)
+	if err != nil {
+		if errors.Is(err, database.ErrOrderNotFound) {
+			http.Error(w, "Commande introuvable", http.StatusNotFound)
+			return
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			http.Error(w, "Délai d'attente dépassé", http.StatusGatewayTimeout)
+			return
+		}
+		h.L.ErrorContext(r.Context(), "error getting order", "order_id", id, "error", err)
+		http.Error(w, "Erreur interne", http.StatusInternalServerError)
+		return
+	}
+
+	if err := util.ToJSON(order, w); err != nil {
+		h.L.ErrorContext(r.Context(), "error encoding order", "error", err)
+	}
 }
 
-func (h *Handler) GetAllOrders(w http.ResponseWriter, r *http.Request) {
+// ListOrders returns orders newest first, filtered by user_id, status and by
+// the from/to creation date range, paginated with limit and cursor
+func (h *Handler) ListOrders(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	orders, err := h.C.GetAllOrders(ctx)
+	filter, err := parseOrderFilter(r.URL.Query())
 	if err != nil {
-		if err != fmt.Errorf("sql no row") {
-			util.ToJSON("No orders found", w)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	orders, next, err := h.C.ListOrders(ctx, filter)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			http.Error(w, "Délai d'attente dépassé", http.StatusGatewayTimeout)
 			return
 		}
-		log.Printf("Erreur lors de la récupération des commandes: %v", err)
+		h.L.ErrorContext(r.Context(), "error listing orders", "error", err)
 		http.Error(w, "Erreur interne", http.StatusInternalServerError)
 		return
 	}
 
-	if err := util.ToJSON(orders, w); err != nil {
-		log.Printf("Erreur lors de la sérialisation JSON: %v", err)
+	if err := util.ToJSON(OrderPage{Orders: orders, NextCursor: next}, w); err != nil {
+		h.L.ErrorContext(r.Context(), "error encoding orders", "error", err)
+	}
+}
+
+// OrderEvents returns the status history of an order, oldest first
+func (h *Handler) OrderEvents(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	id := r.PathValue("id")
+	events, err := h.C.OrderEvents(ctx, id)
+	if err != nil {
+		if errors.Is(err, database.ErrOrderNotFound) {
+			http.Error(w, "Commande introuvable", http.StatusNotFound)
+			return
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			http.Error(w, "Délai d'attente dépassé", http.StatusGatewayTimeout)
+			return
+		}
+		h.L.ErrorContext(r.Context(), "error getting order events", "order_id", id, "error", err)
 		http.Error(w, "Erreur interne", http.StatusInternalServerError)
 		return
 	}
+
+	if err := util.ToJSON(events, w); err != nil {
+		h.L.ErrorContext(r.Context(), "error encoding order events", "error", err)
+	}
+}
+
+// Cancel cancels a pending order. An authorized payment is voided at the
+// payment provider first, see cancelPayment.
+func (h *Handler) Cancel(w http.ResponseWriter, r *http.Request) {
+	reason, ok := readReason(w, r)
+	if !ok {
+		return
+	}
+	if h.cancelPayment(w, r, reason) {
+		return
+	}
+	h.transition(w, r, database.StatusCancelled, reason)
+}
+
+// Fulfill marks a paid order as delivered to the customer
+func (h *Handler) Fulfill(w http.ResponseWriter, r *http.Request) {
+	reason, ok := readReason(w, r)
+	if !ok {
+		return
+	}
+	h.transition(w, r, database.StatusFulfilled, reason)
+}
+
+// transition moves the order named in the path to status to, recording
+// reason in the order history
+func (h *Handler) transition(w http.ResponseWriter, r *http.Request, to database.OrderStatus, reason string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	id := r.PathValue("id")
+	event, err := h.C.Transition(ctx, id, to, reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrOrderNotFound):
+			http.Error(w, "Commande introuvable", http.StatusNotFound)
+		case errors.Is(err, database.ErrInvalidTransition):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case ctx.Err() == context.DeadlineExceeded:
+			http.Error(w, "Délai d'attente dépassé", http.StatusGatewayTimeout)
+		default:
+			h.L.ErrorContext(r.Context(), "error changing order status", "order_id", id, "to", to, "error", err)
+			http.Error(w, "Erreur interne", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.L.InfoContext(r.Context(), "order status changed", "order_id", id, "from", event.From, "to", event.To)
+	h.publish(r.Context(), event)
+
+	if err := util.ToJSON(event, w); err != nil {
+		h.L.ErrorContext(r.Context(), "error encoding order event", "error", err)
+	}
+}
+
+// readReason reads the optional {"reason": "..."} body of status changes.
+// It answers 400 and returns false if the body is not valid JSON.
+func readReason(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := util.FromJSON(&body, r.Body); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Corps de requête invalide", http.StatusBadRequest)
+			return "", false
+		}
+	}
+	return body.Reason, true
+}
+
+// publish announces a committed status change. Failures are only logged: the
+// change itself is already stored.
+func (h *Handler) publish(ctx context.Context, event database.OrderEvent) {
+	if h.Events == nil {
+		return
+	}
+	if err := h.Events.PublishOrderEvent(ctx, event); err != nil {
+		h.L.WarnContext(ctx, "error publishing order event", "order_id", event.OrderID, "error", err)
+	}
+}
+
+func parseOrderFilter(q url.Values) (database.OrderFilter, error) {
+	filter := database.OrderFilter{
+		UserID: q.Get("user_id"),
+		Status: database.OrderStatus(q.Get("status")),
+		Cursor: q.Get("cursor"),
+	}
+	if v := q.Get("flagged"); v != "" {
+		flagged, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, errors.New("paramètre 'flagged' invalide: booléen attendu")
+		}
+		filter.Flagged = flagged
+	}
+	if filter.Status != "" && !filter.Status.Valid() {
+		return filter, fmt.Errorf("paramètre 'status' invalide: %q", filter.Status)
+	}
+
+	var err error
+	if filter.From, err = parseTime(q.Get("from")); err != nil {
+		return filter, fmt.Errorf("paramètre 'from' invalide: %w", err)
+	}
+	if filter.To, err = parseTime(q.Get("to")); err != nil {
+		return filter, fmt.Errorf("paramètre 'to' invalide: %w", err)
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, errors.New("'from' doit précéder 'to'")
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > database.MaxPageSize {
+			return filter, fmt.Errorf("paramètre 'limit' invalide: entier entre 1 et %d attendu", database.MaxPageSize)
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
+
+// parseTime accepts an RFC 3339 timestamp or a plain YYYY-MM-DD date (UTC)
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, v)
 }
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/database"
+	"github.com/n-nourdine/play-with-containers/billing-app/invoice"
+	"github.com/n-nourdine/play-with-containers/billing-app/util"
+)
+
+// GetInvoice returns the invoice of a paid, fulfilled or refunded order,
+// issuing it on first request. It is rendered as JSON, or as HTML with
+// ?format=html or an Accept header preferring text/html.
+func (h *Handler) GetInvoice(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	format := r.URL.Query().Get("format")
+	if format == "" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		format = "html"
+	}
+	if format != "" && format != "json" && format != "html" {
+		http.Error(w, "format non pris en charge: 'json' ou 'html' attendu", http.StatusNotAcceptable)
+		return
+	}
+
+	id := r.PathValue("id")
+	inv, err := h.C.IssueInvoice(ctx, id, h.Invoices.NumberPrefix(), h.Invoices.Build)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrOrderNotFound):
+			http.Error(w, "Commande introuvable", http.StatusNotFound)
+		case errors.Is(err, database.ErrNotInvoiceable):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case ctx.Err() == context.DeadlineExceeded:
+			http.Error(w, "Délai d'attente dépassé", http.StatusGatewayTimeout)
+		default:
+			h.L.ErrorContext(r.Context(), "error issuing invoice", "order_id", id, "error", err)
+			http.Error(w, "Erreur interne", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if format != "html" {
+		if err := util.ToJSON(inv, w); err != nil {
+			h.L.ErrorContext(r.Context(), "error encoding invoice", "error", err)
+		}
+		return
+	}
+
+	var page bytes.Buffer
+	if err := invoice.RenderHTML(&page, inv); err != nil {
+		h.L.ErrorContext(r.Context(), "error rendering invoice", "invoice", inv.Number, "error", err)
+		http.Error(w, "Erreur interne", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page.Bytes())
+}
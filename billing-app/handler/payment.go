@@ -0,0 +1,381 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/database"
+	"github.com/n-nourdine/play-with-containers/billing-app/metrics"
+	"github.com/n-nourdine/play-with-containers/billing-app/payment"
+	"github.com/n-nourdine/play-with-containers/billing-app/util"
+)
+
+// maxWebhookSize bounds the body of payment webhooks
+const maxWebhookSize = 64 << 10
+
+// Pay authorizes the payment of a pending order for its total. The body may
+// name the means of payment as {"payment_method": "..."}. The order stays
+// pending until the payment is captured.
+func (h *Handler) Pay(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var body struct {
+		PaymentMethod string `json:"payment_method"`
+	}
+	if r.ContentLength != 0 {
+		if err := util.FromJSON(&body, r.Body); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Corps de requête invalide", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The provider is called with the order locked, so that one order never
+	// gets two authorizations
+	id := r.PathValue("id")
+	var (
+		res         payment.Result
+		providerErr error
+	)
+	p, err := h.C.AddPayment(ctx, id, func(ctx context.Context, order database.Order) (database.Payment, error) {
+		res, providerErr = h.Payments.Authorize(ctx, payment.Authorization{
+			OrderID:  order.ID,
+			Amount:   order.TotalAmount,
+			Currency: order.Currency,
+			Method:   body.PaymentMethod,
+		})
+		if providerErr != nil {
+			return database.Payment{}, providerErr
+		}
+		metrics.PaymentOperations.WithLabelValues("authorize", string(res.Status)).Inc()
+		return database.Payment{
+			ID:       res.PaymentID,
+			OrderID:  order.ID,
+			Provider: h.Payments.Name(),
+			Status:   res.Status,
+			Amount:   order.TotalAmount,
+			Currency: order.Currency,
+			Reason:   res.Reason,
+		}, nil
+	})
+	if providerErr != nil {
+		h.providerError(w, r, "authorize", providerErr)
+		return
+	}
+	if err != nil {
+		if res.Status == payment.StatusAuthorized {
+			// The payment could not be stored: release the funds
+			h.voidUnrecorded(r.Context(), res.PaymentID)
+		}
+		h.paymentError(ctx, w, r, "error saving payment", err)
+		return
+	}
+	h.L.InfoContext(r.Context(), "payment authorization requested", "order_id", id, "payment_id", p.ID, "status", p.Status)
+
+	switch p.Status {
+	case payment.StatusFailed:
+		h.writePayment(w, r, http.StatusPaymentRequired, p)
+	case payment.StatusPending:
+		h.writePayment(w, r, http.StatusAccepted, p)
+	default:
+		h.writePayment(w, r, http.StatusCreated, p)
+	}
+}
+
+// voidUnrecorded voids an authorization that could not be stored. Failures
+// are only logged: the authorization then expires at the provider.
+func (h *Handler) voidUnrecorded(ctx context.Context, paymentID string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	res, err := h.Payments.Void(ctx, paymentID)
+	if err != nil {
+		metrics.PaymentOperations.WithLabelValues("void", "error").Inc()
+		h.L.WarnContext(ctx, "error voiding unrecorded authorization", "payment_id", paymentID, "error", err)
+		return
+	}
+	metrics.PaymentOperations.WithLabelValues("void", string(res.Status)).Inc()
+	h.L.WarnContext(ctx, "unrecorded authorization voided", "payment_id", paymentID, "status", res.Status)
+}
+
+// Capture captures the authorized payment of an order, which makes the order
+// paid and issues its invoice
+func (h *Handler) Capture(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	id := r.PathValue("id")
+	p, err := h.C.ActivePayment(ctx, id)
+	if err != nil {
+		h.paymentError(ctx, w, r, "error getting payment", err)
+		return
+	}
+	if p.Status != payment.StatusAuthorized {
+		http.Error(w, fmt.Sprintf("paiement %s, autorisation attendue", p.Status), http.StatusConflict)
+		return
+	}
+
+	res, err := h.Payments.Capture(ctx, p.ID, p.Amount)
+	if err != nil {
+		h.providerError(w, r, "capture", err)
+		return
+	}
+	metrics.PaymentOperations.WithLabelValues("capture", string(res.Status)).Inc()
+	if p, _, ok := h.applyResult(ctx, w, r, p, res, ""); ok {
+		h.writePayment(w, r, http.StatusOK, p)
+	}
+}
+
+// Refund refunds a paid or fulfilled order and returns the recorded status
+// change. Orders paid through the payment provider are refunded there first;
+// if the provider answers asynchronously, the payment is returned with 202
+// and the order is refunded when the webhook confirms.
+func (h *Handler) Refund(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	reason, ok := readReason(w, r)
+	if !ok {
+		return
+	}
+
+	id := r.PathValue("id")
+	p, err := h.C.ActivePayment(ctx, id)
+	if errors.Is(err, database.ErrPaymentNotFound) {
+		// Nothing was charged
+		h.transition(w, r, database.StatusRefunded, reason)
+		return
+	}
+	if err != nil {
+		h.paymentError(ctx, w, r, "error getting payment", err)
+		return
+	}
+	if p.Status != payment.StatusCaptured {
+		// The funds are still held: the authorization is released by
+		// cancelling the order
+		http.Error(w, fmt.Sprintf("paiement %s non capturé, rien à rembourser: annulez la commande", p.Status), http.StatusConflict)
+		return
+	}
+
+	res, err := h.Payments.Refund(ctx, p.ID, p.Amount)
+	if err != nil {
+		h.providerError(w, r, "refund", err)
+		return
+	}
+	metrics.PaymentOperations.WithLabelValues("refund", string(res.Status)).Inc()
+	if _, event, ok := h.applyResult(ctx, w, r, p, res, reason); ok {
+		if err := util.ToJSON(event, w); err != nil {
+			h.L.ErrorContext(r.Context(), "error encoding order event", "error", err)
+		}
+	}
+}
+
+// cancelPayment releases the authorized payment of an order being cancelled:
+// the payment is voided at the provider, and the order is cancelled in the
+// same transaction that records the void. If the provider answers
+// asynchronously, the payment is returned with 202 and the order is cancelled
+// when the webhook confirms. It returns false, without answering, when the
+// order has no payment to release and Cancel goes on with the transition.
+func (h *Handler) cancelPayment(w http.ResponseWriter, r *http.Request, reason string) bool {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	p, err := h.C.ActivePayment(ctx, r.PathValue("id"))
+	if errors.Is(err, database.ErrPaymentNotFound) || (err == nil && p.Status == payment.StatusCaptured) {
+		// Nothing is held, or the order is paid and the transition refuses it
+		return false
+	}
+	if err != nil {
+		h.paymentError(ctx, w, r, "error getting payment", err)
+		return true
+	}
+	if p.Status == payment.StatusPending {
+		http.Error(w, "autorisation du paiement en attente, réessayez une fois son résultat connu", http.StatusConflict)
+		return true
+	}
+
+	res, err := h.Payments.Void(ctx, p.ID)
+	if err != nil {
+		h.providerError(w, r, "void", err)
+		return true
+	}
+	metrics.PaymentOperations.WithLabelValues("void", string(res.Status)).Inc()
+	if res.Status == payment.StatusFailed {
+		// A refused void leaves the payment authorized
+		http.Error(w, "annulation de l'autorisation refusée par le prestataire: "+res.Reason, http.StatusPaymentRequired)
+		return true
+	}
+
+	_, event, ok := h.applyResult(ctx, w, r, p, res, reason)
+	if !ok {
+		return true
+	}
+	if event == nil {
+		http.Error(w, "autorisation annulée, mais la commande n'est plus annulable", http.StatusConflict)
+		return true
+	}
+	if err := util.ToJSON(event, w); err != nil {
+		h.L.ErrorContext(r.Context(), "error encoding order event", "error", err)
+	}
+	return true
+}
+
+// ListPayments lists the payments of an order, oldest first
+func (h *Handler) ListPayments(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	payments, err := h.C.Payments(ctx, r.PathValue("id"))
+	if err != nil {
+		h.paymentError(ctx, w, r, "error getting payments", err)
+		return
+	}
+	if err := util.ToJSON(payments, w); err != nil {
+		h.L.ErrorContext(r.Context(), "error encoding payments", "error", err)
+	}
+}
+
+// PaymentWebhook receives the asynchronous results of the payment provider.
+// Requests must be signed by the provider; notifications already applied are
+// acknowledged without effect.
+func (h *Handler) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+	if err != nil {
+		http.Error(w, "Corps de requête invalide", http.StatusBadRequest)
+		return
+	}
+	e, err := h.Payments.ParseWebhook(r.Header, body)
+	if err != nil {
+		metrics.PaymentOperations.WithLabelValues("webhook", "error").Inc()
+		if errors.Is(err, payment.ErrInvalidSignature) {
+			h.L.WarnContext(r.Context(), "payment webhook rejected", "error", err)
+			http.Error(w, "Signature invalide", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, event, err := h.C.SettlePayment(ctx, database.PaymentUpdate{
+		PaymentID: e.PaymentID,
+		EventID:   e.ID,
+		Status:    e.Status,
+		Reason:    e.Reason,
+	})
+	if errors.Is(err, database.ErrDuplicatePaymentEvent) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		metrics.PaymentOperations.WithLabelValues("webhook", "error").Inc()
+		h.paymentError(ctx, w, r, "error applying payment event", err)
+		return
+	}
+	metrics.PaymentOperations.WithLabelValues("webhook", string(e.Status)).Inc()
+	h.L.InfoContext(r.Context(), "payment event applied", "event_id", e.ID, "payment_id", p.ID, "order_id", p.OrderID, "status", p.Status)
+
+	h.settled(r.Context(), p, event)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyResult records the synchronous result of a capture or refund. It
+// answers 202 with the payment when the result will arrive by webhook and
+// 402 when the provider refused; otherwise it returns the settled payment and
+// order event, and the caller answers.
+func (h *Handler) applyResult(ctx context.Context, w http.ResponseWriter, r *http.Request, p database.Payment, res payment.Result, reason string) (database.Payment, *database.OrderEvent, bool) {
+	if res.Status == payment.StatusPending {
+		h.writePayment(w, r, http.StatusAccepted, p)
+		return p, nil, false
+	}
+	if res.Status == payment.StatusFailed && p.Status == payment.StatusCaptured {
+		// A refused refund leaves the payment captured
+		http.Error(w, "remboursement refusé par le prestataire: "+res.Reason, http.StatusPaymentRequired)
+		return p, nil, false
+	}
+	if res.Reason != "" {
+		reason = res.Reason
+	}
+
+	p, event, err := h.C.SettlePayment(ctx, database.PaymentUpdate{PaymentID: p.ID, Status: res.Status, Reason: reason})
+	if err != nil {
+		h.paymentError(ctx, w, r, "error saving payment", err)
+		return p, nil, false
+	}
+	h.settled(r.Context(), p, event)
+
+	if p.Status == payment.StatusFailed {
+		h.writePayment(w, r, http.StatusPaymentRequired, p)
+		return p, nil, false
+	}
+	return p, event, true
+}
+
+// settled follows up a payment status change: it announces the order status
+// change, and issues the invoice of orders that were just paid. Failures are
+// only logged: the payment is already recorded.
+func (h *Handler) settled(ctx context.Context, p database.Payment, event *database.OrderEvent) {
+	if event == nil {
+		if p.Status == payment.StatusCaptured {
+			h.L.WarnContext(ctx, "payment captured for an order that can no longer be paid, refund required",
+				"order_id", p.OrderID, "payment_id", p.ID)
+		}
+		return
+	}
+
+	h.publish(ctx, *event)
+	if event.To != database.StatusPaid {
+		return
+	}
+	inv, err := h.C.IssueInvoice(ctx, p.OrderID, h.Invoices.NumberPrefix(), h.Invoices.Build)
+	if err != nil {
+		h.L.WarnContext(ctx, "error issuing invoice, it will be issued on first request", "order_id", p.OrderID, "error", err)
+		return
+	}
+	h.L.InfoContext(ctx, "invoice issued", "order_id", p.OrderID, "invoice", inv.Number)
+}
+
+func (h *Handler) writePayment(w http.ResponseWriter, r *http.Request, status int, p database.Payment) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := util.ToJSON(p, w); err != nil {
+		h.L.ErrorContext(r.Context(), "error encoding payment", "error", err)
+	}
+}
+
+// providerError answers a failed call to the payment provider
+func (h *Handler) providerError(w http.ResponseWriter, r *http.Request, operation string, err error) {
+	metrics.PaymentOperations.WithLabelValues(operation, "error").Inc()
+	if errors.Is(err, payment.ErrUnknownPayment) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	h.L.ErrorContext(r.Context(), "payment provider error", "operation", operation, "order_id", r.PathValue("id"), "error", err)
+	http.Error(w, "Prestataire de paiement indisponible", http.StatusBadGateway)
+}
+
+// paymentError maps a store error to the HTTP response
+func (h *Handler) paymentError(ctx context.Context, w http.ResponseWriter, r *http.Request, msg string, err error) {
+	switch {
+	case errors.Is(err, database.ErrOrderNotFound):
+		http.Error(w, "Commande introuvable", http.StatusNotFound)
+	case errors.Is(err, database.ErrPaymentNotFound):
+		http.Error(w, "Paiement introuvable", http.StatusNotFound)
+	case errors.Is(err, database.ErrPaymentInProgress),
+		errors.Is(err, database.ErrNotPayable),
+		errors.Is(err, database.ErrPaymentTransition),
+		errors.Is(err, database.ErrInvalidTransition):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case ctx.Err() == context.DeadlineExceeded:
+		http.Error(w, "Délai d'attente dépassé", http.StatusGatewayTimeout)
+	default:
+		h.L.ErrorContext(r.Context(), msg, "order_id", r.PathValue("id"), "error", err)
+		http.Error(w, "Erreur interne", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/database"
+	"github.com/n-nourdine/play-with-containers/billing-app/util"
+)
+
+// UserSummary is what a user spent, per currency
+type UserSummary struct {
+	UserID     string                  `json:"user_id"`
+	Currencies []database.UserSpending `json:"currencies"`
+}
+
+// RevenueReport is the revenue per period and currency, with the totals of
+// the whole range per currency
+type RevenueReport struct {
+	GroupBy string                   `json:"group_by"`
+	From    *time.Time               `json:"from,omitempty"`
+	To      *time.Time               `json:"to,omitempty"`
+	Periods []database.RevenuePeriod `json:"periods"`
+	Totals  []database.RevenueTotal  `json:"totals"`
+}
+
+// GetUserSummary returns the spending of a user per currency, as JSON or,
+// with ?format=csv or Accept: text/csv, as CSV
+func (h *Handler) GetUserSummary(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	asCSV, ok := wantsCSV(w, r)
+	if !ok {
+		return
+	}
+
+	id := r.PathValue("id")
+	summary, err := h.C.UserSummary(ctx, id)
+	if err != nil {
+		h.reportError(ctx, w, r, "error computing user summary", err)
+		return
+	}
+
+	if !asCSV {
+		if err := util.ToJSON(UserSummary{UserID: id, Currencies: summary}, w); err != nil {
+			h.L.ErrorContext(r.Context(), "error encoding user summary", "error", err)
+		}
+		return
+	}
+
+	records := [][]string{{"user_id", "currency", "orders", "paid_orders", "spent", "tax_paid", "refunded", "outstanding", "first_order_at", "last_order_at"}}
+	for _, s := range summary {
+		records = append(records, []string{
+			id, s.Currency,
+			strconv.FormatInt(s.Orders, 10), strconv.FormatInt(s.PaidOrders, 10),
+			s.Spent.String(), s.TaxPaid.String(), s.Refunded.String(), s.Outstanding.String(),
+			s.FirstOrderAt.UTC().Format(time.RFC3339), s.LastOrderAt.UTC().Format(time.RFC3339),
+		})
+	}
+	h.writeCSV(w, r, "user-"+id+"-summary.csv", records)
+}
+
+// GetRevenue returns the revenue grouped by day, week or month (group_by,
+// default month) between the optional from and to dates, as JSON or, with
+// ?format=csv or Accept: text/csv, as CSV
+func (h *Handler) GetRevenue(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	asCSV, ok := wantsCSV(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	report := RevenueReport{GroupBy: q.Get("group_by")}
+	if report.GroupBy == "" {
+		report.GroupBy = database.GroupByMonth
+	}
+	from, err := parseTime(q.Get("from"))
+	if err != nil {
+		http.Error(w, "paramètre 'from' invalide: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTime(q.Get("to"))
+	if err != nil {
+		http.Error(w, "paramètre 'to' invalide: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		http.Error(w, "'from' doit précéder 'to'", http.StatusBadRequest)
+		return
+	}
+	if !from.IsZero() {
+		report.From = &from
+	}
+	if !to.IsZero() {
+		report.To = &to
+	}
+
+	report.Periods, err = h.C.RevenueByPeriod(ctx, report.GroupBy, from, to)
+	if err != nil {
+		h.reportError(ctx, w, r, "error computing revenue", err)
+		return
+	}
+	report.Totals = database.RevenueTotals(report.Periods)
+
+	if !asCSV {
+		if err := util.ToJSON(report, w); err != nil {
+			h.L.ErrorContext(r.Context(), "error encoding revenue report", "error", err)
+		}
+		return
+	}
+
+	records := [][]string{{"period", "currency", "paid_orders", "net", "tax", "gross", "refunds", "refunded", "revenue"}}
+	for _, p := range report.Periods {
+		records = append(records, []string{
+			p.Period.Format(time.DateOnly), p.Currency,
+			strconv.FormatInt(p.PaidOrders, 10),
+			p.Net.String(), p.Tax.String(), p.Gross.String(),
+			strconv.FormatInt(p.Refunds, 10),
+			p.Refunded.String(), p.Revenue.String(),
+		})
+	}
+	h.writeCSV(w, r, "revenue-by-"+report.GroupBy+".csv", records)
+}
+
+// wantsCSV reads the requested format: JSON by default, CSV with
+// ?format=csv or an Accept header preferring text/csv. It answers 406 and
+// returns false for other formats.
+func wantsCSV(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		return true, true
+	case "json":
+		return false, true
+	case "":
+		return strings.Contains(r.Header.Get("Accept"), "text/csv"), true
+	}
+	http.Error(w, "format non pris en charge: 'json' ou 'csv' attendu", http.StatusNotAcceptable)
+	return false, false
+}
+
+// writeCSV sends records as a CSV attachment
+func (h *Handler) writeCSV(w http.ResponseWriter, r *http.Request, filename string, records [][]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+strings.ReplaceAll(filename, `"`, "")+`"`)
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(records); err != nil {
+		h.L.ErrorContext(r.Context(), "error writing CSV", "error", err)
+	}
+}
+
+// reportError maps a report error to the HTTP response
+func (h *Handler) reportError(ctx context.Context, w http.ResponseWriter, r *http.Request, msg string, err error) {
+	switch {
+	case errors.Is(err, database.ErrInvalidGroupBy):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case ctx.Err() == context.DeadlineExceeded:
+		http.Error(w, "Délai d'attente dépassé", http.StatusGatewayTimeout)
+	default:
+		h.L.ErrorContext(r.Context(), msg, "error", err)
+		http.Error(w, "Erreur interne", http.StatusInternalServerError)
+	}
+}
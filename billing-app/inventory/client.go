@@ -0,0 +1,104 @@
+// Package inventory is a client for the catalog and pricing API of the
+// inventory service
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/config"
+	"github.com/n-nourdine/play-with-containers/billing-app/money"
+	"github.com/n-nourdine/play-with-containers/shared/logging"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// ErrMovieNotFound means the inventory service does not know the movie
+var ErrMovieNotFound = errors.New("film inconnu de l'inventaire")
+
+// Offer is the price of a movie for one kind of offer, as returned by
+// GET /api/movies/{id}/offers
+type Offer struct {
+	Kind       string       `json:"kind"`
+	Price      money.Amount `json:"price"`
+	FinalPrice money.Amount `json:"final_price"`
+	Currency   string       `json:"currency"`
+	RentalDays *int         `json:"rental_days,omitempty"`
+}
+
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+func NewClient(cfg config.Inventory) *Client {
+	return &Client{
+		baseURL: cfg.URL(),
+		http: &http.Client{
+			Timeout:   3 * time.Second,
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+		},
+	}
+}
+
+// Movie is a movie of the inventory catalog, as returned by
+// GET /api/movies/{id}
+type Movie struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// Movie returns a movie of the catalog
+func (c *Client) Movie(ctx context.Context, movieID string) (Movie, error) {
+	var movie Movie
+	err := c.get(ctx, "/api/movies/"+url.PathEscape(movieID), movieID, &movie)
+	return movie, err
+}
+
+// Offers returns the offers of a movie priced at time at
+func (c *Client) Offers(ctx context.Context, movieID string, at time.Time) ([]Offer, error) {
+	var body struct {
+		Offers []Offer `json:"offers"`
+	}
+	path := "/api/movies/" + url.PathEscape(movieID) + "/offers?at=" + url.QueryEscape(at.UTC().Format(time.RFC3339))
+	if err := c.get(ctx, path, movieID, &body); err != nil {
+		return nil, err
+	}
+	return body.Offers, nil
+}
+
+// get decodes the JSON response to a GET of path into v. A 404 is reported
+// as ErrMovieNotFound for movieID.
+func (c *Client) get(ctx context.Context, path, movieID string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	if id := logging.RequestID(ctx); id != "" {
+		req.Header.Set(logging.RequestIDHeader, id)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("inventaire injoignable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %s", ErrMovieNotFound, movieID)
+	default:
+		return fmt.Errorf("inventaire: GET %s a répondu %d", req.URL.Path, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("réponse de l'inventaire invalide: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package invoice
+
+import (
+	"html/template"
+	"io"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/database"
+)
+
+// page only reads the stored invoice, so the same invoice always renders to
+// the same document
+var page = template.Must(template.New("invoice").Parse(`<!DOCTYPE html>
+<html lang="fr">
+<head>
+<meta charset="utf-8">
+<title>Facture {{.Number}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; margin-top: 1em; }
+th, td { border-bottom: 1px solid #ccc; padding: 0.4em; text-align: left; }
+.num { text-align: right; }
+</style>
+</head>
+<body>
+<h1>Facture {{.Number}}</h1>
+<p>
+<strong>{{.Seller.Name}}</strong>
+{{- with .Seller.Address}}<br>{{.}}{{end}}
+{{- with .Seller.TaxID}}<br>N° TVA : {{.}}{{end}}
+</p>
+<p>
+Date : {{.IssuedAt.Format "2006-01-02"}}<br>
+Commande : {{.OrderID}}<br>
+Client : {{.UserID}}
+</p>
+<table>
+<thead><tr><th>Désignation</th><th class="num">Quantité</th><th class="num">Prix unitaire {{if .PricesIncludeTax}}TTC{{else}}HT{{end}}</th><th class="num">Montant {{if .PricesIncludeTax}}TTC{{else}}HT{{end}}</th></tr></thead>
+<tbody>
+{{- range .Lines}}
+<tr><td>{{.Description}}</td><td class="num">{{.Quantity}}</td><td class="num">{{.UnitPrice}}</td><td class="num">{{.Amount}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+<table>
+<tr><td>Total HT</td><td class="num">{{.Subtotal}} {{.Currency}}</td></tr>
+{{- range .TaxLines}}
+<tr><td>{{.Name}} ({{.Rate}} %) sur {{.Base}}</td><td class="num">{{.Amount}} {{$.Currency}}</td></tr>
+{{- end}}
+<tr><td>Total taxes</td><td class="num">{{.TaxTotal}} {{.Currency}}</td></tr>
+<tr><th>Total TTC</th><th class="num">{{.Total}} {{.Currency}}</th></tr>
+</table>
+</body>
+</html>
+`))
+
+// RenderHTML writes the invoice as a printable HTML page
+func RenderHTML(w io.Writer, inv database.Invoice) error {
+	return page.Execute(w, inv)
+}
@@ -0,0 +1,112 @@
+// Package invoice drafts the invoices of orders and renders them for
+// customers
+package invoice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/config"
+	"github.com/n-nourdine/play-with-containers/billing-app/database"
+	"github.com/n-nourdine/play-with-containers/billing-app/inventory"
+)
+
+// Builder drafts invoices from orders, naming movies from the inventory
+// catalog
+type Builder struct {
+	catalog *inventory.Client
+	cfg     config.Invoice
+}
+
+func NewBuilder(catalog *inventory.Client, cfg config.Invoice) *Builder {
+	return &Builder{catalog: catalog, cfg: cfg}
+}
+
+// NumberPrefix is prepended to the sequential invoice numbers
+func (b *Builder) NumberPrefix() string {
+	return b.cfg.NumberPrefix
+}
+
+// Build drafts the invoice of an order; it implements database.InvoiceBuilder.
+//
+// Movies that left the catalog are named by their ID. Any other catalog
+// failure is returned, rather than issuing for good an invoice with
+// incomplete descriptions.
+func (b *Builder) Build(ctx context.Context, order database.Order) (database.Invoice, error) {
+	inv := database.Invoice{
+		Currency: order.Currency,
+		Seller: database.Seller{
+			Name:    b.cfg.SellerName,
+			Address: b.cfg.SellerAddress,
+			TaxID:   b.cfg.SellerTaxID,
+		},
+		PricesIncludeTax: order.TaxInclusive,
+		Subtotal:         order.NetAmount,
+		TaxLines:         []database.TaxLine{},
+		TaxTotal:         order.TaxAmount,
+		Total:            order.GrossAmount,
+	}
+	if order.TaxRegion != "" {
+		inv.TaxLines = append(inv.TaxLines, database.TaxLine{
+			Name:   fmt.Sprintf("%s %s", b.cfg.TaxLabel, order.TaxRegion),
+			Rate:   order.TaxRate,
+			Base:   order.NetAmount,
+			Amount: order.TaxAmount,
+		})
+	}
+
+	// Orders stored before line items only know their item count and total
+	if len(order.Items) == 0 {
+		priced := order.NetAmount
+		if order.TaxInclusive {
+			priced = order.GrossAmount
+		}
+		inv.Lines = []database.InvoiceLine{{
+			Description: fmt.Sprintf("Commande de %s article(s)", order.NumberOfItems),
+			Quantity:    1,
+			UnitPrice:   priced,
+			Amount:      priced,
+		}}
+		return inv, nil
+	}
+
+	titles := map[string]string{}
+	for _, item := range order.Items {
+		title, ok := titles[item.MovieID]
+		if !ok {
+			movie, err := b.catalog.Movie(ctx, item.MovieID)
+			switch {
+			case err == nil:
+				title = movie.Title
+			case errors.Is(err, inventory.ErrMovieNotFound):
+				title = "Film " + item.MovieID
+			default:
+				return database.Invoice{}, err
+			}
+			titles[item.MovieID] = title
+		}
+
+		amount, err := item.UnitPrice.Mul(int64(item.Quantity))
+		if err != nil {
+			return database.Invoice{}, err
+		}
+		inv.Lines = append(inv.Lines, database.InvoiceLine{
+			Description: describe(item.Kind, title),
+			MovieID:     item.MovieID,
+			Kind:        item.Kind,
+			Quantity:    item.Quantity,
+			UnitPrice:   item.UnitPrice,
+			Amount:      amount,
+		})
+	}
+	return inv, nil
+}
+
+func describe(kind, title string) string {
+	if kind == database.KindRental {
+		return "Location - " + strconv.Quote(title)
+	}
+	return "Achat - " + strconv.Quote(title)
+}
@@ -4,59 +4,137 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/n-nourdine/play-with-containers/billing-app/config"
 	"github.com/n-nourdine/play-with-containers/billing-app/handler"
+	"github.com/n-nourdine/play-with-containers/billing-app/health"
+	"github.com/n-nourdine/play-with-containers/billing-app/inventory"
+	"github.com/n-nourdine/play-with-containers/billing-app/invoice"
+	"github.com/n-nourdine/play-with-containers/billing-app/metrics"
+	"github.com/n-nourdine/play-with-containers/billing-app/ordering"
+	"github.com/n-nourdine/play-with-containers/billing-app/payment"
+	"github.com/n-nourdine/play-with-containers/billing-app/pricing"
+	"github.com/n-nourdine/play-with-containers/billing-app/rabbitmq"
+	"github.com/n-nourdine/play-with-containers/billing-app/tax"
+	"github.com/n-nourdine/play-with-containers/billing-app/tracing"
+	"github.com/n-nourdine/play-with-containers/shared/logging"
 )
 
 func main() {
-	logger := log.New(os.Stdout, fmt.Sprintf("billing-app running on port %s -> ",
-		os.Getenv("BILLING_APP_PORT")), log.LstdFlags)
-
-	// // Connect to database
-	// store, err := database.NewConn()
-	// if err != nil {
-	// 	logger.Fatalf("Erreur de connexion à la base de données: %v", err)
-	// }
-	// defer store.Close()
-
-	// // Create RabbitMQ consumer
-	// consumer, err := rabbitmq.NewConsumer(logger, store)
-	// if err != nil {
-	// 	logger.Fatalf("Erreur de création du consommateur RabbitMQ: %v", err)
-	// }
-	// defer consumer.Close()
-
-	// // Create context for graceful shutdown
-	// ctx, cancel := context.WithCancel(context.Background())
-	// defer cancel()
-
-	// // Start consuming messages
-	// err = consumer.StartConsuming(ctx)
-	// if err != nil {
-	// 	logger.Fatalf("Erreur lors du démarrage de la consommation: %v", err)
-	// }
-
-	h, err := handler.NewHandler(logger)
+	cfg, err := config.Load()
 	if err != nil {
-		logger.Fatalf("Erreur de connexion à la base de données: %v", err)
+		logging.New("billing-app", "info").Error("failed to load configuration", "error", err)
+		os.Exit(1)
+	}
+
+	logger := logging.New("billing-app", cfg.LogLevel)
+	logger.Info("configuration loaded", "config", cfg.Redacted())
+
+	shutdownTracing, err := tracing.Init(context.Background(), "billing-app", cfg.Tracing)
+	if err != nil {
+		logger.Error("failed to initialize tracing", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownTracing(ctx)
+	}()
+
+	// Lifecycle events go to their own connection so that a slow or blocked
+	// publisher never holds up the consumer
+	events, err := rabbitmq.NewPublisher(logger, cfg.RabbitMQ)
+	if err != nil {
+		logger.Error("failed to create RabbitMQ publisher", "error", err)
+		os.Exit(1)
+	}
+	defer events.Close()
+
+	catalog := inventory.NewClient(cfg.Inventory)
+
+	payments, err := payment.New(logger, cfg.Payment)
+	if err != nil {
+		logger.Error("failed to create payment provider", "error", err)
+		os.Exit(1)
+	}
+
+	// Orders are priced from the inventory catalog
+	pricer := pricing.NewPricer(catalog, cfg.Pricing)
+
+	taxes, err := tax.NewRateTable(cfg.Tax)
+	if err != nil {
+		logger.Error("invalid tax configuration", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("tax rates loaded", "rates", taxes.Regions(), "default_region", cfg.Tax.DefaultRegion, "prices_include_tax", cfg.Tax.PricesIncludeTax)
+
+	// Orders from the queue and from POST /api/order are built alike
+	orders := ordering.NewBuilder(logger, pricer, taxes)
+
+	h, err := handler.NewHandler(logger, cfg.Database, events, orders, invoice.NewBuilder(catalog, cfg.Invoice), payments)
+	if err != nil {
+		logger.Error("failed to connect to database", "error", err)
+		os.Exit(1)
 	}
 	defer h.C.Close()
-	
+
+	metrics.RegisterPool(h.C.Stat)
+
+	checker := health.NewChecker(2 * time.Second)
+	checker.Add("postgres", h.C.Ping)
+	checker.Add("rabbitmq-events", events.Ping)
+
+	// Orders are consumed from billing_queue. The consumer can be turned
+	// off to run the HTTP API alone, e.g. next to another instance
+	// that consumes the queue.
+	var consumer *rabbitmq.Consumer
+	if cfg.RabbitMQ.Consume {
+		consumer, err = rabbitmq.NewConsumer(logger, cfg.RabbitMQ, h.C, events, orders)
+		if err != nil {
+			logger.Error("failed to create RabbitMQ consumer", "error", err)
+			os.Exit(1)
+		}
+		defer consumer.Close()
+
+		if err := consumer.StartConsuming(); err != nil {
+			logger.Error("failed to start consuming", "error", err)
+			os.Exit(1)
+		}
+		checker.Add("rabbitmq", consumer.Ping)
+	} else {
+		logger.Warn("RabbitMQ consumer disabled, orders published to the queue are not processed", "queue", cfg.RabbitMQ.Queue)
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /api/order", h.Add)
 	mux.HandleFunc("GET /api/health", h.Health)
-	mux.HandleFunc("GET /api/orders", h.GetAllOrders)
+	mux.HandleFunc("GET /livez", checker.Live)
+	mux.HandleFunc("GET /readyz", checker.Ready)
+	mux.HandleFunc("GET /api/orders", h.ListOrders)
+	mux.HandleFunc("GET /api/orders/{id}", h.GetOrder)
+	mux.HandleFunc("GET /api/orders/{id}/events", h.OrderEvents)
+	mux.HandleFunc("POST /api/orders/{id}/cancel", h.Cancel)
+	mux.HandleFunc("POST /api/orders/{id}/fulfill", h.Fulfill)
+	mux.HandleFunc("POST /api/orders/{id}/refund", h.Refund)
+	mux.HandleFunc("GET /api/orders/{id}/invoice", h.GetInvoice)
+	mux.HandleFunc("POST /api/orders/{id}/pay", h.Pay)
+	mux.HandleFunc("POST /api/orders/{id}/capture", h.Capture)
+	mux.HandleFunc("GET /api/orders/{id}/payments", h.ListPayments)
+	mux.HandleFunc("POST /api/payments/webhook", h.PaymentWebhook)
+	mux.HandleFunc("GET /api/users/{id}/summary", h.GetUserSummary)
+	mux.HandleFunc("GET /api/reports/revenue", h.GetRevenue)
+	mux.Handle("GET /metrics", metrics.Handler())
 
 	server := &http.Server{
-		Addr:         fmt.Sprintf(":%v", os.Getenv("BILLING_APP_PORT")),
-		Handler:      mux,
-		ErrorLog:     logger,
+		Addr:         fmt.Sprintf(":%v", cfg.Port),
+		Handler:      tracing.Middleware("billing-app", logging.Middleware(logger, metrics.Middleware(tracing.Route(mux)))),
+		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  120 * time.Second,
@@ -64,10 +142,11 @@ func main() {
 
 	// Start HTTP server in goroutine
 	go func() {
-		log.Println()
+		logger.Info("starting billing-app", "port", cfg.Port)
 		err := server.ListenAndServe()
-		if err == nil {
-			log.Fatalf("Error starting server: %s/n", err)
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("HTTP server error", "error", err)
+			os.Exit(1)
 		}
 
 	}()
@@ -77,7 +156,16 @@ func main() {
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	sig := <-c
-	log.Println("Got signal:", sig)
+	logger.Info("received signal", "signal", sig.String())
+
+	// Finish the orders already received before closing the connection
+	if consumer != nil {
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.RabbitMQ.DrainTimeout)
+		defer cancelDrain()
+		if err := consumer.Shutdown(drainCtx); err != nil {
+			logger.Warn("consumer not drained", "error", err)
+		}
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "billing_app"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Total number of HTTP requests handled.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// MessagesConsumed counts deliveries handled by the consumer, by outcome
+	// (acked, rejected or requeued)
+	MessagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "amqp_messages_consumed_total",
+		Help:      "Total number of RabbitMQ deliveries handled by the consumer.",
+	}, []string{"queue", "outcome"})
+
+	// ConsumeFailures counts deliveries that could not be processed, by reason
+	ConsumeFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "amqp_consume_failures_total",
+		Help:      "Total number of RabbitMQ deliveries that failed processing.",
+	}, []string{"queue", "reason"})
+
+	// ConsumerLag observes the age of a message when the consumer picks it up
+	ConsumerLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "amqp_consumer_lag_seconds",
+		Help:      "Time between message publication and consumption.",
+		Buckets:   []float64{.01, .05, .1, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"queue"})
+
+	// ConsumerBatchSize observes the number of orders stored per batch
+	ConsumerBatchSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "amqp_consumer_batch_size",
+		Help:      "Number of orders stored per batch by the consumer.",
+		Buckets:   []float64{1, 2, 5, 10, 25, 50, 100, 250, 500},
+	}, []string{"queue"})
+
+	// QueueTopologyMismatch is 1 when the live queue was declared with other
+	// arguments than the configured ones
+	QueueTopologyMismatch = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "amqp_queue_topology_mismatch",
+		Help:      "Whether the live queue arguments differ from the configuration (1) or not (0).",
+	}, []string{"queue"})
+
+	// EventsPublished counts order lifecycle events sent to the events
+	// exchange, by routing key and outcome (published or failed)
+	EventsPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "amqp_events_published_total",
+		Help:      "Total number of order lifecycle events published.",
+	}, []string{"routing_key", "outcome"})
+
+	// PriceMismatches counts orders whose submitted prices differed from the
+	// catalog, by action taken (rejected or flagged)
+	PriceMismatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "price_mismatches_total",
+		Help:      "Total number of orders submitted with prices that differ from the catalog.",
+	}, []string{"action"})
+
+	// PaymentOperations counts calls to the payment provider and webhook
+	// notifications, by operation (authorize, capture, void, refund or webhook) and
+	// resulting payment status, or "error" when the call failed
+	PaymentOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "payment_operations_total",
+		Help:      "Total number of payment provider operations.",
+	}, []string{"operation", "status"})
+)
+
+// Handler exposes the registered metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware records request counts and latencies by route and status
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rw, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(rw.status)
+		httpRequests.WithLabelValues(r.Method, route, status).Inc()
+		httpDuration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// RegisterPool exports the statistics of a pgx connection pool
+func RegisterPool(stat func() *pgxpool.Stat) {
+	prometheus.MustRegister(&poolCollector{stat: stat})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+var (
+	poolAcquired = prometheus.NewDesc(namespace+"_pgxpool_acquired_conns",
+		"Number of connections currently acquired from the pool.", nil, nil)
+	poolIdle = prometheus.NewDesc(namespace+"_pgxpool_idle_conns",
+		"Number of idle connections in the pool.", nil, nil)
+	poolTotal = prometheus.NewDesc(namespace+"_pgxpool_total_conns",
+		"Total number of connections in the pool.", nil, nil)
+	poolMax = prometheus.NewDesc(namespace+"_pgxpool_max_conns",
+		"Maximum size of the pool.", nil, nil)
+	poolAcquireCount = prometheus.NewDesc(namespace+"_pgxpool_acquire_total",
+		"Cumulative count of successful acquires from the pool.", nil, nil)
+	poolEmptyAcquire = prometheus.NewDesc(namespace+"_pgxpool_empty_acquire_total",
+		"Cumulative count of acquires that had to wait for a connection.", nil, nil)
+	poolWait = prometheus.NewDesc(namespace+"_pgxpool_acquire_wait_seconds_total",
+		"Cumulative time spent waiting to acquire a connection.", nil, nil)
+)
+
+// poolCollector reads pgxpool statistics at scrape time
+type poolCollector struct {
+	stat func() *pgxpool.Stat
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquired
+	ch <- poolIdle
+	ch <- poolTotal
+	ch <- poolMax
+	ch <- poolAcquireCount
+	ch <- poolEmptyAcquire
+	ch <- poolWait
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquired, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdle, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotal, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMax, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireCount, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquire, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolWait, prometheus.CounterValue, s.AcquireDuration().Seconds())
+}
@@ -0,0 +1,181 @@
+// Package ordering builds the orders submitted for billing, whether they
+// arrive through the queue or over HTTP. Submitted amounts are never stored
+// as given: items are priced from the inventory catalog and the total is
+// taxed by the service.
+package ordering
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/database"
+	"github.com/n-nourdine/play-with-containers/billing-app/metrics"
+	"github.com/n-nourdine/play-with-containers/billing-app/money"
+	"github.com/n-nourdine/play-with-containers/billing-app/pricing"
+	"github.com/n-nourdine/play-with-containers/billing-app/tax"
+	"github.com/n-nourdine/play-with-containers/billing-app/util"
+)
+
+// Request is an order submitted for billing. The amounts it carries are only
+// compared with the catalog; the net, tax and gross amounts are computed.
+type Request struct {
+	UserID        string               `json:"user_id"`
+	NumberOfItems string               `json:"number_of_items"`
+	TotalAmount   string               `json:"total_amount"`
+	Currency      string               `json:"currency"`
+	TaxRegion     string               `json:"tax_region"`
+	Items         []database.OrderItem `json:"items"`
+}
+
+// Error explains why a request did not become an order. The reason labels
+// the failure metrics; Retry tells whether a later attempt may succeed.
+type Error struct {
+	Reason string
+	Retry  bool
+	Err    error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// Builder prices and taxes the orders submitted for billing
+type Builder struct {
+	logger *slog.Logger
+	pricer *pricing.Pricer
+	taxes  tax.Calculator
+}
+
+func NewBuilder(logger *slog.Logger, pricer *pricing.Pricer, taxes tax.Calculator) *Builder {
+	return &Builder{logger: logger, pricer: pricer, taxes: taxes}
+}
+
+// Build builds the order described by a request.
+//
+// When the request lists items, they are priced from the inventory catalog
+// in force at time at, and the item count, total and currency are computed
+// from the catalog: the submitted values are only compared with it, and a
+// difference is handled according to the pricing policy. Requests without
+// items cannot be priced and are handled as a mismatch.
+//
+// The total is then taxed at the rate of the tax region of the request, or
+// of the default region.
+func (b *Builder) Build(ctx context.Context, req Request, at time.Time) (database.Order, error) {
+	order, err := b.price(ctx, req, at)
+	if err != nil {
+		return database.Order{}, err
+	}
+
+	breakdown, err := b.taxes.Compute(req.TaxRegion, order.TotalAmount)
+	if err != nil {
+		return database.Order{}, &Error{Reason: "tax", Err: err}
+	}
+	order.ApplyTax(breakdown)
+	return order, nil
+}
+
+// price builds the untaxed order described by a request
+func (b *Builder) price(ctx context.Context, m Request, at time.Time) (database.Order, error) {
+	if m.UserID == "" {
+		return database.Order{}, errors.New("user_id manquant")
+	}
+
+	order := database.Order{
+		ID:       util.NewUUID(),
+		UserID:   m.UserID,
+		Currency: m.Currency,
+		Items:    m.Items,
+	}
+
+	if len(m.Items) == 0 {
+		if m.NumberOfItems == "" || m.TotalAmount == "" {
+			return database.Order{}, errors.New("champs requis manquants: items, ou number_of_items et total_amount")
+		}
+		total, err := money.Parse(m.TotalAmount)
+		if err != nil {
+			return database.Order{}, err
+		}
+		order.NumberOfItems = m.NumberOfItems
+		order.TotalAmount = total
+
+		// Without items the catalog cannot confirm the total, which is
+		// handled as a mismatch
+		if b.pricer.Policy() == pricing.PolicyReject {
+			metrics.PriceMismatches.WithLabelValues("rejected").Inc()
+			return database.Order{}, &Error{
+				Reason: "price_mismatch",
+				Err:    fmt.Errorf("commande sans articles: montant soumis %s %s invérifiable", total, m.Currency),
+			}
+		}
+		metrics.PriceMismatches.WithLabelValues("flagged").Inc()
+		b.logger.WarnContext(ctx, "order without items flagged for review",
+			"order_id", order.ID,
+			"submitted_amount", total.String(),
+			"currency", m.Currency,
+		)
+		order.PriceMismatch = true
+		order.SubmittedAmount = &total
+		return order, nil
+	}
+
+	// What the client asked to pay: the stated total, or else the sum of the
+	// submitted lines
+	_, submitted, err := database.Totals(m.Items)
+	if err != nil {
+		return database.Order{}, err
+	}
+	if m.TotalAmount != "" {
+		if submitted, err = money.Parse(m.TotalAmount); err != nil {
+			return database.Order{}, err
+		}
+	}
+
+	currency, mismatches, err := b.pricer.Reprice(ctx, order.Items, at)
+	if err != nil {
+		if pricing.Rejects(err) {
+			return database.Order{}, &Error{Reason: "pricing", Err: err}
+		}
+		return database.Order{}, &Error{Reason: "catalog_unavailable", Retry: true, Err: err}
+	}
+
+	count, total, err := database.Totals(order.Items)
+	if err != nil {
+		return database.Order{}, err
+	}
+	order.NumberOfItems = strconv.Itoa(count)
+	order.TotalAmount = total
+	order.Currency = currency
+
+	if len(mismatches) == 0 && submitted == total && (m.Currency == "" || m.Currency == currency) {
+		return order, nil
+	}
+
+	details := make([]string, len(mismatches))
+	for i, mm := range mismatches {
+		details[i] = mm.String()
+	}
+	if b.pricer.Policy() == pricing.PolicyReject {
+		metrics.PriceMismatches.WithLabelValues("rejected").Inc()
+		return database.Order{}, &Error{
+			Reason: "price_mismatch",
+			Err: fmt.Errorf("montant soumis %s %s, catalogue %s %s [%s]",
+				submitted, m.Currency, total, currency, strings.Join(details, "; ")),
+		}
+	}
+
+	metrics.PriceMismatches.WithLabelValues("flagged").Inc()
+	b.logger.WarnContext(ctx, "order flagged for price mismatch",
+		"order_id", order.ID,
+		"submitted_amount", submitted.String(),
+		"catalog_amount", total.String(),
+		"currency", currency,
+		"lines", details,
+	)
+	order.PriceMismatch = true
+	order.SubmittedAmount = &submitted
+	return order, nil
+}
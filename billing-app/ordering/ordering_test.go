@@ -0,0 +1,193 @@
+package ordering
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/config"
+	"github.com/n-nourdine/play-with-containers/billing-app/database"
+	"github.com/n-nourdine/play-with-containers/billing-app/inventory"
+	"github.com/n-nourdine/play-with-containers/billing-app/money"
+	"github.com/n-nourdine/play-with-containers/billing-app/pricing"
+	"github.com/n-nourdine/play-with-containers/billing-app/tax"
+)
+
+// catalog sells m1 at 10.00 EUR, or 8.00 after a promotion for rentals
+type catalog struct{ err error }
+
+func (c catalog) Offers(ctx context.Context, movieID string, at time.Time) ([]inventory.Offer, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if movieID != "m1" {
+		return nil, inventory.ErrMovieNotFound
+	}
+	return []inventory.Offer{
+		{Kind: database.KindPurchase, Price: 1000, FinalPrice: 1000, Currency: "EUR"},
+		{Kind: database.KindRental, Price: 1000, FinalPrice: 800, Currency: "EUR"},
+	}, nil
+}
+
+func newBuilder(t *testing.T, policy string, c catalog) *Builder {
+	t.Helper()
+	taxes, err := tax.NewRateTable(config.Tax{Rates: []string{"FR=20"}, DefaultRegion: "FR", PricesIncludeTax: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pricer := pricing.NewPricer(c, config.Pricing{MismatchPolicy: policy})
+	return NewBuilder(slog.New(slog.DiscardHandler), pricer, taxes)
+}
+
+func item(kind string, quantity int, price money.Amount) database.OrderItem {
+	return database.OrderItem{MovieID: "m1", Kind: kind, Quantity: quantity, UnitPrice: price}
+}
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    string
+		req       Request
+		total     money.Amount
+		flagged   bool
+		submitted money.Amount
+	}{
+		{
+			name:   "catalog prices",
+			policy: pricing.PolicyReject,
+			req:    Request{UserID: "u1", Items: []database.OrderItem{item(database.KindPurchase, 2, 1000)}},
+			total:  2000,
+		},
+		{
+			name:   "matching total and currency",
+			policy: pricing.PolicyReject,
+			req:    Request{UserID: "u1", TotalAmount: "8.00", Currency: "EUR", Items: []database.OrderItem{item(database.KindRental, 1, 800)}},
+			total:  800,
+		},
+		{
+			name:      "line mismatch flagged",
+			policy:    pricing.PolicyFlag,
+			req:       Request{UserID: "u1", Items: []database.OrderItem{item(database.KindRental, 1, 100)}},
+			total:     800,
+			flagged:   true,
+			submitted: 100,
+		},
+		{
+			name:      "total mismatch flagged",
+			policy:    pricing.PolicyFlag,
+			req:       Request{UserID: "u1", TotalAmount: "1.00", Items: []database.OrderItem{item(database.KindRental, 1, 800)}},
+			total:     800,
+			flagged:   true,
+			submitted: 100,
+		},
+		{
+			name:      "no items flagged",
+			policy:    pricing.PolicyFlag,
+			req:       Request{UserID: "u1", NumberOfItems: "3", TotalAmount: "12.00", Currency: "EUR"},
+			total:     1200,
+			flagged:   true,
+			submitted: 1200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := newBuilder(t, tt.policy, catalog{}).Build(context.Background(), tt.req, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if order.TotalAmount != tt.total || order.GrossAmount != tt.total {
+				t.Errorf("total %s gross %s, want %s", order.TotalAmount, order.GrossAmount, tt.total)
+			}
+			if order.NetAmount+order.TaxAmount != order.GrossAmount || order.TaxRegion != "FR" {
+				t.Errorf("tax not applied: net %s tax %s region %q", order.NetAmount, order.TaxAmount, order.TaxRegion)
+			}
+			if order.PriceMismatch != tt.flagged {
+				t.Errorf("price_mismatch %v, want %v", order.PriceMismatch, tt.flagged)
+			}
+			if tt.flagged && (order.SubmittedAmount == nil || *order.SubmittedAmount != tt.submitted) {
+				t.Errorf("submitted_amount %v, want %s", order.SubmittedAmount, tt.submitted)
+			}
+			if order.ID == "" || order.UserID != tt.req.UserID {
+				t.Errorf("order %q of user %q", order.ID, order.UserID)
+			}
+		})
+	}
+}
+
+func TestBuildRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		catalog catalog
+		req     Request
+		reason  string
+		retry   bool
+	}{
+		{
+			name:   "line mismatch",
+			policy: pricing.PolicyReject,
+			req:    Request{UserID: "u1", Items: []database.OrderItem{item(database.KindRental, 1, 100)}},
+			reason: "price_mismatch",
+		},
+		{
+			name:   "currency mismatch",
+			policy: pricing.PolicyReject,
+			req:    Request{UserID: "u1", Currency: "USD", Items: []database.OrderItem{item(database.KindPurchase, 1, 1000)}},
+			reason: "price_mismatch",
+		},
+		{
+			name:   "no items",
+			policy: pricing.PolicyReject,
+			req:    Request{UserID: "u1", NumberOfItems: "1", TotalAmount: "10.00"},
+			reason: "price_mismatch",
+		},
+		{
+			name:   "unknown movie",
+			policy: pricing.PolicyFlag,
+			req:    Request{UserID: "u1", Items: []database.OrderItem{{MovieID: "nope", Quantity: 1, UnitPrice: 100}}},
+			reason: "pricing",
+		},
+		{
+			name:    "catalog unavailable",
+			policy:  pricing.PolicyFlag,
+			catalog: catalog{err: errors.New("connection refused")},
+			req:     Request{UserID: "u1", Items: []database.OrderItem{item(database.KindPurchase, 1, 1000)}},
+			reason:  "catalog_unavailable",
+			retry:   true,
+		},
+		{
+			name:   "unknown tax region",
+			policy: pricing.PolicyReject,
+			req:    Request{UserID: "u1", TaxRegion: "DE", Items: []database.OrderItem{item(database.KindPurchase, 1, 1000)}},
+			reason: "tax",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newBuilder(t, tt.policy, tt.catalog).Build(context.Background(), tt.req, time.Now())
+			var oerr *Error
+			if !errors.As(err, &oerr) {
+				t.Fatalf("Build = %v, want an *Error", err)
+			}
+			if oerr.Reason != tt.reason || oerr.Retry != tt.retry {
+				t.Errorf("reason %q retry %v, want %q %v", oerr.Reason, oerr.Retry, tt.reason, tt.retry)
+			}
+		})
+	}
+}
+
+func TestBuildInvalid(t *testing.T) {
+	for _, req := range []Request{
+		{Items: []database.OrderItem{item(database.KindPurchase, 1, 1000)}},
+		{UserID: "u1"},
+		{UserID: "u1", NumberOfItems: "1", TotalAmount: "ten"},
+	} {
+		_, err := newBuilder(t, pricing.PolicyFlag, catalog{}).Build(context.Background(), req, time.Now())
+		var oerr *Error
+		if err == nil || errors.As(err, &oerr) {
+			t.Errorf("Build(%+v) = %v, want a validation error", req, err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/config"
+	"github.com/n-nourdine/play-with-containers/billing-app/money"
+	"github.com/n-nourdine/play-with-containers/billing-app/util"
+)
+
+// Payment methods understood by the fake provider; any other method is
+// accepted synchronously
+const (
+	// FakeDeclined is refused at authorization
+	FakeDeclined = "fake_declined"
+	// FakeAsync answers every call with StatusPending and reports the result
+	// through the webhook after a delay
+	FakeAsync = "fake_async"
+)
+
+// Fake is an in-process provider for local runs and tests. It keeps payments
+// in memory, so they are forgotten when the service restarts, and signs its
+// webhooks like a real provider would.
+type Fake struct {
+	logger     *slog.Logger
+	secret     string
+	webhookURL string
+	delay      time.Duration
+	http       *http.Client
+
+	mu       sync.Mutex
+	payments map[string]*fakePayment
+}
+
+type fakePayment struct {
+	amount money.Amount
+	status Status
+	async  bool
+}
+
+func NewFake(logger *slog.Logger, cfg config.Payment) *Fake {
+	return &Fake{
+		logger:     logger,
+		secret:     cfg.WebhookSecret,
+		webhookURL: cfg.FakeWebhookURL,
+		delay:      cfg.FakeDelay,
+		http:       &http.Client{Timeout: 3 * time.Second},
+		payments:   map[string]*fakePayment{},
+	}
+}
+
+func (f *Fake) Name() string { return "fake" }
+
+func (f *Fake) Authorize(ctx context.Context, a Authorization) (Result, error) {
+	id := "fake_" + util.NewUUID()
+	if a.Method == FakeDeclined {
+		return Result{PaymentID: id, Status: StatusFailed, Reason: "paiement refusé"}, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := &fakePayment{amount: a.Amount, status: StatusPending, async: a.Method == FakeAsync}
+	f.payments[id] = p
+	return f.settle(id, p, StatusAuthorized), nil
+}
+
+func (f *Fake) Capture(ctx context.Context, paymentID string, amount money.Amount) (Result, error) {
+	return f.move(paymentID, amount, StatusAuthorized, StatusCaptured)
+}
+
+func (f *Fake) Void(ctx context.Context, paymentID string) (Result, error) {
+	return f.move(paymentID, 0, StatusAuthorized, StatusVoided)
+}
+
+func (f *Fake) Refund(ctx context.Context, paymentID string, amount money.Amount) (Result, error) {
+	return f.move(paymentID, amount, StatusCaptured, StatusRefunded)
+}
+
+func (f *Fake) ParseWebhook(header http.Header, body []byte) (Event, error) {
+	if err := Verify(f.secret, header.Get(SignatureHeader), body, time.Now()); err != nil {
+		return Event{}, err
+	}
+	var e Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		return Event{}, fmt.Errorf("événement de paiement invalide: %w", err)
+	}
+	return e, nil
+}
+
+// move takes a payment from status from to status to
+func (f *Fake) move(paymentID string, amount money.Amount, from, to Status) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[paymentID]
+	if !ok {
+		return Result{}, fmt.Errorf("%w: %s", ErrUnknownPayment, paymentID)
+	}
+	if p.status != from {
+		return Result{PaymentID: paymentID, Status: StatusFailed, Reason: fmt.Sprintf("paiement %s, %s attendu", p.status, from)}, nil
+	}
+	if amount > p.amount {
+		return Result{PaymentID: paymentID, Status: StatusFailed, Reason: fmt.Sprintf("montant %s supérieur à %s", amount, p.amount)}, nil
+	}
+	return f.settle(paymentID, p, to), nil
+}
+
+// settle moves the payment to status, at once or, for asynchronous
+// payments, after the delay and with a webhook. The caller holds f.mu.
+func (f *Fake) settle(id string, p *fakePayment, status Status) Result {
+	if !p.async {
+		p.status = status
+		return Result{PaymentID: id, Status: status}
+	}
+
+	time.AfterFunc(f.delay, func() {
+		f.mu.Lock()
+		p.status = status
+		f.mu.Unlock()
+		f.notify(Event{ID: "evt_" + util.NewUUID(), PaymentID: id, Status: status})
+	})
+	return Result{PaymentID: id, Status: StatusPending}
+}
+
+// notify sends a signed event to the webhook
+func (f *Fake) notify(e Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		f.logger.Error("error encoding payment event", "error", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, f.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		f.logger.Error("error creating payment webhook request", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(f.secret, time.Now(), body))
+
+	resp, err := f.http.Do(req)
+	if err != nil {
+		f.logger.Warn("payment webhook delivery failed", "payment_id", e.PaymentID, "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		f.logger.Warn("payment webhook rejected", "payment_id", e.PaymentID, "status", resp.StatusCode)
+	}
+}
@@ -0,0 +1,152 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/config"
+)
+
+const testSecret = "s3cret"
+
+func newTestFake(webhookURL string) *Fake {
+	return NewFake(slog.New(slog.DiscardHandler), config.Payment{
+		WebhookSecret:  testSecret,
+		FakeWebhookURL: webhookURL,
+		FakeDelay:      10 * time.Millisecond,
+	})
+}
+
+func TestFakeSync(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFake("")
+
+	res, err := f.Authorize(ctx, Authorization{OrderID: "o1", Amount: 1000, Currency: "EUR", Method: "card"})
+	if err != nil || res.Status != StatusAuthorized {
+		t.Fatalf("Authorize = %+v, %v; want authorized", res, err)
+	}
+	id := res.PaymentID
+
+	steps := []struct {
+		name string
+		call func() (Result, error)
+		want Status
+	}{
+		{"refund before capture", func() (Result, error) { return f.Refund(ctx, id, 1000) }, StatusFailed},
+		{"capture more than authorized", func() (Result, error) { return f.Capture(ctx, id, 1001) }, StatusFailed},
+		{"capture", func() (Result, error) { return f.Capture(ctx, id, 1000) }, StatusCaptured},
+		{"capture twice", func() (Result, error) { return f.Capture(ctx, id, 1000) }, StatusFailed},
+		{"void after capture", func() (Result, error) { return f.Void(ctx, id) }, StatusFailed},
+		{"refund", func() (Result, error) { return f.Refund(ctx, id, 1000) }, StatusRefunded},
+	}
+	for _, s := range steps {
+		res, err := s.call()
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if res.Status != s.want {
+			t.Fatalf("%s: status %s, want %s (%s)", s.name, res.Status, s.want, res.Reason)
+		}
+	}
+
+	if _, err := f.Capture(ctx, "fake_unknown", 1000); !errors.Is(err, ErrUnknownPayment) {
+		t.Errorf("Capture of an unknown payment = %v, want ErrUnknownPayment", err)
+	}
+}
+
+func TestFakeDeclined(t *testing.T) {
+	f := newTestFake("")
+	res, err := f.Authorize(context.Background(), Authorization{OrderID: "o1", Amount: 1000, Method: FakeDeclined})
+	if err != nil || res.Status != StatusFailed || res.Reason == "" {
+		t.Fatalf("Authorize = %+v, %v; want failed with a reason", res, err)
+	}
+}
+
+func TestFakeVoid(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFake("")
+	res, _ := f.Authorize(ctx, Authorization{OrderID: "o1", Amount: 1000})
+
+	if res, err := f.Void(ctx, res.PaymentID); err != nil || res.Status != StatusVoided {
+		t.Fatalf("Void = %+v, %v; want voided", res, err)
+	}
+	if res, err := f.Capture(ctx, res.PaymentID, 1000); err != nil || res.Status != StatusFailed {
+		t.Fatalf("Capture after void = %+v, %v; want failed", res, err)
+	}
+}
+
+// TestFakeAsyncWebhook follows an asynchronous payment from authorization
+// to capture through the signed webhooks of the provider
+func TestFakeAsyncWebhook(t *testing.T) {
+	events := make(chan Event, 2)
+	var f *Fake
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		e, err := f.ParseWebhook(r.Header, body)
+		if err != nil {
+			t.Errorf("ParseWebhook: %v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		events <- e
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	f = newTestFake(srv.URL)
+
+	ctx := context.Background()
+	res, err := f.Authorize(ctx, Authorization{OrderID: "o1", Amount: 1000, Method: FakeAsync})
+	if err != nil || res.Status != StatusPending {
+		t.Fatalf("Authorize = %+v, %v; want pending", res, err)
+	}
+	id := res.PaymentID
+	authorized := receive(t, events)
+	if authorized.PaymentID != id || authorized.Status != StatusAuthorized {
+		t.Fatalf("first event %+v, want %s authorized", authorized, id)
+	}
+
+	if res, err := f.Capture(ctx, id, 1000); err != nil || res.Status != StatusPending {
+		t.Fatalf("Capture = %+v, %v; want pending", res, err)
+	}
+	captured := receive(t, events)
+	if captured.PaymentID != id || captured.Status != StatusCaptured {
+		t.Fatalf("second event %+v, want %s captured", captured, id)
+	}
+	if captured.ID == "" || captured.ID == authorized.ID {
+		t.Errorf("event IDs %q and %q must be set and distinct", authorized.ID, captured.ID)
+	}
+}
+
+func TestFakeWebhookRejectsForgery(t *testing.T) {
+	f := newTestFake("")
+	body := []byte(`{"id":"evt_1","payment_id":"fake_1","status":"captured"}`)
+	header := http.Header{}
+
+	header.Set(SignatureHeader, Sign("forged", time.Now(), body))
+	if _, err := f.ParseWebhook(header, body); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("forged signature: %v, want ErrInvalidSignature", err)
+	}
+
+	// A captured request replayed after the tolerance is refused
+	header.Set(SignatureHeader, Sign(testSecret, time.Now().Add(-SignatureTolerance-time.Minute), body))
+	if _, err := f.ParseWebhook(header, body); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("replayed request: %v, want ErrInvalidSignature", err)
+	}
+}
+
+func receive(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("no webhook received")
+		return Event{}
+	}
+}
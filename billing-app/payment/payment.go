@@ -0,0 +1,88 @@
+// Package payment takes the payment of orders through a payment provider
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/config"
+	"github.com/n-nourdine/play-with-containers/billing-app/money"
+)
+
+// Status is the state of a payment at the provider
+type Status string
+
+const (
+	// StatusPending means the authorization was requested and its result
+	// will arrive by webhook
+	StatusPending    Status = "pending"
+	StatusAuthorized Status = "authorized"
+	StatusCaptured   Status = "captured"
+	StatusRefunded   Status = "refunded"
+	// StatusVoided means the authorization was released without capture
+	StatusVoided Status = "voided"
+	// StatusFailed means the payment was declined or could not be completed
+	StatusFailed Status = "failed"
+)
+
+var (
+	ErrUnknownPayment   = errors.New("paiement inconnu du prestataire")
+	ErrInvalidSignature = errors.New("signature de webhook invalide")
+)
+
+// Authorization asks the provider to reserve the amount of an order
+type Authorization struct {
+	OrderID  string
+	Amount   money.Amount
+	Currency string
+	// Method identifies the means of payment, as given by the client
+	Method string
+}
+
+// Result is the outcome of a provider call. Status is the new status of the
+// payment, or StatusPending when the provider answers asynchronously; the
+// result is then delivered later as an Event.
+type Result struct {
+	PaymentID string
+	Status    Status
+	// Reason explains a failure
+	Reason string
+}
+
+// Event is an asynchronous result reported by the provider through its
+// webhook. ID identifies the notification, so that redeliveries can be
+// ignored.
+type Event struct {
+	ID        string `json:"id"`
+	PaymentID string `json:"payment_id"`
+	Status    Status `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Provider is a payment provider. Funds are first authorized, then
+// captured; only a captured payment is paid. An authorization that will not
+// be captured is voided, which releases the funds. Any call may answer
+// StatusPending and report its result later through the webhook.
+type Provider interface {
+	Name() string
+	Authorize(ctx context.Context, a Authorization) (Result, error)
+	Capture(ctx context.Context, paymentID string, amount money.Amount) (Result, error)
+	Void(ctx context.Context, paymentID string) (Result, error)
+	Refund(ctx context.Context, paymentID string, amount money.Amount) (Result, error)
+
+	// ParseWebhook checks the signature of a webhook request and decodes
+	// its event; it returns ErrInvalidSignature for forged or stale requests
+	ParseWebhook(header http.Header, body []byte) (Event, error)
+}
+
+// New returns the provider selected by the configuration
+func New(logger *slog.Logger, cfg config.Payment) (Provider, error) {
+	switch cfg.Provider {
+	case "fake":
+		return NewFake(logger, cfg), nil
+	}
+	return nil, fmt.Errorf("prestataire de paiement inconnu: %q", cfg.Provider)
+}
@@ -0,0 +1,60 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries the signature of webhook requests as
+// "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">"
+const SignatureHeader = "X-Payment-Signature"
+
+// SignatureTolerance is how old a signed webhook may be, which bounds the
+// replay of captured requests
+const SignatureTolerance = 5 * time.Minute
+
+// Sign returns the SignatureHeader value of body sent at time t
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac(secret, ts, body))
+}
+
+// Verify checks a SignatureHeader value against body at time now
+func Verify(secret, header string, body []byte, now time.Time) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return fmt.Errorf("%w: en-tête %s mal formé", ErrInvalidSignature, SignatureHeader)
+	}
+
+	got, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, mac(secret, ts, body)) {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return fmt.Errorf("%w: horodatage hors tolérance", ErrInvalidSignature)
+	}
+	return nil
+}
+
+func mac(secret, ts string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(ts))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
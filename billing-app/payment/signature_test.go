@@ -0,0 +1,60 @@
+package payment
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	const secret = "s3cret"
+	now := time.Unix(1_700_000_000, 0)
+	body := []byte(`{"id":"evt_1","payment_id":"fake_1","status":"captured"}`)
+	valid := Sign(secret, now, body)
+
+	ts, sig, _ := strings.Cut(valid, ",")
+
+	// flip the last hex digit of the signature
+	last := byte('0')
+	if valid[len(valid)-1] == '0' {
+		last = '1'
+	}
+	tampered := valid[:len(valid)-1] + string(last)
+
+	tests := []struct {
+		name   string
+		header string
+		body   []byte
+		now    time.Time
+		ok     bool
+	}{
+		{"valid", valid, body, now, true},
+		{"parts reordered", sig + ", " + ts, body, now, true},
+		{"just within tolerance", valid, body, now.Add(SignatureTolerance), true},
+		{"clock behind within tolerance", valid, body, now.Add(-SignatureTolerance), true},
+		{"too old", valid, body, now.Add(SignatureTolerance + time.Second), false},
+		{"from the future", valid, body, now.Add(-SignatureTolerance - time.Second), false},
+		{"tampered body", valid, []byte(`{"id":"evt_1","payment_id":"fake_2","status":"captured"}`), now, false},
+		{"tampered signature", tampered, body, now, false},
+		{"other secret", Sign("other", now, body), body, now, false},
+		{"empty", "", body, now, false},
+		{"no signature", "t=1700000000", body, now, false},
+		{"bad timestamp", "t=yesterday,v1=00", body, now, false},
+		{"not hex", "t=1700000000,v1=zz", body, now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(secret, tt.header, tt.body, tt.now)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("Verify: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("Verify = %v, want ErrInvalidSignature", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// Package pricing recomputes order prices from the inventory catalog so that
+// the amounts submitted by clients are never trusted
+package pricing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/config"
+	"github.com/n-nourdine/play-with-containers/billing-app/database"
+	"github.com/n-nourdine/play-with-containers/billing-app/inventory"
+	"github.com/n-nourdine/play-with-containers/billing-app/money"
+)
+
+// Policies applied when submitted prices differ from the catalog
+const (
+	PolicyReject = "reject"
+	PolicyFlag   = "flag"
+)
+
+var (
+	ErrNotForSale      = errors.New("film non disponible pour ce type d'offre")
+	ErrMixedCurrencies = errors.New("les articles de la commande ont des devises différentes")
+)
+
+// Mismatch is a line whose submitted unit price is not the catalog price
+type Mismatch struct {
+	MovieID   string
+	Kind      string
+	Submitted money.Amount
+	Catalog   money.Amount
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s/%s: %s au lieu de %s", m.MovieID, m.Kind, m.Submitted, m.Catalog)
+}
+
+// Catalog returns the offers of a movie in force at a given time;
+// inventory.Client is the catalog of the inventory service
+type Catalog interface {
+	Offers(ctx context.Context, movieID string, at time.Time) ([]inventory.Offer, error)
+}
+
+// Pricer prices order items from the inventory catalog
+type Pricer struct {
+	catalog Catalog
+	policy  string
+}
+
+func NewPricer(catalog Catalog, cfg config.Pricing) *Pricer {
+	return &Pricer{catalog: catalog, policy: cfg.MismatchPolicy}
+}
+
+// Policy returns what to do with orders whose submitted prices differ from
+// the catalog: PolicyReject or PolicyFlag
+func (p *Pricer) Policy() string {
+	return p.policy
+}
+
+// Reprice replaces the unit price of every item with the catalog price in
+// force at time at, promotions included. It returns the currency of the
+// order and the lines whose submitted price was different. Items without a
+// kind are priced as purchases.
+func (p *Pricer) Reprice(ctx context.Context, items []database.OrderItem, at time.Time) (string, []Mismatch, error) {
+	var (
+		currency   string
+		mismatches []Mismatch
+		offers     = map[string][]inventory.Offer{}
+	)
+
+	for i := range items {
+		item := &items[i]
+		if item.Kind == "" {
+			item.Kind = database.KindPurchase
+		}
+
+		movieOffers, ok := offers[item.MovieID]
+		if !ok {
+			var err error
+			if movieOffers, err = p.catalog.Offers(ctx, item.MovieID, at); err != nil {
+				return "", nil, err
+			}
+			offers[item.MovieID] = movieOffers
+		}
+
+		offer, ok := find(movieOffers, item.Kind)
+		if !ok {
+			return "", nil, fmt.Errorf("%w: %s (%s)", ErrNotForSale, item.MovieID, item.Kind)
+		}
+		if currency == "" {
+			currency = offer.Currency
+		} else if offer.Currency != currency {
+			return "", nil, fmt.Errorf("%w: %s et %s", ErrMixedCurrencies, currency, offer.Currency)
+		}
+
+		if item.UnitPrice != offer.FinalPrice {
+			mismatches = append(mismatches, Mismatch{
+				MovieID:   item.MovieID,
+				Kind:      item.Kind,
+				Submitted: item.UnitPrice,
+				Catalog:   offer.FinalPrice,
+			})
+		}
+		item.UnitPrice = offer.FinalPrice
+	}
+
+	return currency, mismatches, nil
+}
+
+// Rejects reports whether the error means the order can never be priced, as
+// opposed to the catalog being temporarily unavailable
+func Rejects(err error) bool {
+	return errors.Is(err, inventory.ErrMovieNotFound) ||
+		errors.Is(err, ErrNotForSale) ||
+		errors.Is(err, ErrMixedCurrencies)
+}
+
+func find(offers []inventory.Offer, kind string) (inventory.Offer, bool) {
+	for _, o := range offers {
+		if o.Kind == kind {
+			return o, true
+		}
+	}
+	return inventory.Offer{}, false
+}
@@ -0,0 +1,155 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/config"
+	"github.com/n-nourdine/play-with-containers/billing-app/database"
+	"github.com/n-nourdine/play-with-containers/billing-app/inventory"
+)
+
+// stubCatalog serves fixed offers and counts the lookups per movie
+type stubCatalog struct {
+	offers map[string][]inventory.Offer
+	err    error
+	calls  map[string]int
+}
+
+func (c *stubCatalog) Offers(ctx context.Context, movieID string, at time.Time) ([]inventory.Offer, error) {
+	if c.calls == nil {
+		c.calls = map[string]int{}
+	}
+	c.calls[movieID]++
+	if c.err != nil {
+		return nil, c.err
+	}
+	offers, ok := c.offers[movieID]
+	if !ok {
+		return nil, inventory.ErrMovieNotFound
+	}
+	return offers, nil
+}
+
+func newCatalog() *stubCatalog {
+	return &stubCatalog{offers: map[string][]inventory.Offer{
+		// 20 % off the rental
+		"m1": {
+			{Kind: database.KindPurchase, Price: 999, FinalPrice: 999, Currency: "EUR"},
+			{Kind: database.KindRental, Price: 399, FinalPrice: 319, Currency: "EUR"},
+		},
+		"m2":     {{Kind: database.KindPurchase, Price: 1499, FinalPrice: 1499, Currency: "EUR"}},
+		"dollar": {{Kind: database.KindPurchase, Price: 500, FinalPrice: 500, Currency: "USD"}},
+	}}
+}
+
+func TestReprice(t *testing.T) {
+	tests := []struct {
+		name       string
+		items      []database.OrderItem
+		prices     []int64
+		currency   string
+		mismatches []Mismatch
+	}{
+		{
+			name: "catalog prices",
+			items: []database.OrderItem{
+				{MovieID: "m1", Kind: database.KindRental, Quantity: 1, UnitPrice: 319},
+				{MovieID: "m2", Kind: database.KindPurchase, Quantity: 2, UnitPrice: 1499},
+			},
+			prices:   []int64{319, 1499},
+			currency: "EUR",
+		},
+		{
+			name: "promotion ignored by the client",
+			items: []database.OrderItem{
+				{MovieID: "m1", Kind: database.KindRental, Quantity: 1, UnitPrice: 399},
+			},
+			prices:     []int64{319},
+			currency:   "EUR",
+			mismatches: []Mismatch{{MovieID: "m1", Kind: database.KindRental, Submitted: 399, Catalog: 319}},
+		},
+		{
+			name: "kind defaults to purchase",
+			items: []database.OrderItem{
+				{MovieID: "m1", Quantity: 1, UnitPrice: 1},
+			},
+			prices:     []int64{999},
+			currency:   "EUR",
+			mismatches: []Mismatch{{MovieID: "m1", Kind: database.KindPurchase, Submitted: 1, Catalog: 999}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPricer(newCatalog(), config.Pricing{})
+			currency, mismatches, err := p.Reprice(context.Background(), tt.items, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if currency != tt.currency {
+				t.Errorf("currency %q, want %q", currency, tt.currency)
+			}
+			for i, item := range tt.items {
+				if int64(item.UnitPrice) != tt.prices[i] {
+					t.Errorf("item %d priced %s, want %d", i, item.UnitPrice, tt.prices[i])
+				}
+			}
+			if len(mismatches) != len(tt.mismatches) {
+				t.Fatalf("mismatches %v, want %v", mismatches, tt.mismatches)
+			}
+			for i := range mismatches {
+				if mismatches[i] != tt.mismatches[i] {
+					t.Errorf("mismatch %d = %v, want %v", i, mismatches[i], tt.mismatches[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRepriceFetchesEachMovieOnce(t *testing.T) {
+	catalog := newCatalog()
+	items := []database.OrderItem{
+		{MovieID: "m1", Kind: database.KindRental, Quantity: 1, UnitPrice: 319},
+		{MovieID: "m1", Kind: database.KindPurchase, Quantity: 1, UnitPrice: 999},
+	}
+	if _, _, err := NewPricer(catalog, config.Pricing{}).Reprice(context.Background(), items, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if catalog.calls["m1"] != 1 {
+		t.Errorf("m1 fetched %d times, want 1", catalog.calls["m1"])
+	}
+}
+
+func TestRepriceErrors(t *testing.T) {
+	unavailable := errors.New("connection refused")
+	tests := []struct {
+		name    string
+		items   []database.OrderItem
+		err     error
+		want    error
+		rejects bool
+	}{
+		{"unknown movie", []database.OrderItem{{MovieID: "nope", Quantity: 1}}, nil, inventory.ErrMovieNotFound, true},
+		{"not for rent", []database.OrderItem{{MovieID: "m2", Kind: database.KindRental, Quantity: 1}}, nil, ErrNotForSale, true},
+		{"mixed currencies", []database.OrderItem{
+			{MovieID: "m2", Quantity: 1},
+			{MovieID: "dollar", Quantity: 1},
+		}, nil, ErrMixedCurrencies, true},
+		{"catalog unavailable", []database.OrderItem{{MovieID: "m1", Quantity: 1}}, unavailable, unavailable, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			catalog := newCatalog()
+			catalog.err = tt.err
+			_, _, err := NewPricer(catalog, config.Pricing{}).Reprice(context.Background(), tt.items, time.Now())
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Reprice = %v, want %v", err, tt.want)
+			}
+			if Rejects(err) != tt.rejects {
+				t.Errorf("Rejects(%v) = %v, want %v", err, !tt.rejects, tt.rejects)
+			}
+		})
+	}
+}
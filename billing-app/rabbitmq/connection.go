@@ -0,0 +1,29 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const dialAttempts = 10
+
+// dial connects to the broker, retrying with a linearly increasing delay while
+// RabbitMQ is still starting
+func dial(logger *slog.Logger, rabbitURL string) (*amqp.Connection, error) {
+	var conn *amqp.Connection
+	var err error
+
+	for i := 0; i < dialAttempts; i++ {
+		conn, err = amqp.Dial(rabbitURL)
+		if err == nil {
+			return conn, nil
+		}
+		logger.Warn("RabbitMQ connection attempt failed", "attempt", i+1, "max_attempts", dialAttempts, "error", err)
+		time.Sleep(time.Duration(i+1) * time.Second)
+	}
+
+	return nil, fmt.Errorf("impossible de se connecter à RabbitMQ après %d tentatives: %w", dialAttempts, err)
+}
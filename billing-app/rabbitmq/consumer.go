@@ -4,46 +4,59 @@ package rabbitmq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/n-nourdine/play-with-containers/billing-app/config"
 	"github.com/n-nourdine/play-with-containers/billing-app/database"
-	"github.com/n-nourdine/play-with-containers/billing-app/util"
+	"github.com/n-nourdine/play-with-containers/billing-app/message"
+	"github.com/n-nourdine/play-with-containers/billing-app/metrics"
+	"github.com/n-nourdine/play-with-containers/billing-app/ordering"
+	"github.com/n-nourdine/play-with-containers/billing-app/tracing"
+	"github.com/n-nourdine/play-with-containers/shared/logging"
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Consumer struct {
 	conn    *amqp.Connection
 	channel *amqp.Channel
-	logger  *log.Logger
+	logger  *slog.Logger
 	store   *database.OrderStore
-}
+	events  *Publisher
+	orders  *ordering.Builder
+	queue   string
+	// exchange and keys are what the queue is bound to
+	exchange string
+	keys     []string
 
-func NewConsumer(logger *log.Logger, store *database.OrderStore) (*Consumer, error) {
-	rabbitURL := fmt.Sprintf("amqp://%s:%s@%s:%s/",
-		os.Getenv("RABBITMQ_USER"),
-		os.Getenv("RABBITMQ_PASSWORD"),
-		os.Getenv("RABBITMQ_HOST"),
-		os.Getenv("RABBITMQ_PORT"),
-	)
+	prefetch     int
+	workers      int
+	batchSize    int
+	batchTimeout time.Duration
 
-	// Retry connection with exponential backoff
-	var conn *amqp.Connection
-	var err error
+	pool     []*worker
+	running  sync.WaitGroup
+	draining atomic.Bool
 
-	for i := 0; i < 10; i++ {
-		conn, err = amqp.Dial(rabbitURL)
-		if err == nil {
-			break
-		}
-		logger.Printf("Tentative de connexion RabbitMQ %d/10 échouée: %v", i+1, err)
-		time.Sleep(time.Duration(i+1) * time.Second)
-	}
+	// channelClosed is set once the broker or the client closes the channel
+	// the queue is declared on
+	channelClosed atomic.Bool
+}
 
+// NewConsumer connects to RabbitMQ, declares the billing queue and binds it to
+// the billing exchange with the configured routing keys. Orders are
+// built by orders before being stored, and announced through events.
+func NewConsumer(logger *slog.Logger, cfg config.RabbitMQ, store *database.OrderStore, events *Publisher, orders *ordering.Builder) (*Consumer, error) {
+	conn, err := dial(logger, cfg.URL())
 	if err != nil {
-		return nil, fmt.Errorf("impossible de se connecter à RabbitMQ après 10 tentatives: %w", err)
+		return nil, err
 	}
 
 	channel, err := conn.Channel()
@@ -52,141 +65,531 @@ func NewConsumer(logger *log.Logger, store *database.OrderStore) (*Consumer, err
 		return nil, fmt.Errorf("impossible d'ouvrir un canal RabbitMQ: %w", err)
 	}
 
-	// Declare the queue (idempotent operation)
-	queueName := os.Getenv("RABBITMQ_QUEUE_NAME")
-	if queueName == "" {
-		queueName = "billing_queue"
+	if err := declareQueue(logger, conn, channel, cfg.Queue, cfg.Topology); err != nil {
+		channel.Close()
+		conn.Close()
+		return nil, err
 	}
 
-	_, err = channel.QueueDeclare(
-		queueName, // name
-		true,      // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
-	)
-	if err != nil {
+	// Declare the exchange the gateway publishes to and bind the queue to it,
+	// whichever of the two starts first
+	if err := bind(channel, cfg.Exchange, cfg.Queue, cfg.BindingKeys); err != nil {
 		channel.Close()
 		conn.Close()
-		return nil, fmt.Errorf("impossible de déclarer la queue: %w", err)
+		return nil, err
 	}
 
-	return &Consumer{
+	c := &Consumer{
 		conn:    conn,
 		channel: channel,
 		logger:  logger,
 		store:   store,
-	}, nil
+		events:  events,
+		orders:  orders,
+		queue:   cfg.Queue,
+
+		exchange: cfg.Exchange,
+		keys:     cfg.BindingKeys,
+
+		prefetch:     cfg.Prefetch,
+		workers:      cfg.Workers,
+		batchSize:    cfg.BatchSize,
+		batchTimeout: cfg.BatchTimeout,
+	}
+	watchChannel(channel, &c.channelClosed)
+
+	return c, nil
 }
 
-func (c *Consumer) StartConsuming(ctx context.Context) error {
-	queueName := os.Getenv("RABBITMQ_QUEUE_NAME")
-	if queueName == "" {
-		queueName = "billing_queue"
+// declareQueue declares the billing queue with the configured arguments.
+// RabbitMQ closes the channel with PRECONDITION_FAILED when the queue already
+// exists with other arguments, so the declaration is first tried on a scratch
+// channel. On a mismatch the live queue is kept as it is, since changing its
+// arguments means deleting it with its messages: the difference is logged
+// and reported by the amqp_queue_topology_mismatch metric.
+func declareQueue(logger *slog.Logger, conn *amqp.Connection, channel *amqp.Channel, queue string, topology config.QueueTopology) error {
+	args := amqp.Table(topology.Arguments())
+
+	scratch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("impossible d'ouvrir un canal RabbitMQ: %w", err)
+	}
+	_, err = scratch.QueueDeclare(
+		queue, // name
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		args,  // arguments
+	)
+	if err == nil {
+		scratch.Close()
+		metrics.QueueTopologyMismatch.WithLabelValues(queue).Set(0)
+		logger.Info("queue declared", "queue", queue, "arguments", args)
+		return nil
 	}
 
-	// Set QoS to process one message at a time
-	err := c.channel.Qos(
-		1,     // prefetch count
-		0,     // prefetch size
-		false, // global
+	// The broker closed the scratch channel
+	var amqpErr *amqp.Error
+	if !errors.As(err, &amqpErr) || amqpErr.Code != amqp.PreconditionFailed {
+		return fmt.Errorf("impossible de déclarer la queue: %w", err)
+	}
+	metrics.QueueTopologyMismatch.WithLabelValues(queue).Set(1)
+	logger.Error("queue exists with other arguments than configured, using it as it is",
+		"queue", queue, "arguments", args, "reason", amqpErr.Reason)
+
+	if _, err := channel.QueueDeclarePassive(queue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("impossible de vérifier la queue: %w", err)
+	}
+	return nil
+}
+
+// bind declares the topic exchange and binds queue to it with each key
+func bind(channel *amqp.Channel, exchange, queue string, keys []string) error {
+	err := channel.ExchangeDeclare(
+		exchange, // name
+		"topic",  // kind
+		true,     // durable
+		false,    // auto-deleted
+		false,    // internal
+		false,    // no-wait
+		nil,      // arguments
 	)
 	if err != nil {
-		return fmt.Errorf("impossible de définir QoS: %w", err)
+		return fmt.Errorf("impossible de déclarer l'exchange: %w", err)
+	}
+	for _, key := range keys {
+		if err := channel.QueueBind(queue, key, exchange, false, nil); err != nil {
+			return fmt.Errorf("impossible de lier la queue à %s avec la clé %s: %w", exchange, key, err)
+		}
 	}
+	return nil
+}
+
+// worker consumes the billing queue on its own channel, so that its
+// prefetch and acknowledgements do not interfere with the other workers
+type worker struct {
+	id      int
+	channel *amqp.Channel
+	tag     string
 
-	messages, err := c.channel.Consume(
-		queueName, // queue
-		"",        // consumer
-		false,     // auto-ack (we'll manually ack)
-		false,     // exclusive
-		false,     // no-local
-		false,     // no-wait
-		nil,       // args
+	// closed is set once the broker or the client closes the channel
+	closed atomic.Bool
+}
+
+// StartConsuming starts the workers, each consuming the billing queue on its
+// own channel until Shutdown. A worker processes one message at a time, or
+// batches of messages when the batch size is above 1.
+func (c *Consumer) StartConsuming() error {
+	for i := range c.workers {
+		w, err := c.startWorker(i)
+		if err != nil {
+			return err
+		}
+		c.pool = append(c.pool, w)
+	}
+
+	c.logger.Info("waiting for messages", "queue", c.queue, "exchange", c.exchange, "binding_keys", c.keys, "prefetch", c.prefetch, "workers", c.workers, "batch_size", c.batchSize,
+		"message_versions", message.Versions(message.OrderCreated))
+	return nil
+}
+
+func (c *Consumer) startWorker(id int) (*worker, error) {
+	channel, err := c.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("impossible d'ouvrir un canal RabbitMQ: %w", err)
+	}
+
+	// Bound the deliveries in flight on the channel: enough to keep the
+	// worker, or a whole batch, busy
+	err = channel.Qos(
+		c.prefetch, // prefetch count
+		0,          // prefetch size
+		false,      // global
 	)
 	if err != nil {
-		return fmt.Errorf("impossible de commencer la consommation: %w", err)
+		channel.Close()
+		return nil, fmt.Errorf("impossible de définir QoS: %w", err)
 	}
 
-	c.logger.Printf("En attente de messages sur la queue '%s'. Pour sortir, appuyez sur CTRL+C", queueName)
+	w := &worker{id: id, channel: channel, tag: fmt.Sprintf("billing-worker-%d", id)}
+	messages, err := channel.Consume(
+		c.queue, // queue
+		w.tag,   // consumer
+		false,   // auto-ack (we'll manually ack)
+		false,   // exclusive
+		false,   // no-local
+		false,   // no-wait
+		nil,     // args
+	)
+	if err != nil {
+		channel.Close()
+		return nil, fmt.Errorf("impossible de commencer la consommation: %w", err)
+	}
+	watchChannel(channel, &w.closed)
 
+	c.running.Add(1)
 	go func() {
-		for {
-			select {
-			case msg, ok := <-messages:
-				if !ok {
-					c.logger.Println("Canal de messages fermé")
-					return
+		defer c.running.Done()
+		if c.batchSize > 1 {
+			c.consumeBatches(messages)
+		} else {
+			c.consume(messages)
+		}
+		if c.draining.Load() {
+			c.logger.Info("worker drained", "worker", w.id)
+		} else {
+			c.logger.Warn("message channel closed", "worker", w.id)
+		}
+	}()
+	return w, nil
+}
+
+// consume processes messages one at a time until the delivery channel is
+// closed
+func (c *Consumer) consume(messages <-chan amqp.Delivery) {
+	for msg := range messages {
+		if p := c.prepare(msg); p != nil {
+			c.storeOrder(p)
+		}
+	}
+}
+
+// consumeBatches gathers messages into batches until the delivery channel is
+// closed, then stores what is left. A batch is stored when it is full or
+// batchTimeout after its first message.
+//
+// Batches are acknowledged with multiple=true, which also acknowledges every
+// earlier delivery of the channel: each worker therefore has its own channel,
+// and settles a batch entirely before starting the next one.
+func (c *Consumer) consumeBatches(messages <-chan amqp.Delivery) {
+	batch := make([]amqp.Delivery, 0, c.batchSize)
+	timer := time.NewTimer(c.batchTimeout)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				if len(batch) > 0 {
+					c.processBatch(batch)
 				}
-				c.processMessage(msg)
-			case <-ctx.Done():
-				c.logger.Println("Arrêt du consommateur...")
 				return
 			}
+			batch = append(batch, msg)
+			if len(batch) == 1 {
+				timer.Reset(c.batchTimeout)
+			}
+			if len(batch) < c.batchSize {
+				continue
+			}
+			timer.Stop()
+		case <-timer.C:
+		}
+		c.processBatch(batch)
+		batch = batch[:0]
+	}
+}
+
+// processBatch turns the messages into orders, then stores the valid ones
+// together
+func (c *Consumer) processBatch(batch []amqp.Delivery) {
+	ready := make([]*pendingOrder, 0, len(batch))
+	for _, msg := range batch {
+		if p := c.prepare(msg); p != nil {
+			ready = append(ready, p)
 		}
+	}
+	if len(ready) > 0 {
+		c.storeBatch(ready)
+	}
+}
+
+// Shutdown stops the consumer: the broker stops sending messages, and the
+// messages already received are processed and acknowledged. It returns when
+// every worker is done, or with an error when ctx expires first; messages
+// still unacknowledged are then requeued by the broker on Close.
+func (c *Consumer) Shutdown(ctx context.Context) error {
+	c.draining.Store(true)
+	for _, w := range c.pool {
+		// The delivery channel is closed once the deliveries already
+		// received have been handed over
+		if err := w.channel.Cancel(w.tag, false); err != nil {
+			c.logger.Warn("error cancelling consumer", "worker", w.id, "error", err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.running.Wait()
+		close(done)
 	}()
+	select {
+	case <-done:
+		c.logger.Info("consumer stopped")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("arrêt du consommateur interrompu, messages en cours remis en file: %w", ctx.Err())
+	}
+}
 
-	return nil
+// pendingOrder is an order built from a message and not stored yet. The
+// span covers the processing of the message and ends once it is settled.
+type pendingOrder struct {
+	msg   amqp.Delivery
+	ctx   context.Context
+	span  trace.Span
+	order database.Order
 }
 
-func (c *Consumer) processMessage(msg amqp.Delivery) {
-	c.logger.Printf("Message reçu: %s", string(msg.Body))
+// prepare turns a message into an order. Messages that cannot become an
+// order are rejected or requeued, and nil is returned.
+func (c *Consumer) prepare(msg amqp.Delivery) *pendingOrder {
+	queue := c.queue
+	if !msg.Timestamp.IsZero() {
+		metrics.ConsumerLag.WithLabelValues(queue).Observe(time.Since(msg.Timestamp).Seconds())
+	}
 
-	// Parse the JSON message
-	var orderData struct {
-		UserID        string `json:"user_id"`
-		NumberOfItems string `json:"number_of_items"`
-		TotalAmount   string `json:"total_amount"`
+	// Continue the trace and request ID started by the gateway
+	ctx := context.Background()
+	if id, ok := msg.Headers[logging.AMQPRequestIDHeader].(string); ok {
+		ctx = logging.WithRequestID(ctx, id)
 	}
+	ctx, span := tracing.Tracer().Start(tracing.ExtractAMQP(ctx, msg.Headers),
+		queue+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", queue),
+		))
+
+	c.logger.DebugContext(ctx, "message received", "queue", queue, "size", len(msg.Body))
 
-	err := json.Unmarshal(msg.Body, &orderData)
+	// Decode the message, check it against its schema, then read the order
+	var orderData ordering.Request
+	var env message.Envelope
+	msgType := msg.Type
+	if msgType == "" {
+		msgType = message.OrderCreated
+	}
+	codec, err := deliveryCodec(msg)
+	body := msg.Body
+	if err == nil {
+		body, err = codec.Decode(msgType, deliveryVersion(msg), msg.Body)
+	}
+	if err == nil {
+		env, err = message.Decode(body, message.OrderCreated)
+	}
+	if err == nil && env.Type != message.OrderCreated {
+		err = fmt.Errorf("%w: type %s", message.ErrUnsupportedVersion, env.Type)
+	}
+	if err == nil {
+		err = json.Unmarshal(env.Payload, &orderData)
+	}
 	if err != nil {
-		c.logger.Printf("Erreur lors du parsing JSON: %v", err)
-		// Reject the message without requeue since it's malformed
+		reason := "malformed"
+		switch {
+		case codec == nil:
+			reason = "encoding"
+		case errors.Is(err, message.ErrUnsupportedVersion):
+			reason = "unsupported_version"
+		case errors.Is(err, message.ErrInvalid):
+			reason = "schema"
+		}
+		c.logger.WarnContext(ctx, "invalid message rejected", "reason", reason, "message_id", msg.MessageId, "error", err)
+		span.SetStatus(codes.Error, reason)
+		defer span.End()
+		// Reject the message without requeue: no retry can make it valid
 		msg.Nack(false, false)
-		return
+		metrics.ConsumeFailures.WithLabelValues(queue, reason).Inc()
+		metrics.MessagesConsumed.WithLabelValues(queue, "rejected").Inc()
+		c.rejected(ctx, msg.MessageId, reason, err)
+		return nil
 	}
+	span.SetAttributes(
+		attribute.String("messaging.message.id", env.ID),
+		attribute.Int("messaging.message.version", env.Version),
+		attribute.String("messaging.message.encoding", codec.Name()),
+	)
 
-	// Validate required fields
-	if orderData.UserID == "" || orderData.NumberOfItems == "" || orderData.TotalAmount == "" {
-		c.logger.Printf("Champs requis manquants dans le message: %+v", orderData)
+	submittedAt := msg.Timestamp
+	if submittedAt.IsZero() {
+		submittedAt = time.Now()
+	}
+	order, err := c.orders.Build(ctx, orderData, submittedAt)
+	if err != nil {
+		defer span.End()
+		var oerr *ordering.Error
+		if !errors.As(err, &oerr) {
+			oerr = &ordering.Error{Reason: "invalid", Err: err}
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, oerr.Reason)
+		metrics.ConsumeFailures.WithLabelValues(queue, oerr.Reason).Inc()
+		if oerr.Retry {
+			c.logger.ErrorContext(ctx, "order message requeued", "user_id", orderData.UserID, "reason", oerr.Reason, "error", err)
+			msg.Nack(false, true)
+			metrics.MessagesConsumed.WithLabelValues(queue, "requeued").Inc()
+			return nil
+		}
+		c.logger.WarnContext(ctx, "order message rejected", "user_id", orderData.UserID, "reason", oerr.Reason, "error", err)
 		msg.Nack(false, false)
-		return
+		metrics.MessagesConsumed.WithLabelValues(queue, "rejected").Inc()
+		c.rejected(ctx, msg.MessageId, oerr.Reason, err)
+		return nil
 	}
 
-	// Create order with generated ID
-	order := database.Order{
-		ID:            util.NewUUID(),
-		UserID:        orderData.UserID,
-		NumberOfItems: orderData.NumberOfItems,
-		TotalAmount:   orderData.TotalAmount,
-	}
+	return &pendingOrder{msg: msg, ctx: ctx, span: span, order: order}
+}
+
+// storeOrder stores a single order and acknowledges its message, or requeues
+// it if the order could not be stored
+func (c *Consumer) storeOrder(p *pendingOrder) {
+	defer p.span.End()
 
 	// Store in database
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(p.ctx, 10*time.Second)
 	defer cancel()
 
-	err = c.store.CreateOrder(ctx, order)
+	event, err := c.store.CreateOrder(ctx, p.order)
 	if err != nil {
-		c.logger.Printf("Erreur lors de la sauvegarde en base: %v", err)
+		c.logger.ErrorContext(ctx, "error storing order, message requeued", "error", err)
+		p.span.RecordError(err)
+		p.span.SetStatus(codes.Error, "store failed")
 		// Reject and requeue the message for retry
-		msg.Nack(false, true)
+		p.msg.Nack(false, true)
+		metrics.ConsumeFailures.WithLabelValues(c.queue, "store").Inc()
+		metrics.MessagesConsumed.WithLabelValues(c.queue, "requeued").Inc()
 		return
 	}
 
 	// Acknowledge the message
-	err = msg.Ack(false)
+	if err := p.msg.Ack(false); err != nil {
+		c.logger.ErrorContext(ctx, "error acknowledging message", "error", err)
+		metrics.ConsumeFailures.WithLabelValues(c.queue, "ack").Inc()
+		return
+	}
+	c.stored(ctx, p.msg.MessageId, p.order, event)
+}
+
+// storeBatch stores the orders in one transaction and acknowledges their
+// messages at once. If the batch fails, the orders are stored one by one so
+// that a single bad order only fails its own message.
+func (c *Consumer) storeBatch(batch []*pendingOrder) {
+	ctx, span := tracing.Tracer().Start(context.Background(), c.queue+" store batch",
+		trace.WithAttributes(attribute.Int("messaging.batch.message_count", len(batch))))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	orders := make([]database.Order, len(batch))
+	last := batch[0]
+	for i, p := range batch {
+		orders[i] = p.order
+		span.AddLink(trace.LinkFromContext(p.ctx))
+		if p.msg.DeliveryTag > last.msg.DeliveryTag {
+			last = p
+		}
+	}
+
+	events, err := c.store.CreateOrders(ctx, orders)
 	if err != nil {
-		c.logger.Printf("Erreur lors de l'accusé de réception: %v", err)
+		c.logger.WarnContext(ctx, "error storing order batch, storing orders one by one", "size", len(batch), "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "batch store failed")
+		metrics.ConsumeFailures.WithLabelValues(c.queue, "batch").Inc()
+		for _, p := range batch {
+			c.storeOrder(p)
+		}
+		return
+	}
+	metrics.ConsumerBatchSize.WithLabelValues(c.queue).Observe(float64(len(batch)))
+
+	// Every earlier delivery of the channel is already settled: rejected
+	// messages were nacked one by one
+	ackErr := last.msg.Ack(true)
+	if ackErr != nil {
+		c.logger.ErrorContext(ctx, "error acknowledging message batch", "size", len(batch), "error", ackErr)
+	}
+	for i, p := range batch {
+		if ackErr != nil {
+			metrics.ConsumeFailures.WithLabelValues(c.queue, "ack").Inc()
+		} else {
+			c.stored(p.ctx, p.msg.MessageId, orders[i], events[i])
+		}
+		p.span.End()
+	}
+}
+
+// stored follows up an order stored and acknowledged
+func (c *Consumer) stored(ctx context.Context, messageID string, order database.Order, event database.OrderEvent) {
+	metrics.MessagesConsumed.WithLabelValues(c.queue, "acked").Inc()
+
+	c.logger.InfoContext(ctx, "order processed",
+		"order_id", order.ID,
+		"user_id", order.UserID,
+		"number_of_items", order.NumberOfItems,
+		"total_amount", order.TotalAmount.String(),
+		"currency", order.Currency,
+		"lines", len(order.Items),
+		"price_mismatch", order.PriceMismatch,
+	)
+
+	// The order is stored whatever happens next: a lost event is logged
+	// rather than redelivering the message
+	if err := c.events.PublishOrderEvent(ctx, event); err != nil {
+		c.logger.WarnContext(ctx, "error publishing order event", "order_id", order.ID, "error", err)
+	}
+	c.outcome(ctx, MessageOutcome{MessageID: messageID, Status: OutcomePersisted, OrderID: order.ID})
+}
+
+// rejected announces a message rejected for good
+func (c *Consumer) rejected(ctx context.Context, messageID, reason string, err error) {
+	c.outcome(ctx, MessageOutcome{MessageID: messageID, Status: OutcomeRejected, Reason: reason, Detail: err.Error()})
+}
+
+// outcome publishes the outcome of a message, so that the gateway can report
+// it to the client. Messages without ID cannot be tracked.
+func (c *Consumer) outcome(ctx context.Context, outcome MessageOutcome) {
+	if outcome.MessageID == "" {
 		return
 	}
+	outcome.OccurredAt = time.Now().UTC()
+	if err := c.events.PublishOutcome(ctx, outcome); err != nil {
+		c.logger.WarnContext(ctx, "error publishing message outcome", "message_id", outcome.MessageID, "status", outcome.Status, "error", err)
+	}
+}
+
+// Ping reports whether the AMQP connection and channel are still open
+func (c *Consumer) Ping(ctx context.Context) error {
+	if c.conn == nil || c.conn.IsClosed() {
+		return errors.New("AMQP connection closed")
+	}
+	if c.channelClosed.Load() {
+		return errors.New("AMQP channel closed")
+	}
+	for _, w := range c.pool {
+		if w.closed.Load() {
+			return fmt.Errorf("AMQP channel of worker %d closed", w.id)
+		}
+	}
+	return nil
+}
 
-	c.logger.Printf("Commande traitée avec succès: ID=%s, UserID=%s, Items=%s, Total=%s",
-		order.ID, order.UserID, order.NumberOfItems, order.TotalAmount)
+// watchChannel flags the channel as closed as soon as it stops being usable
+func watchChannel(ch *amqp.Channel, closed *atomic.Bool) {
+	notify := ch.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		<-notify
+		closed.Store(true)
+	}()
 }
 
 func (c *Consumer) Close() {
+	for _, w := range c.pool {
+		w.channel.Close()
+	}
 	if c.channel != nil {
 		c.channel.Close()
 	}
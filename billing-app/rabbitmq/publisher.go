@@ -0,0 +1,191 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/config"
+	"github.com/n-nourdine/play-with-containers/billing-app/database"
+	"github.com/n-nourdine/play-with-containers/billing-app/metrics"
+	"github.com/n-nourdine/play-with-containers/billing-app/tracing"
+	"github.com/n-nourdine/play-with-containers/shared/logging"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Publisher sends order lifecycle events to a topic exchange. Events are
+// routed with the key billing.order.<status> so that other services can bind
+// to the transitions they care about, e.g. billing.order.* or
+// billing.order.refunded. The outcome of each billing message is published to
+// the same exchange with the key billing.message.<outcome>.
+type Publisher struct {
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	logger   *slog.Logger
+	exchange string
+
+	// channelClosed is set once the broker or the client closes the channel
+	channelClosed atomic.Bool
+}
+
+func NewPublisher(logger *slog.Logger, cfg config.RabbitMQ) (*Publisher, error) {
+	conn, err := dial(logger, cfg.URL())
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("impossible d'ouvrir un canal RabbitMQ: %w", err)
+	}
+
+	// Declare the exchange (idempotent operation)
+	err = channel.ExchangeDeclare(
+		cfg.EventsExchange, // name
+		"topic",            // kind
+		true,               // durable
+		false,              // auto-deleted
+		false,              // internal
+		false,              // no-wait
+		nil,                // arguments
+	)
+	if err != nil {
+		channel.Close()
+		conn.Close()
+		return nil, fmt.Errorf("impossible de déclarer l'exchange: %w", err)
+	}
+
+	p := &Publisher{
+		conn:     conn,
+		channel:  channel,
+		logger:   logger,
+		exchange: cfg.EventsExchange,
+	}
+	watchChannel(channel, &p.channelClosed)
+
+	return p, nil
+}
+
+// RoutingKey returns the routing key of the event announcing an order status
+func RoutingKey(status database.OrderStatus) string {
+	return "billing.order." + string(status)
+}
+
+// PublishOrderEvent announces a status change of an order
+func (p *Publisher) PublishOrderEvent(ctx context.Context, event database.OrderEvent) error {
+	key := RoutingKey(event.To)
+	if err := p.publish(ctx, key, event); err != nil {
+		return err
+	}
+	p.logger.DebugContext(ctx, "order event published", "exchange", p.exchange, "routing_key", key, "order_id", event.OrderID)
+	return nil
+}
+
+// Outcomes of a billing message
+const (
+	OutcomePersisted = "persisted"
+	OutcomeRejected  = "rejected"
+)
+
+// MessageOutcome tells what became of a billing message, identified by the
+// message ID the gateway returned as tracking ID
+type MessageOutcome struct {
+	MessageID string `json:"message_id"`
+	Status    string `json:"status"`
+	// OrderID is set when the order was persisted, Reason and Detail when
+	// the message was rejected
+	OrderID    string    `json:"order_id,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// OutcomeRoutingKey returns the routing key of the outcome events of the
+// given status
+func OutcomeRoutingKey(status string) string {
+	return "billing.message." + status
+}
+
+// PublishOutcome announces that a billing message was persisted or rejected
+func (p *Publisher) PublishOutcome(ctx context.Context, outcome MessageOutcome) error {
+	key := OutcomeRoutingKey(outcome.Status)
+	if err := p.publish(ctx, key, outcome); err != nil {
+		return err
+	}
+	p.logger.DebugContext(ctx, "message outcome published", "exchange", p.exchange, "routing_key", key, "message_id", outcome.MessageID)
+	return nil
+}
+
+// publish sends v as JSON to the exchange with the given routing key
+func (p *Publisher) publish(ctx context.Context, key string, v any) error {
+	ctx, span := tracing.Tracer().Start(ctx, p.exchange+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", p.exchange),
+			attribute.String("messaging.rabbitmq.destination.routing_key", key),
+		))
+	defer span.End()
+
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("impossible d'encoder l'événement: %w", err)
+	}
+
+	// Carry the trace context and request ID to the subscribers
+	headers := amqp.Table{}
+	tracing.InjectAMQP(ctx, headers)
+	if id := logging.RequestID(ctx); id != "" {
+		headers[logging.AMQPRequestIDHeader] = id
+	}
+
+	err = p.channel.Publish(
+		p.exchange, // exchange
+		key,        // routing key
+		false,      // mandatory
+		false,      // immediate
+		amqp.Publishing{
+			Headers:      headers,
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Body:         body,
+			Timestamp:    time.Now(),
+		},
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "publish failed")
+		metrics.EventsPublished.WithLabelValues(key, "failed").Inc()
+		return fmt.Errorf("impossible de publier l'événement: %w", err)
+	}
+	metrics.EventsPublished.WithLabelValues(key, "published").Inc()
+	return nil
+}
+
+// Ping reports whether the AMQP connection and channel are still open
+func (p *Publisher) Ping(ctx context.Context) error {
+	if p.conn == nil || p.conn.IsClosed() {
+		return errors.New("AMQP connection closed")
+	}
+	if p.channelClosed.Load() {
+		return errors.New("AMQP channel closed")
+	}
+	return nil
+}
+
+func (p *Publisher) Close() {
+	if p.channel != nil {
+		p.channel.Close()
+	}
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
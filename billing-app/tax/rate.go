@@ -0,0 +1,65 @@
+package tax
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/n-nourdine/play-with-containers/billing-app/money"
+)
+
+// Rate is a tax rate in hundredths of a percent: Rate(2000) is 20 %,
+// Rate(725) is 7.25 %. It is written as a decimal percentage, e.g. "7.25",
+// in JSON and to NUMERIC columns.
+type Rate int64
+
+// percent is 100 % as a Rate
+const percent Rate = 100 * 100
+
+// ParseRate reads a percentage with at most two fractional digits, between 0
+// and 100
+func ParseRate(s string) (Rate, error) {
+	// A rate has the same decimal form as an amount
+	a, err := money.Parse(s)
+	if err != nil || a < 0 || Rate(a) > percent {
+		return 0, fmt.Errorf("taux invalide: %q", s)
+	}
+	return Rate(a), nil
+}
+
+// String formats the rate without trailing zeros, e.g. "20" or "5.5"
+func (r Rate) String() string {
+	s := money.Amount(r).String()
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// UnmarshalJSON accepts both "7.25" and 7.25
+func (r *Rate) UnmarshalJSON(b []byte) error {
+	v, err := ParseRate(strings.Trim(string(b), `"`))
+	if err != nil {
+		return err
+	}
+	*r = v
+	return nil
+}
+
+// ScanNumeric reads a NUMERIC column
+func (r *Rate) ScanNumeric(n pgtype.Numeric) error {
+	var a money.Amount
+	if err := a.ScanNumeric(n); err != nil {
+		return err
+	}
+	*r = Rate(a)
+	return nil
+}
+
+// NumericValue writes the rate to a NUMERIC column
+func (r Rate) NumericValue() (pgtype.Numeric, error) {
+	return money.Amount(r).NumericValue()
+}
@@ -0,0 +1,57 @@
+package tax
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Rate
+		str  string
+	}{
+		{"20", 2000, "20"},
+		{"7.25", 725, "7.25"},
+		{"5.5", 550, "5.5"},
+		{"5.50", 550, "5.5"},
+		{"0.5", 50, "0.5"},
+		{"0", 0, "0"},
+		{"100", 10000, "100"},
+		{" 19.6 ", 1960, "19.6"},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if err != nil {
+			t.Errorf("ParseRate(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+		if s := got.String(); s != tt.str {
+			t.Errorf("Rate(%d).String() = %q, want %q", got, s, tt.str)
+		}
+	}
+}
+
+func TestParseRateInvalid(t *testing.T) {
+	for _, in := range []string{"", "-1", "-0.01", "100.01", "7.255", "abc", "20%", "1e2"} {
+		if r, err := ParseRate(in); err == nil {
+			t.Errorf("ParseRate(%q) = %s, want an error", in, r)
+		}
+	}
+}
+
+func TestRateJSON(t *testing.T) {
+	for _, in := range []string{`"7.25"`, `7.25`} {
+		var r Rate
+		if err := json.Unmarshal([]byte(in), &r); err != nil || r != 725 {
+			t.Errorf("Unmarshal(%s) = %d, %v; want 725", in, r, err)
+		}
+	}
+	b, err := json.Marshal(Rate(550))
+	if err != nil || string(b) != `"5.5"` {
+		t.Errorf("Marshal(550) = %s, %v; want \"5.5\"", b, err)
+	}
+}
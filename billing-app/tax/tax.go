@@ -0,0 +1,139 @@
+// Package tax computes the VAT or sales tax due on orders
+package tax
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/config"
+	"github.com/n-nourdine/play-with-containers/billing-app/money"
+)
+
+// ErrUnknownRegion means no rate is configured for the region of an order
+var ErrUnknownRegion = errors.New("aucun taux de taxe pour cette région")
+
+// Breakdown splits the amount of an order into net, tax and gross
+type Breakdown struct {
+	Region    string
+	Rate      Rate
+	Inclusive bool
+	Net       money.Amount
+	Tax       money.Amount
+	Gross     money.Amount
+}
+
+// Calculator computes the tax due on an amount for a country or region.
+// Amounts are tax-inclusive or tax-exclusive depending on the calculator.
+type Calculator interface {
+	Compute(region string, amount money.Amount) (Breakdown, error)
+}
+
+// RateTable is a Calculator applying a fixed rate per country or region
+type RateTable struct {
+	rates         map[string]Rate
+	defaultRegion string
+	inclusive     bool
+}
+
+// NewRateTable reads the rate table from the configuration
+func NewRateTable(cfg config.Tax) (*RateTable, error) {
+	t := &RateTable{
+		rates:         map[string]Rate{},
+		defaultRegion: normalize(cfg.DefaultRegion),
+		inclusive:     cfg.PricesIncludeTax,
+	}
+	for _, entry := range cfg.Rates {
+		region, value, ok := strings.Cut(entry, "=")
+		region = normalize(region)
+		if !ok || region == "" {
+			return nil, fmt.Errorf("TAX_RATES: %q: REGION=TAUX attendu", entry)
+		}
+		rate, err := ParseRate(value)
+		if err != nil {
+			return nil, fmt.Errorf("TAX_RATES: %s: %w", region, err)
+		}
+		if _, dup := t.rates[region]; dup {
+			return nil, fmt.Errorf("TAX_RATES: %s apparaît deux fois", region)
+		}
+		t.rates[region] = rate
+	}
+	if t.defaultRegion != "" {
+		if _, err := t.rate(t.defaultRegion); err != nil {
+			return nil, fmt.Errorf("TAX_DEFAULT_REGION: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// Regions lists the configured regions with their rate, e.g. "FR=20"
+func (t *RateTable) Regions() []string {
+	out := make([]string, 0, len(t.rates))
+	for region, rate := range t.rates {
+		out = append(out, region+"="+rate.String())
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Compute splits amount according to the rate of region, or of the default
+// region if empty.
+//
+// The rate is applied once to the whole amount, not per line. Tax-exclusive
+// amounts get their tax rounded half away from zero to the cent;
+// tax-inclusive amounts get their net rounded the same way. The third value
+// is derived from the other two, so that net plus tax always equals gross.
+func (t *RateTable) Compute(region string, amount money.Amount) (Breakdown, error) {
+	region = normalize(region)
+	if region == "" {
+		region = t.defaultRegion
+	}
+	rate, err := t.rate(region)
+	if err != nil {
+		return Breakdown{}, err
+	}
+
+	b := Breakdown{Region: region, Rate: rate, Inclusive: t.inclusive}
+	if t.inclusive {
+		b.Gross = amount
+		b.Net = money.Amount(mulDivRound(int64(amount), int64(percent), int64(percent)+int64(rate)))
+		b.Tax = b.Gross - b.Net
+	} else {
+		b.Net = amount
+		b.Tax = money.Amount(mulDivRound(int64(amount), int64(rate), int64(percent)))
+		b.Gross = b.Net + b.Tax
+	}
+	return b, nil
+}
+
+// rate returns the rate of a region, falling back to its country
+func (t *RateTable) rate(region string) (Rate, error) {
+	if rate, ok := t.rates[region]; ok {
+		return rate, nil
+	}
+	if country, _, ok := strings.Cut(region, "-"); ok {
+		if rate, ok := t.rates[country]; ok {
+			return rate, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownRegion, region)
+}
+
+func normalize(region string) string {
+	return strings.ToUpper(strings.TrimSpace(region))
+}
+
+// mulDivRound returns a*m/d rounded half away from zero; m and d are
+// positive. It works on big integers as a*m may not fit in 64 bits.
+func mulDivRound(a, m, d int64) int64 {
+	n := new(big.Int).Mul(big.NewInt(a), big.NewInt(2*m))
+	half := big.NewInt(d)
+	if a < 0 {
+		n.Sub(n, half)
+	} else {
+		n.Add(n, half)
+	}
+	return n.Quo(n, big.NewInt(2*d)).Int64()
+}
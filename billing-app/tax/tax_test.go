@@ -0,0 +1,142 @@
+package tax
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/config"
+	"github.com/n-nourdine/play-with-containers/billing-app/money"
+)
+
+func TestMulDivRound(t *testing.T) {
+	tests := []struct {
+		a, m, d int64
+		want    int64
+	}{
+		{4, 1, 2, 2},
+		{5, 1, 2, 3},   // 2.5
+		{-5, 1, 2, -3}, // -2.5
+		{1, 1, 3, 0},   // 0.33
+		{2, 1, 3, 1},   // 0.67
+		{-2, 1, 3, -1},
+		{0, 2000, 10000, 0},
+		{1000, 2000, 10000, 200},
+		{5, 1000, 10000, 1},   // 0.5
+		{-5, 1000, 10000, -1}, // -0.5
+		{4, 1000, 10000, 0},   // 0.4
+		// a*m overflows 64 bits
+		{math.MaxInt64 / 2, 10000, 10000, math.MaxInt64 / 2},
+		{math.MinInt64 / 2, 10000, 10000, math.MinInt64 / 2},
+	}
+	for _, tt := range tests {
+		if got := mulDivRound(tt.a, tt.m, tt.d); got != tt.want {
+			t.Errorf("mulDivRound(%d, %d, %d) = %d, want %d", tt.a, tt.m, tt.d, got, tt.want)
+		}
+	}
+}
+
+func newTable(t *testing.T, inclusive bool, rates ...string) *RateTable {
+	t.Helper()
+	table, err := NewRateTable(config.Tax{Rates: rates, DefaultRegion: "FR", PricesIncludeTax: inclusive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestCompute(t *testing.T) {
+	rates := []string{"FR=20", "HALF=10", "ZERO=0", "FULL=100", "US-CA=7.25"}
+	tests := []struct {
+		name      string
+		inclusive bool
+		region    string
+		amount    money.Amount
+		net, tax  money.Amount
+	}{
+		{"exclusive", false, "FR", 1000, 1000, 200},
+		{"exclusive half cent", false, "HALF", 5, 5, 1},
+		{"exclusive below half cent", false, "HALF", 4, 4, 0},
+		{"exclusive refund", false, "FR", -1000, -1000, -200},
+		{"exclusive refund half cent", false, "HALF", -5, -5, -1},
+		{"exclusive 0%", false, "ZERO", 999, 999, 0},
+		{"exclusive 100%", false, "FULL", 999, 999, 999},
+		{"exclusive region rate", false, "US-CA", 1000, 1000, 73}, // 72.5
+		{"inclusive", true, "FR", 1200, 1000, 200},
+		{"inclusive rounded net", true, "FR", 999, 833, 166}, // 832.5
+		{"inclusive half cent", true, "FULL", 1, 1, 0},       // net 0.5
+		{"inclusive refund", true, "FR", -1200, -1000, -200},
+		{"inclusive refund half cent", true, "FULL", -1, -1, 0},
+		{"inclusive 0%", true, "ZERO", 999, 999, 0},
+		{"inclusive 100%", true, "FULL", 1000, 500, 500},
+		{"default region", true, "", 1200, 1000, 200},
+		{"country fallback", true, "fr-75", 1200, 1000, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := newTable(t, tt.inclusive, rates...).Compute(tt.region, tt.amount)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.Net != tt.net || b.Tax != tt.tax {
+				t.Errorf("Compute(%q, %s) = net %s tax %s, want net %s tax %s", tt.region, tt.amount, b.Net, b.Tax, tt.net, tt.tax)
+			}
+			if b.Net+b.Tax != b.Gross {
+				t.Errorf("net %s + tax %s != gross %s", b.Net, b.Tax, b.Gross)
+			}
+		})
+	}
+}
+
+// TestComputeAddsUp checks that net plus tax equals gross and that the tax
+// is within half a cent of the exact value, for many amounts and rates
+func TestComputeAddsUp(t *testing.T) {
+	rates := []string{"R0=0", "R1=5.5", "R2=7.25", "R3=19.6", "R4=20", "R5=100"}
+	for _, inclusive := range []bool{false, true} {
+		table := newTable(t, inclusive, append(rates, "FR=20")...)
+		for _, entry := range rates {
+			region := entry[:2]
+			rate, _ := table.rate(region)
+			for amount := money.Amount(-2500); amount <= 2500; amount += 7 {
+				b, err := table.Compute(region, amount)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if b.Net+b.Tax != b.Gross {
+					t.Fatalf("%s %s inclusive=%v: net %s + tax %s != gross %s", region, amount, inclusive, b.Net, b.Tax, b.Gross)
+				}
+				// |tax*100% - net*rate| <= (100% + rate)/2 covers the rounding of
+				// either the tax or the net
+				diff := int64(b.Tax)*int64(percent) - int64(b.Net)*int64(rate)
+				if diff < 0 {
+					diff = -diff
+				}
+				if 2*diff > int64(percent+rate) {
+					t.Fatalf("%s %s inclusive=%v: tax %s off for net %s", region, amount, inclusive, b.Tax, b.Net)
+				}
+			}
+		}
+	}
+}
+
+func TestComputeUnknownRegion(t *testing.T) {
+	_, err := newTable(t, true, "FR=20").Compute("DE", 1000)
+	if !errors.Is(err, ErrUnknownRegion) {
+		t.Errorf("Compute(DE) = %v, want ErrUnknownRegion", err)
+	}
+}
+
+func TestNewRateTableInvalid(t *testing.T) {
+	tests := []config.Tax{
+		{Rates: []string{"FR"}},
+		{Rates: []string{"=20"}},
+		{Rates: []string{"FR=120"}},
+		{Rates: []string{"FR=20", "fr=19.6"}},
+		{Rates: []string{"FR=20"}, DefaultRegion: "DE"},
+	}
+	for _, cfg := range tests {
+		if _, err := NewRateTable(cfg); err == nil {
+			t.Errorf("NewRateTable(%v) succeeded", cfg.Rates)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/n-nourdine/play-with-containers/billing-app/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Init installs the global tracer provider and the W3C trace context
+// propagator. The exporter is chosen by cfg.Exporter:
+//
+//   - otlp: OTLP over HTTP, configured with the standard OTEL_EXPORTER_OTLP_* variables
+//   - stdout: pretty-printed spans on standard output
+//   - file: spans appended as JSON lines to cfg.File
+//   - none: spans are created for propagation but never exported
+//
+// When no exporter is set, otlp is used if an OTLP endpoint is configured and
+// none otherwise. The returned function flushes and stops the provider.
+func Init(ctx context.Context, service string, cfg config.Tracing) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	exporter, closer, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	if exporter != nil {
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			closer.Close()
+		}
+		return err
+	}, nil
+}
+
+func newExporter(ctx context.Context, cfg config.Tracing) (sdktrace.SpanExporter, io.Closer, error) {
+	kind := cfg.Exporter
+	if kind == "" {
+		kind = "none"
+		if cfg.OTLPEndpoint != "" {
+			kind = "otlp"
+		}
+	}
+
+	switch kind {
+	case "otlp":
+		exp, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+		}
+		return exp, nil, nil
+	case "stdout":
+		exp, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create stdout trace exporter: %w", err)
+		}
+		return exp, nil, nil
+	case "file":
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("failed to create file trace exporter: %w", err)
+		}
+		return exp, f, nil
+	case "none":
+		return nil, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q", kind)
+	}
+}
+
+// Tracer returns the tracer used for spans created by this service
+func Tracer() trace.Tracer {
+	return otel.Tracer("github.com/n-nourdine/play-with-containers/billing-app")
+}
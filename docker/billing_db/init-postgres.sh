@@ -53,19 +53,160 @@ EOSQL
 CREATE DATABASE "$DB_NAME";
 GRANT ALL PRIVILEGES ON DATABASE "$DB_NAME" TO "$DB_USER";
 EOSQL
+    fi
 
-    # Now connect to the new database to create tables
-    echo "Creating tables in $DB_NAME..."
-    psql_movies_cmd="psql -v ON_ERROR_STOP=1 --username $DB_USER --dbname $DB_NAME"
-    $psql_movies_cmd <<EOSQL
-CREATE TABLE orders (
+    # Apply the schema on every start. Statements are idempotent so that
+    # existing volumes are upgraded in place.
+    echo "Applying schema to $DB_NAME..."
+    psql_billing_cmd="psql -v ON_ERROR_STOP=1 --username $DB_USER --dbname $DB_NAME"
+    $psql_billing_cmd <<EOSQL
+CREATE TABLE IF NOT EXISTS orders (
     id TEXT PRIMARY KEY,
     user_id VARCHAR(255) NOT NULL,
     number_of_items VARCHAR(255),
-    total_amount VARCHAR(255)
+    total_amount NUMERIC(12, 2) NOT NULL
+);
+
+-- Amounts used to be free text: convert them to NUMERIC, keeping any value
+-- that is not a valid amount in total_amount_legacy (its total becomes 0)
+DO \$\$
+BEGIN
+    IF (SELECT data_type FROM information_schema.columns
+        WHERE table_name = 'orders' AND column_name = 'total_amount') <> 'numeric' THEN
+        ALTER TABLE orders ADD COLUMN IF NOT EXISTS total_amount_legacy VARCHAR(255);
+        UPDATE orders SET total_amount_legacy = total_amount
+            WHERE total_amount !~ '^\s*[0-9]+(\.[0-9]{1,2})?\s*\$';
+        ALTER TABLE orders ALTER COLUMN total_amount TYPE NUMERIC(12, 2)
+            USING CASE WHEN total_amount ~ '^\s*[0-9]+(\.[0-9]{1,2})?\s*\$'
+                THEN trim(total_amount)::numeric ELSE 0 END;
+        ALTER TABLE orders ALTER COLUMN total_amount SET NOT NULL;
+    END IF;
+END
+\$\$;
+
+-- Order queries: lookup by user and date range, keyset pagination
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+CREATE INDEX IF NOT EXISTS orders_created_at_id_idx ON orders (created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS orders_user_id_created_at_id_idx ON orders (user_id, created_at DESC, id DESC);
+
+-- Order lifecycle: current status and history of every transition
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'pending';
+DO \$\$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'orders_status_check') THEN
+        ALTER TABLE orders ADD CONSTRAINT orders_status_check
+            CHECK (status IN ('pending', 'paid', 'fulfilled', 'cancelled', 'refunded'));
+    END IF;
+END
+\$\$;
+
+CREATE TABLE IF NOT EXISTS order_events (
+    id BIGSERIAL PRIMARY KEY,
+    order_id TEXT NOT NULL REFERENCES orders (id),
+    from_status VARCHAR(16),
+    to_status VARCHAR(16) NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS order_events_order_id_idx ON order_events (order_id, id);
+CREATE INDEX IF NOT EXISTS order_events_to_status_created_at_idx ON order_events (to_status, created_at);
+
+-- Order lines: what was bought, referencing inventory movies by ID
+CREATE TABLE IF NOT EXISTS order_items (
+    order_id TEXT NOT NULL REFERENCES orders (id),
+    line INTEGER NOT NULL,
+    movie_id TEXT NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    unit_price NUMERIC(12, 2) NOT NULL CHECK (unit_price > 0),
+    PRIMARY KEY (order_id, line)
+);
+CREATE INDEX IF NOT EXISTS order_items_movie_id_idx ON order_items (movie_id);
+
+-- Pricing: order currency, rental or purchase lines, and orders accepted
+-- although the submitted prices differed from the catalog
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'EUR';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS price_mismatch BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS submitted_amount NUMERIC(12, 2);
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS kind VARCHAR(16) NOT NULL DEFAULT 'purchase'
+    CHECK (kind IN ('rental', 'purchase'));
+CREATE INDEX IF NOT EXISTS orders_price_mismatch_idx ON orders (created_at) WHERE price_mismatch;
+
+-- Taxes: net, tax and gross amounts of each order, with the region and rate
+-- applied. Orders taken before taxes were computed are untaxed.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS net_amount NUMERIC(12, 2);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_amount NUMERIC(12, 2);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS gross_amount NUMERIC(12, 2);
+UPDATE orders SET net_amount = total_amount, tax_amount = 0, gross_amount = total_amount
+    WHERE gross_amount IS NULL;
+ALTER TABLE orders ALTER COLUMN net_amount SET NOT NULL;
+ALTER TABLE orders ALTER COLUMN tax_amount SET NOT NULL;
+ALTER TABLE orders ALTER COLUMN gross_amount SET NOT NULL;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_region VARCHAR(16) NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_rate NUMERIC(5, 2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_inclusive BOOLEAN NOT NULL DEFAULT false;
+
+-- Invoices: numbered from a single counter row so that numbers have no gaps,
+-- and stored as immutable snapshots
+CREATE TABLE IF NOT EXISTS invoice_counter (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    last_value BIGINT NOT NULL
+);
+INSERT INTO invoice_counter (id, last_value) VALUES (1, 0) ON CONFLICT (id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS invoices (
+    number TEXT PRIMARY KEY,
+    order_id TEXT NOT NULL UNIQUE REFERENCES orders (id),
+    issued_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    document JSONB NOT NULL
+);
+
+CREATE OR REPLACE FUNCTION invoices_immutable() RETURNS trigger AS \$\$
+BEGIN
+    RAISE EXCEPTION 'invoice % is immutable', OLD.number;
+END
+\$\$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS invoices_immutable ON invoices;
+CREATE TRIGGER invoices_immutable BEFORE UPDATE OR DELETE ON invoices
+    FOR EACH ROW EXECUTE FUNCTION invoices_immutable();
+
+-- Payments: at most one in progress per order; failed, voided and refunded
+-- payments are kept. Provider notifications are recorded so that each one is
+-- applied once.
+CREATE TABLE IF NOT EXISTS payments (
+    id TEXT PRIMARY KEY,
+    order_id TEXT NOT NULL REFERENCES orders (id),
+    provider VARCHAR(32) NOT NULL,
+    status VARCHAR(16) NOT NULL
+        CHECK (status IN ('pending', 'authorized', 'captured', 'refunded', 'voided', 'failed')),
+    amount NUMERIC(12, 2) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+DO \$\$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint
+                   WHERE conname = 'payments_status_check'
+                     AND pg_get_constraintdef(oid) LIKE '%voided%') THEN
+        ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;
+        ALTER TABLE payments ADD CONSTRAINT payments_status_check
+            CHECK (status IN ('pending', 'authorized', 'captured', 'refunded', 'voided', 'failed'));
+    END IF;
+END
+\$\$;
+CREATE INDEX IF NOT EXISTS payments_order_id_idx ON payments (order_id, created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS payments_in_progress_idx ON payments (order_id)
+    WHERE status IN ('pending', 'authorized', 'captured');
+
+CREATE TABLE IF NOT EXISTS payment_events (
+    id TEXT PRIMARY KEY,
+    payment_id TEXT NOT NULL REFERENCES payments (id),
+    status VARCHAR(16) NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT now()
 );
 EOSQL
-    fi
 
     unset PGPASSWORD
     su-exec postgres pg_ctl stop -D "$PGDATA" -m fast
@@ -53,18 +53,42 @@ EOSQL
 CREATE DATABASE "$DB_NAME";
 GRANT ALL PRIVILEGES ON DATABASE "$DB_NAME" TO "$DB_USER";
 EOSQL
+    fi
 
-    # Now connect to the new database to create tables
-    echo "Creating tables in $DB_NAME..."
+    # Apply the schema on every start. Statements are idempotent so that
+    # existing volumes are upgraded in place.
+    echo "Applying schema to $DB_NAME..."
     psql_movies_cmd="psql -v ON_ERROR_STOP=1 --username $DB_USER --dbname $DB_NAME"
     $psql_movies_cmd <<EOSQL
-CREATE TABLE movies (
+CREATE TABLE IF NOT EXISTS movies (
     id TEXT PRIMARY KEY,
     title VARCHAR(255) NOT NULL,
     description VARCHAR(255)
 );
+
+-- Pricing: at most one rental and one purchase offer per movie
+CREATE TABLE IF NOT EXISTS movie_offers (
+    movie_id TEXT NOT NULL REFERENCES movies (id) ON DELETE CASCADE,
+    kind VARCHAR(16) NOT NULL CHECK (kind IN ('rental', 'purchase')),
+    price NUMERIC(12, 2) NOT NULL CHECK (price > 0),
+    currency CHAR(3) NOT NULL,
+    rental_days INTEGER CHECK (rental_days > 0),
+    PRIMARY KEY (movie_id, kind),
+    CHECK ((kind = 'rental') = (rental_days IS NOT NULL))
+);
+
+-- Time-bounded percentage discounts; a NULL kind applies to both offers
+CREATE TABLE IF NOT EXISTS promotions (
+    id BIGSERIAL PRIMARY KEY,
+    movie_id TEXT NOT NULL REFERENCES movies (id) ON DELETE CASCADE,
+    kind VARCHAR(16) CHECK (kind IN ('rental', 'purchase')),
+    percent_off INTEGER NOT NULL CHECK (percent_off BETWEEN 1 AND 100),
+    starts_at TIMESTAMPTZ NOT NULL,
+    ends_at TIMESTAMPTZ NOT NULL,
+    CHECK (starts_at < ends_at)
+);
+CREATE INDEX IF NOT EXISTS promotions_movie_id_ends_at_idx ON promotions (movie_id, ends_at);
 EOSQL
-    fi
 
     unset PGPASSWORD
     su-exec postgres pg_ctl stop -D "$PGDATA" -m fast
@@ -5,12 +5,11 @@ set -e
 RABBITMQ_USER=${RABBITMQ_USER:-nasdev}
 RABBITMQ_PASSWORD=${RABBITMQ_PASSWORD:-passer}
 RABBITMQ_VHOST=${RABBITMQ_VHOST:-/}
-RABBITMQ_QUEUE=${RABBITMQ_QUEUE:-billing_queue}
+RABBITMQ_BILLING_EXCHANGE=${RABBITMQ_BILLING_EXCHANGE:-billing_messages}
 
 echo "🐰 Démarrage de RabbitMQ..."
 echo "Utilisateur: ${RABBITMQ_USER}"
 echo "VHost: ${RABBITMQ_VHOST}"
-echo "Queue: ${RABBITMQ_QUEUE}"
 
 # Démarrer RabbitMQ en arrière-plan
 rabbitmq-server &
@@ -38,15 +37,17 @@ rabbitmqctl add_user ${RABBITMQ_USER} ${RABBITMQ_PASSWORD} || true
 rabbitmqctl set_user_tags ${RABBITMQ_USER} administrator || true
 rabbitmqctl set_permissions -p ${RABBITMQ_VHOST} ${RABBITMQ_USER} ".*" ".*" ".*" || true
 
-# Déclarer une queue
-echo "📋 Création de la queue ${RABBITMQ_QUEUE:-billing_queue}..."
-rabbitmqadmin declare queue name=billing_queue durable=true
+# La gateway publie sur un exchange topic. Les queues ne sont pas créées
+# ici : chaque consommateur déclare la sienne avec ses arguments et ses clés
+# de routage (RABBITMQ_QUEUE_NAME, RABBITMQ_BINDING_KEYS pour billing-app)
+echo "🔀 Création de l'exchange ${RABBITMQ_BILLING_EXCHANGE}..."
+rabbitmqadmin declare exchange name=${RABBITMQ_BILLING_EXCHANGE} type=topic durable=true
 
 echo ""
 echo "🎉 RabbitMQ configuré avec succès!"
 echo "🌐 Interface: http://localhost:15672"
 echo "👤 Utilisateur: ${RABBITMQ_USER} / ${RABBITMQ_PASSWORD}"
-echo "📋 Queue: ${RABBITMQ_QUEUE:-billing_queue}"
+echo "🔀 Exchange: ${RABBITMQ_BILLING_EXCHANGE}"
 
 # Garder le conteneur actif
 wait $PID
\ No newline at end of file
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/n-nourdine/play-with-containers/shared/envconfig"
+)
+
+// Config holds the settings of the inventory service
+type Config struct {
+	Port     string `env:"INVENTORY_APP_PORT" flag:"port" default:"8080"`
+	LogLevel string `env:"LOG_LEVEL" flag:"log-level" default:"info"`
+
+	// GRPCPort serves the gRPC API alongside the REST one
+	GRPCPort string `env:"INVENTORY_GRPC_PORT" flag:"grpc-port" default:"9090"`
+
+	Database Database
+	RabbitMQ RabbitMQ
+	Tracing  Tracing
+}
+
+// Database holds the PostgreSQL connection settings
+type Database struct {
+	Host     string `env:"INVENTORY_DB_HOST" flag:"db-host" required:"true"`
+	Port     string `env:"INVENTORY_DB_PORT" flag:"db-port" default:"5432"`
+	User     string `env:"INVENTORY_DB_USER" flag:"db-user" required:"true"`
+	Password string `env:"INVENTORY_DB_PASSWORD" required:"true" secret:"true"`
+	Name     string `env:"INVENTORY_DB_NAME" flag:"db-name" required:"true"`
+}
+
+// RabbitMQ holds the broker the catalog changes are announced to. Without a
+// host, changes are not announced.
+type RabbitMQ struct {
+	Host     string `env:"RABBITMQ_HOST" flag:"rabbitmq-host"`
+	Port     string `env:"RABBITMQ_PORT" flag:"rabbitmq-port" default:"5672"`
+	User     string `env:"RABBITMQ_USER" flag:"rabbitmq-user"`
+	Password string `env:"RABBITMQ_PASSWORD" secret:"true"`
+	VHost    string `env:"RABBITMQ_VHOST" flag:"rabbitmq-vhost" default:"/"`
+
+	// EventsExchange is the topic exchange catalog changes are published to
+	EventsExchange string `env:"RABBITMQ_INVENTORY_EXCHANGE" flag:"rabbitmq-inventory-exchange" default:"inventory_events"`
+}
+
+// Tracing selects the span exporter; the OTLP exporter itself also reads the
+// standard OTEL_EXPORTER_OTLP_* variables
+type Tracing struct {
+	Exporter     string `env:"OTEL_TRACES_EXPORTER" flag:"traces-exporter"`
+	File         string `env:"OTEL_TRACES_FILE" default:"traces.json"`
+	OTLPEndpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+}
+
+// Load reads the configuration from defaults, the optional file given by
+// -config or CONFIG_FILE, the environment and the command-line flags, and
+// validates it
+func Load() (Config, error) {
+	var cfg Config
+	if err := envconfig.Load(&cfg, os.Args[1:]); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// Redacted returns the configuration keyed by variable name with secrets masked
+func (c Config) Redacted() map[string]string {
+	return envconfig.Redact(c)
+}
+
+// String formats the configuration with secrets masked
+func (c Config) String() string {
+	return envconfig.Dump(c)
+}
+
+// Enabled reports whether catalog changes are announced
+func (r RabbitMQ) Enabled() bool {
+	return r.Host != ""
+}
+
+// URL returns the AMQP connection URL
+func (r RabbitMQ) URL() string {
+	return fmt.Sprintf("amqp://%s@%s:%s/%s",
+		url.UserPassword(r.User, r.Password).String(),
+		r.Host, r.Port,
+		url.PathEscape(trimSlash(r.VHost)))
+}
+
+// trimSlash maps the default vhost "/" to the empty path segment expected by
+// the AMQP URI scheme
+func trimSlash(vhost string) string {
+	if vhost == "/" {
+		return ""
+	}
+	return vhost
+}
+
+// DSN returns the PostgreSQL connection string
+func (d Database) DSN() string {
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(d.User, d.Password),
+		Host:   d.Host + ":" + d.Port,
+		Path:   "/" + d.Name,
+	}
+	return u.String()
+}
@@ -3,11 +3,12 @@ package database
 import (
 	"context"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/n-nourdine/play-with-containers/inventory-app/config"
+	"github.com/n-nourdine/play-with-containers/inventory-app/tracing"
 )
 
 type MovieStream struct {
@@ -20,27 +21,25 @@ type Movies struct {
 	Description string `json:"description"`
 }
 
-func NewConn() (*MovieStream, error) {
+func NewConn(cfg config.Database) (*MovieStream, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	dbpool, err := pgxpool.New(ctx,
-		fmt.Sprintf("postgres://%s:%s@%s:%s/%s",
-			os.Getenv("INVENTORY_DB_USER"),
-			os.Getenv("INVENTORY_DB_PASSWORD"),
-			os.Getenv("INVENTORY_DB_HOST"),
-			os.Getenv("INVENTORY_DB_PORT"),
-			os.Getenv("INVENTORY_DB_NAME"),
-		))
+	poolConfig, err := pgxpool.ParseConfig(cfg.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("configuration de la base de données invalide: %w", err)
+	}
+	poolConfig.ConnConfig.Tracer = tracing.QueryTracer{}
 
+	dbpool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
 		return nil, fmt.Errorf("erreur de connexion à la base de données: %w", err)
 	}
 
 	err = dbpool.Ping(ctx)
 	if err != nil {
-		fmt.Printf("user: %v; db: %v, password: %v \n", os.Getenv("INVENTORY_DB_USER"), os.Getenv("INVENTORY_DB_NAME"), os.Getenv("INVENTORY_DB_PASSWORD"))
-		return nil, fmt.Errorf("impossible de ping la base de données: %w", err)
+		dbpool.Close()
+		return nil, fmt.Errorf("impossible de ping la base de données %s sur %s:%s: %w", cfg.Name, cfg.Host, cfg.Port, err)
 	}
 
 	return &MovieStream{db: dbpool}, nil
@@ -50,6 +49,16 @@ func (m *MovieStream) Close() {
 	m.db.Close()
 }
 
+// Ping checks that the database is reachable
+func (m *MovieStream) Ping(ctx context.Context) error {
+	return m.db.Ping(ctx)
+}
+
+// Stat returns a snapshot of the connection pool statistics
+func (m *MovieStream) Stat() *pgxpool.Stat {
+	return m.db.Stat()
+}
+
 func (m *MovieStream) Add(ctx context.Context, movie Movies) error {
 	tx, err := m.db.Begin(ctx)
 	if err != nil {
@@ -74,6 +83,22 @@ func (m *MovieStream) GetById(ctx context.Context, id string) (Movies, error) {
 	return movie, err
 }
 
+// MaxIDs bounds the number of movies fetched at once by ListeByIDs
+const MaxIDs = 100
+
+// ListeByIDs returns the movies among ids; unknown IDs are left out
+func (m *MovieStream) ListeByIDs(ctx context.Context, ids []string) ([]Movies, error) {
+	rows, err := m.db.Query(ctx, "SELECT id, title, description FROM movies WHERE id = ANY($1)", ids)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la récupération des films: %w", err)
+	}
+	movies, err := pgx.CollectRows(rows, pgx.RowToStructByPos[Movies])
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors du scan : %w", err)
+	}
+	return movies, nil
+}
+
 func (m *MovieStream) ListeByTitle(ctx context.Context, title string) ([]Movies, error) {
 	rows, err := m.db.Query(ctx, "SELECT id,title, description FROM movies WHERE title=$1", title)
 	if err != nil {
@@ -0,0 +1,208 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/n-nourdine/play-with-containers/inventory-app/money"
+)
+
+// OfferKind tells whether an offer rents or sells a movie
+type OfferKind string
+
+const (
+	KindRental   OfferKind = "rental"
+	KindPurchase OfferKind = "purchase"
+)
+
+// Valid reports whether k is a known kind of offer
+func (k OfferKind) Valid() bool {
+	return k == KindRental || k == KindPurchase
+}
+
+// Offer is the catalog price of a movie for one kind of offer. RentalDays is
+// only set for rentals.
+type Offer struct {
+	MovieID    string       `json:"movie_id"`
+	Kind       OfferKind    `json:"kind"`
+	Price      money.Amount `json:"price"`
+	Currency   string       `json:"currency"`
+	RentalDays *int         `json:"rental_days,omitempty"`
+}
+
+// Promotion is a percentage discount on a movie between StartsAt (inclusive)
+// and EndsAt (exclusive). An empty Kind applies to every offer of the movie.
+type Promotion struct {
+	ID         int64     `json:"id"`
+	MovieID    string    `json:"movie_id"`
+	Kind       OfferKind `json:"kind,omitempty"`
+	PercentOff int       `json:"percent_off"`
+	StartsAt   time.Time `json:"starts_at"`
+	EndsAt     time.Time `json:"ends_at"`
+}
+
+// PricedOffer is an offer with the price to pay at a given time, after the
+// best applicable promotion
+type PricedOffer struct {
+	Offer
+	FinalPrice money.Amount `json:"final_price"`
+	Promotion  *Promotion   `json:"promotion,omitempty"`
+}
+
+// foreignKeyViolation is the PostgreSQL error code raised when a row
+// references a movie that does not exist
+const foreignKeyViolation = "23503"
+
+// Offers returns the offers of a movie priced at time at. When several
+// promotions apply, the largest discount wins. It returns pgx.ErrNoRows if
+// the movie does not exist.
+func (m *MovieStream) Offers(ctx context.Context, movieID string, at time.Time) ([]PricedOffer, error) {
+	rows, err := m.db.Query(ctx, `
+		SELECT movie_id, kind, price, currency, rental_days
+		FROM movie_offers WHERE movie_id = $1
+		ORDER BY kind`, movieID)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la récupération des offres: %w", err)
+	}
+	offers, err := pgx.CollectRows(rows, pgx.RowToStructByPos[Offer])
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors du scan de l'offre: %w", err)
+	}
+
+	if len(offers) == 0 {
+		// Distinguish an unknown movie from one that is not for sale
+		if _, err := m.GetById(ctx, movieID); err != nil {
+			return nil, err
+		}
+		return []PricedOffer{}, nil
+	}
+
+	rows, err = m.db.Query(ctx, `
+		SELECT id, movie_id, COALESCE(kind, ''), percent_off, starts_at, ends_at
+		FROM promotions
+		WHERE movie_id = $1 AND starts_at <= $2 AND ends_at > $2`, movieID, at)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la récupération des promotions: %w", err)
+	}
+	promos, err := pgx.CollectRows(rows, pgx.RowToStructByPos[Promotion])
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors du scan des promotions: %w", err)
+	}
+
+	priced := make([]PricedOffer, len(offers))
+	for i, o := range offers {
+		priced[i] = priceOffer(o, promos, at)
+	}
+	return priced, nil
+}
+
+// priceOffer applies to an offer the largest of the promotions in force at
+// time at that cover its kind. Between equal discounts the oldest promotion
+// wins.
+func priceOffer(o Offer, promos []Promotion, at time.Time) PricedOffer {
+	priced := PricedOffer{Offer: o, FinalPrice: o.Price}
+	for _, p := range promos {
+		if p.MovieID != o.MovieID || (p.Kind != "" && p.Kind != o.Kind) ||
+			at.Before(p.StartsAt) || !at.Before(p.EndsAt) {
+			continue
+		}
+		best := priced.Promotion
+		if best == nil || p.PercentOff > best.PercentOff || (p.PercentOff == best.PercentOff && p.ID < best.ID) {
+			priced.Promotion = &p
+		}
+	}
+	if priced.Promotion != nil {
+		priced.FinalPrice = o.Price.PercentOff(priced.Promotion.PercentOff)
+	}
+	return priced
+}
+
+// PutOffer creates or replaces the offer of a movie for offer.Kind. It
+// returns pgx.ErrNoRows if the movie does not exist.
+func (m *MovieStream) PutOffer(ctx context.Context, offer Offer) error {
+	_, err := m.db.Exec(ctx, `
+		INSERT INTO movie_offers (movie_id, kind, price, currency, rental_days)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (movie_id, kind) DO UPDATE
+		SET price = EXCLUDED.price, currency = EXCLUDED.currency, rental_days = EXCLUDED.rental_days`,
+		offer.MovieID, offer.Kind, offer.Price, offer.Currency, offer.RentalDays)
+	if isForeignKeyViolation(err) {
+		return pgx.ErrNoRows
+	}
+	if err != nil {
+		return fmt.Errorf("erreur lors de l'enregistrement de l'offre: %w", err)
+	}
+	return nil
+}
+
+// DeleteOffer withdraws an offer; it returns pgx.ErrNoRows if there was none
+func (m *MovieStream) DeleteOffer(ctx context.Context, movieID string, kind OfferKind) error {
+	commandTag, err := m.db.Exec(ctx, "DELETE FROM movie_offers WHERE movie_id=$1 AND kind=$2", movieID, kind)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// AddPromotion stores a promotion and fills in its ID. It returns
+// pgx.ErrNoRows if the movie does not exist.
+func (m *MovieStream) AddPromotion(ctx context.Context, promo *Promotion) error {
+	var kind *OfferKind
+	if promo.Kind != "" {
+		kind = &promo.Kind
+	}
+	err := m.db.QueryRow(ctx, `
+		INSERT INTO promotions (movie_id, kind, percent_off, starts_at, ends_at)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		promo.MovieID, kind, promo.PercentOff, promo.StartsAt, promo.EndsAt,
+	).Scan(&promo.ID)
+	if isForeignKeyViolation(err) {
+		return pgx.ErrNoRows
+	}
+	if err != nil {
+		return fmt.Errorf("erreur lors de l'enregistrement de la promotion: %w", err)
+	}
+	return nil
+}
+
+// Promotions returns the promotions of a movie that have not ended yet,
+// soonest first
+func (m *MovieStream) Promotions(ctx context.Context, movieID string) ([]Promotion, error) {
+	rows, err := m.db.Query(ctx, `
+		SELECT id, movie_id, COALESCE(kind, ''), percent_off, starts_at, ends_at
+		FROM promotions WHERE movie_id = $1 AND ends_at > now()
+		ORDER BY starts_at, id`, movieID)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la récupération des promotions: %w", err)
+	}
+	promos, err := pgx.CollectRows(rows, pgx.RowToStructByPos[Promotion])
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors du scan des promotions: %w", err)
+	}
+	return promos, nil
+}
+
+// DeletePromotion removes a promotion; it returns pgx.ErrNoRows if there was
+// none
+func (m *MovieStream) DeletePromotion(ctx context.Context, id int64) error {
+	commandTag, err := m.db.Exec(ctx, "DELETE FROM promotions WHERE id=$1", id)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/inventory-app/money"
+)
+
+func TestPriceOffer(t *testing.T) {
+	at := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	promo := func(id int64, kind OfferKind, pct int, starts, ends time.Time) Promotion {
+		return Promotion{ID: id, MovieID: "m1", Kind: kind, PercentOff: pct, StartsAt: starts, EndsAt: ends}
+	}
+	rental := Offer{MovieID: "m1", Kind: KindRental, Price: 399, Currency: "EUR"}
+
+	tests := []struct {
+		name   string
+		offer  Offer
+		promos []Promotion
+		final  money.Amount
+		promo  int64 // ID of the promotion applied, 0 for none
+	}{
+		{"no promotion", rental, nil, 399, 0},
+		{"promotion for every kind", rental, []Promotion{promo(1, "", 20, at.Add(-day), at.Add(day))}, 319, 1},       // 319.2
+		{"promotion for the kind", rental, []Promotion{promo(1, KindRental, 25, at.Add(-day), at.Add(day))}, 299, 1}, // 299.25
+		{"promotion for another kind", rental, []Promotion{promo(1, KindPurchase, 50, at.Add(-day), at.Add(day))}, 399, 0},
+		{"largest discount wins", rental, []Promotion{
+			promo(1, "", 10, at.Add(-day), at.Add(day)),
+			promo(2, KindRental, 30, at.Add(-day), at.Add(day)),
+			promo(3, "", 20, at.Add(-day), at.Add(day)),
+		}, 279, 2}, // 279.3
+		{"oldest of equal discounts", rental, []Promotion{
+			promo(5, "", 10, at.Add(-day), at.Add(day)),
+			promo(4, KindRental, 10, at.Add(-day), at.Add(day)),
+		}, 359, 4}, // 359.1
+		{"starts now", rental, []Promotion{promo(1, "", 50, at, at.Add(day))}, 200, 1}, // 199.5 rounded half up
+		{"ended now", rental, []Promotion{promo(1, "", 50, at.Add(-day), at)}, 399, 0},
+		{"not started", rental, []Promotion{promo(1, "", 50, at.Add(time.Second), at.Add(day))}, 399, 0},
+		{"expired larger discount ignored", rental, []Promotion{
+			promo(1, "", 90, at.Add(-2*day), at.Add(-day)),
+			promo(2, "", 10, at.Add(-day), at.Add(day)),
+		}, 359, 2},
+		{"free", rental, []Promotion{promo(1, "", 100, at.Add(-day), at.Add(day))}, 0, 1},
+		{"other movie", rental, []Promotion{{ID: 1, MovieID: "m2", PercentOff: 50, StartsAt: at.Add(-day), EndsAt: at.Add(day)}}, 399, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := priceOffer(tt.offer, tt.promos, at)
+			if got.FinalPrice != tt.final {
+				t.Errorf("final price %s, want %s", got.FinalPrice, tt.final)
+			}
+			if got.Price != tt.offer.Price {
+				t.Errorf("price changed to %s", got.Price)
+			}
+			var id int64
+			if got.Promotion != nil {
+				id = got.Promotion.ID
+			}
+			if id != tt.promo {
+				t.Errorf("promotion %d applied, want %d", id, tt.promo)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/n-nourdine/play-with-containers/inventory-app/database"
+	inventoryv1 "github.com/n-nourdine/play-with-containers/inventory-app/proto/inventory/v1"
+	"github.com/n-nourdine/play-with-containers/inventory-app/rabbitmq"
+	"github.com/n-nourdine/play-with-containers/inventory-app/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// InventoryServer serves the catalog over gRPC. It shares the database and
+// the change events of the REST handlers.
+type InventoryServer struct {
+	inventoryv1.UnimplementedInventoryServiceServer
+	h *Handler
+}
+
+func NewInventoryServer(h *Handler) *InventoryServer {
+	return &InventoryServer{h: h}
+}
+
+func toProto(m database.Movies) *inventoryv1.Movie {
+	return &inventoryv1.Movie{Id: m.ID, Title: m.Title, Description: m.Description}
+}
+
+// dbError converts a database error to a gRPC status
+func dbError(ctx context.Context, err error, msg string) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return status.Error(codes.DeadlineExceeded, "Délai d'attente dépassé")
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return status.Error(codes.NotFound, "Aucun Film trouvé")
+	}
+	return status.Error(codes.Internal, msg)
+}
+
+func (s *InventoryServer) GetMovie(ctx context.Context, req *inventoryv1.GetMovieRequest) (*inventoryv1.GetMovieResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "ID invalide")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	movie, err := s.h.C.GetById(ctx, req.GetId())
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			s.h.L.ErrorContext(ctx, "error getting movie", "movie_id", req.GetId(), "error", err)
+		}
+		return nil, dbError(ctx, err, "Erreur lors de la récupération du film")
+	}
+	return &inventoryv1.GetMovieResponse{Movie: toProto(movie)}, nil
+}
+
+func (s *InventoryServer) ListMovies(req *inventoryv1.ListMoviesRequest, stream inventoryv1.InventoryService_ListMoviesServer) error {
+	if len(req.GetIds()) > database.MaxIDs {
+		return status.Errorf(codes.InvalidArgument, "Trop d'identifiants: %d au maximum", database.MaxIDs)
+	}
+
+	ctx, cancel := context.WithTimeout(stream.Context(), 3*time.Second)
+	defer cancel()
+
+	var (
+		movies []database.Movies
+		err    error
+	)
+	switch {
+	case len(req.GetIds()) > 0:
+		movies, err = s.h.C.ListeByIDs(ctx, req.GetIds())
+	case req.GetTitle() != "":
+		movies, err = s.h.C.ListeByTitle(ctx, req.GetTitle())
+	default:
+		movies, err = s.h.C.Liste(ctx)
+	}
+	if err != nil {
+		s.h.L.ErrorContext(ctx, "error listing movies", "error", err)
+		return dbError(ctx, err, "Erreur lors de la récupération des films")
+	}
+
+	for _, m := range movies {
+		if err := stream.Send(&inventoryv1.ListMoviesResponse{Movie: toProto(m)}); err != nil {
+			return err
+		}
+	}
+	s.h.L.DebugContext(ctx, "movies streamed", "count", len(movies))
+	return nil
+}
+
+func (s *InventoryServer) CreateMovie(ctx context.Context, req *inventoryv1.CreateMovieRequest) (*inventoryv1.CreateMovieResponse, error) {
+	if req.GetTitle() == "" {
+		return nil, status.Error(codes.InvalidArgument, "require title")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	movie := database.Movies{ID: util.NewUUID(), Title: req.GetTitle(), Description: req.GetDescription()}
+	if err := s.h.C.Add(ctx, movie); err != nil {
+		s.h.L.ErrorContext(ctx, "error adding movie", "error", err)
+		return nil, dbError(ctx, err, "Erreur lors de la création du film")
+	}
+
+	s.h.L.InfoContext(ctx, "movie added", "movie_id", movie.ID)
+	s.h.notify(ctx, rabbitmq.Event{Entity: rabbitmq.EntityMovie, Action: rabbitmq.ActionCreated, MovieID: movie.ID, Data: movie})
+	return &inventoryv1.CreateMovieResponse{Movie: toProto(movie)}, nil
+}
+
+func (s *InventoryServer) UpdateMovie(ctx context.Context, req *inventoryv1.UpdateMovieRequest) (*inventoryv1.UpdateMovieResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "ID invalide")
+	}
+	if req.GetTitle() == "" || req.GetDescription() == "" {
+		return nil, status.Error(codes.InvalidArgument, "invalide fields")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	movie := database.Movies{ID: req.GetId(), Title: req.GetTitle(), Description: req.GetDescription()}
+	if err := s.h.C.Update(ctx, movie); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			s.h.L.ErrorContext(ctx, "error updating movie", "movie_id", movie.ID, "error", err)
+		}
+		return nil, dbError(ctx, err, "impossible de faire la mise à jour")
+	}
+
+	s.h.L.InfoContext(ctx, "movie updated", "movie_id", movie.ID)
+	s.h.notify(ctx, rabbitmq.Event{Entity: rabbitmq.EntityMovie, Action: rabbitmq.ActionUpdated, MovieID: movie.ID, Data: movie})
+	return &inventoryv1.UpdateMovieResponse{Movie: toProto(movie)}, nil
+}
+
+func (s *InventoryServer) DeleteMovie(ctx context.Context, req *inventoryv1.DeleteMovieRequest) (*inventoryv1.DeleteMovieResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id manquant")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := s.h.C.Delete(ctx, req.GetId()); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			s.h.L.ErrorContext(ctx, "error deleting movie", "movie_id", req.GetId(), "error", err)
+		}
+		return nil, dbError(ctx, err, "Erreur lors de la suppression du film")
+	}
+
+	s.h.L.InfoContext(ctx, "movie deleted", "movie_id", req.GetId())
+	s.h.notify(ctx, rabbitmq.Event{Entity: rabbitmq.EntityMovie, Action: rabbitmq.ActionDeleted, MovieID: req.GetId()})
+	return &inventoryv1.DeleteMovieResponse{}, nil
+}
@@ -2,51 +2,93 @@ package handlers
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/n-nourdine/play-with-containers/inventory-app/config"
 	"github.com/n-nourdine/play-with-containers/inventory-app/database"
+	"github.com/n-nourdine/play-with-containers/inventory-app/rabbitmq"
 	"github.com/n-nourdine/play-with-containers/inventory-app/util"
 )
 
 type Handler struct {
-	L *log.Logger
+	L *slog.Logger
 	C *database.MovieStream
+	// Events announces the catalog changes; nil when disabled
+	Events *rabbitmq.Publisher
 }
 
-func NewHandler(l *log.Logger) (*Handler, error) {
-	c, err := database.NewConn()
+func NewHandler(l *slog.Logger, cfg config.Database, events *rabbitmq.Publisher) (*Handler, error) {
+	c, err := database.NewConn(cfg)
 	if err != nil {
 		return nil, err
 	}
-	return &Handler{L: l, C: c}, nil
+	return &Handler{L: l, C: c, Events: events}, nil
+}
+
+// notify announces a change once it is committed. The change is made
+// whatever happens next: a lost event is only logged.
+func (h *Handler) notify(ctx context.Context, event rabbitmq.Event) {
+	if h.Events == nil {
+		return
+	}
+	if err := h.Events.Publish(ctx, event); err != nil {
+		h.L.WarnContext(ctx, "error publishing catalog event", "routing_key", event.RoutingKey(), "movie_id", event.MovieID, "error", err)
+	}
 }
 
 func (h *Handler) GetMovies(rw http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
 	defer cancel()
 
+	// Several movies at once, e.g. those of a batch of orders
+	if v := r.URL.Query().Get("ids"); v != "" {
+		ids := strings.Split(v, ",")
+		if len(ids) > database.MaxIDs {
+			http.Error(rw, fmt.Sprintf("Trop d'identifiants: %d au maximum", database.MaxIDs), http.StatusBadRequest)
+			return
+		}
+		movies, err := h.C.ListeByIDs(ctx, ids)
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				h.L.WarnContext(r.Context(), "timeout listing movies by id", "error", err)
+				http.Error(rw, "Délai d'attente dépassé lors de la récupération des films", http.StatusGatewayTimeout)
+				return
+			}
+			h.L.ErrorContext(r.Context(), "error listing movies by id", "error", err)
+			http.Error(rw, "Erreur lors de la récupération des films", http.StatusInternalServerError)
+			return
+		}
+
+		if err = util.Tojson(movies, rw); err != nil {
+			h.L.ErrorContext(r.Context(), "error encoding movies", "error", err)
+		}
+		h.L.DebugContext(r.Context(), "movies found", "ids", len(ids), "count", len(movies))
+		return
+	}
+
 	if title := r.URL.Query().Get("title"); title != "" {
 		movies, err := h.C.ListeByTitle(ctx, title)
 		if err != nil {
 			if ctx.Err() == context.DeadlineExceeded {
+				h.L.WarnContext(r.Context(), "timeout listing movies by title", "error", err)
 				http.Error(rw, "Délai d'attente dépassé lors de la récupération des films", http.StatusGatewayTimeout)
-				h.L.Panic(err)
 				return
 			}
-			h.L.Panic(err)
+			h.L.ErrorContext(r.Context(), "error listing movies by title", "error", err)
 			http.Error(rw, "Aucun Film trouvé", http.StatusNotFound)
 			return
 		}
 
 		if err = util.Tojson(movies, rw); err != nil {
-			h.L.Println(err)
+			h.L.ErrorContext(r.Context(), "error encoding movies", "error", err)
 			http.Error(rw, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		h.L.Printf("Film trouvés: %v\n", movies)
+		h.L.DebugContext(r.Context(), "movies found", "title", title, "count", len(movies))
 		return
 	}
 
@@ -54,21 +96,21 @@ func (h *Handler) GetMovies(rw http.ResponseWriter, r *http.Request) {
 		movies, err := h.C.Liste(ctx)
 		if err != nil {
 			if ctx.Err() == context.DeadlineExceeded {
-				h.L.Println("Délai d'attente dépassé")
+				h.L.WarnContext(r.Context(), "timeout listing movies", "error", err)
 				http.Error(rw, "Délai d'attente dépassé lors de la récupération des films", http.StatusGatewayTimeout)
 				return
 			}
-			h.L.Println(err)
+			h.L.ErrorContext(r.Context(), "error listing movies", "error", err)
 			http.Error(rw, "Aucun Film trouvé", http.StatusNotFound)
 			return
 		}
 
 		if err = util.Tojson(movies, rw); err != nil {
 			http.Error(rw, err.Error(), http.StatusInternalServerError)
-			h.L.Println(err)
+			h.L.ErrorContext(r.Context(), "error encoding movies", "error", err)
 			return
 		}
-		h.L.Printf("Film trouvés: %v\n", movies)
+		h.L.DebugContext(r.Context(), "movies found", "count", len(movies))
 		return
 	}
 }
@@ -79,7 +121,7 @@ func (h *Handler) GetMovie(rw http.ResponseWriter, r *http.Request) {
 
 	id := r.PathValue("id")
 	if id == "" {
-		h.L.Panic("ID invalide")
+		h.L.WarnContext(r.Context(), "invalid movie id")
 		http.Error(rw, "ID invalide", http.StatusBadRequest)
 		return
 	}
@@ -88,21 +130,21 @@ func (h *Handler) GetMovie(rw http.ResponseWriter, r *http.Request) {
 
 	if err != nil {
 		if ctx.Err() == context.DeadlineExceeded {
-			h.L.Println("Délai d'attente dépassé")
+			h.L.WarnContext(r.Context(), "timeout getting movie", "movie_id", id, "error", err)
 			http.Error(rw, "Délai d'attente dépassé lors de la récupération du film", http.StatusGatewayTimeout)
 			return
 		}
-		h.L.Println(err)
+		h.L.InfoContext(r.Context(), "movie not found", "movie_id", id, "error", err)
 		http.Error(rw, "Aucun Film trouvé", http.StatusNotFound)
 		return
 	}
 
 	if err = util.Tojson(movies, rw); err != nil {
-		h.L.Println(err)
+		h.L.ErrorContext(r.Context(), "error encoding movie", "error", err)
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	h.L.Printf("Film trouvé: %v\n", movies)
+	h.L.DebugContext(r.Context(), "movie found", "movie_id", id)
 
 }
 
@@ -113,20 +155,20 @@ func (h *Handler) AddMovie(rw http.ResponseWriter, r *http.Request) {
 	movie := database.Movies{}
 
 	if err := util.FromJson(&movie, r.Body); err != nil {
-		h.L.Println(err)
+		h.L.WarnContext(r.Context(), "invalid movie payload", "error", err)
 		http.Error(rw, "invalide movie", http.StatusBadRequest)
 		return
 	}
 
 	if movie.Title == "" {
-		h.L.Println("require title")
+		h.L.WarnContext(r.Context(), "movie title is required")
 		http.Error(rw, "require title", http.StatusBadRequest)
 		return
 	}
 
 	movie.ID = util.NewUUID()
 	if err := h.C.Add(ctx, movie); err != nil {
-		h.L.Println(err)
+		h.L.ErrorContext(r.Context(), "error adding movie", "error", err)
 		if ctx.Err() == context.DeadlineExceeded {
 			http.Error(rw, "Délai d'attente dépassé lors de la création du film", http.StatusGatewayTimeout)
 			return
@@ -136,12 +178,13 @@ func (h *Handler) AddMovie(rw http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := util.Tojson(movie, rw); err != nil {
-		h.L.Println(err)
+		h.L.ErrorContext(r.Context(), "error encoding movie", "error", err)
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	h.L.Printf("Movie added: %v\n", movie)
+	h.L.InfoContext(r.Context(), "movie added", "movie_id", movie.ID)
+	h.notify(r.Context(), rabbitmq.Event{Entity: rabbitmq.EntityMovie, Action: rabbitmq.ActionCreated, MovieID: movie.ID, Data: movie})
 
 }
 
@@ -151,20 +194,20 @@ func (h *Handler) UpdateMovie(rw http.ResponseWriter, r *http.Request) {
 
 	id := r.PathValue("id")
 	if id == "" {
-		h.L.Println("invalide ID")
+		h.L.WarnContext(r.Context(), "invalid movie id")
 		http.Error(rw, "ID invalide", http.StatusBadRequest)
 		return
 	}
 	var movie database.Movies
 	if err := util.FromJson(&movie, r.Body); err != nil {
-		h.L.Println(err)
+		h.L.WarnContext(r.Context(), "invalid movie payload", "error", err)
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	movie.ID = id
 	if movie.Title == "" || movie.Description == "" {
-		h.L.Println("Champs invalid")
+		h.L.WarnContext(r.Context(), "movie title and description are required")
 		http.Error(rw, "invalide fields", http.StatusBadRequest)
 		return
 	}
@@ -173,18 +216,19 @@ func (h *Handler) UpdateMovie(rw http.ResponseWriter, r *http.Request) {
 			http.Error(rw, "Délai d'attente dépassé lors de la mise à jour du film", http.StatusGatewayTimeout)
 			return
 		}
-		h.L.Println(err)
+		h.L.ErrorContext(r.Context(), "error updating movie", "movie_id", id, "error", err)
 		http.Error(rw, "impossible de faire la mise à jour", http.StatusInternalServerError)
 		return
 	}
 
 	if err := util.Tojson(movie, rw); err != nil {
-		h.L.Println(err)
+		h.L.ErrorContext(r.Context(), "error encoding movie", "error", err)
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	h.L.Printf("Movie updated: %v]\n", movie)
+	h.L.InfoContext(r.Context(), "movie updated", "movie_id", movie.ID)
+	h.notify(r.Context(), rabbitmq.Event{Entity: rabbitmq.EntityMovie, Action: rabbitmq.ActionUpdated, MovieID: movie.ID, Data: movie})
 }
 func (h *Handler) DeleteMovie(rw http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
@@ -192,7 +236,7 @@ func (h *Handler) DeleteMovie(rw http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
 	if id == "" {
-		h.L.Println("ID manquant")
+		h.L.WarnContext(r.Context(), "missing movie id")
 		http.Error(rw, "id manquant", http.StatusBadRequest)
 		return
 	}
@@ -205,6 +249,7 @@ func (h *Handler) DeleteMovie(rw http.ResponseWriter, r *http.Request) {
 		http.Error(rw, "Film non trouvé", http.StatusNotFound)
 		return
 	}
+	h.notify(r.Context(), rabbitmq.Event{Entity: rabbitmq.EntityMovie, Action: rabbitmq.ActionDeleted, MovieID: id})
 }
 func (h *Handler) DeleteMovies(rw http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
@@ -225,4 +270,5 @@ func (h *Handler) DeleteMovies(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 	rw.WriteHeader(http.StatusNoContent)
+	h.notify(r.Context(), rabbitmq.Event{Entity: rabbitmq.EntityMovie, Action: rabbitmq.ActionPurged})
 }
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/n-nourdine/play-with-containers/inventory-app/database"
+	"github.com/n-nourdine/play-with-containers/inventory-app/money"
+	"github.com/n-nourdine/play-with-containers/inventory-app/rabbitmq"
+	"github.com/n-nourdine/play-with-containers/inventory-app/util"
+)
+
+// currencyCode matches ISO 4217 codes such as EUR or USD
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// MovieOffers lists the offers of a movie with the price to pay at the time
+// given by the optional "at" query parameter (RFC 3339, default now)
+type MovieOffers struct {
+	MovieID string                 `json:"movie_id"`
+	At      time.Time              `json:"at"`
+	Offers  []database.PricedOffer `json:"offers"`
+}
+
+func (h *Handler) GetOffers(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	id := r.PathValue("id")
+	at := time.Now().UTC()
+	if v := r.URL.Query().Get("at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(rw, "paramètre 'at' invalide: date RFC 3339 attendue", http.StatusBadRequest)
+			return
+		}
+		at = t
+	}
+
+	offers, err := h.C.Offers(ctx, id, at)
+	if err != nil {
+		h.pricingError(ctx, rw, r, "Aucun Film trouvé", "error getting offers", err)
+		return
+	}
+
+	if err := util.Tojson(MovieOffers{MovieID: id, At: at, Offers: offers}, rw); err != nil {
+		h.L.ErrorContext(r.Context(), "error encoding offers", "error", err)
+	}
+}
+
+// PutOffer creates or replaces the rental or purchase offer of a movie
+func (h *Handler) PutOffer(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	kind := database.OfferKind(r.PathValue("kind"))
+	if !kind.Valid() {
+		http.Error(rw, "type d'offre invalide: 'rental' ou 'purchase' attendu", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Price      money.Amount `json:"price"`
+		Currency   string       `json:"currency"`
+		RentalDays *int         `json:"rental_days"`
+	}
+	if err := util.FromJson(&body, r.Body); err != nil {
+		h.L.WarnContext(r.Context(), "invalid offer payload", "error", err)
+		http.Error(rw, "offre invalide", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case body.Price <= 0:
+		http.Error(rw, "le prix doit être positif", http.StatusBadRequest)
+		return
+	case !currencyCode.MatchString(body.Currency):
+		http.Error(rw, "devise invalide: code ISO 4217 attendu (ex. EUR)", http.StatusBadRequest)
+		return
+	case kind == database.KindRental && (body.RentalDays == nil || *body.RentalDays < 1):
+		http.Error(rw, "rental_days est requis pour une location", http.StatusBadRequest)
+		return
+	case kind == database.KindPurchase && body.RentalDays != nil:
+		http.Error(rw, "rental_days n'a pas de sens pour un achat", http.StatusBadRequest)
+		return
+	}
+
+	offer := database.Offer{
+		MovieID:    r.PathValue("id"),
+		Kind:       kind,
+		Price:      body.Price,
+		Currency:   body.Currency,
+		RentalDays: body.RentalDays,
+	}
+	if err := h.C.PutOffer(ctx, offer); err != nil {
+		h.pricingError(ctx, rw, r, "Aucun Film trouvé", "error saving offer", err)
+		return
+	}
+
+	if err := util.Tojson(offer, rw); err != nil {
+		h.L.ErrorContext(r.Context(), "error encoding offer", "error", err)
+	}
+	h.L.InfoContext(r.Context(), "offer saved", "movie_id", offer.MovieID, "kind", kind, "price", offer.Price.String(), "currency", offer.Currency)
+	h.notify(r.Context(), rabbitmq.Event{Entity: rabbitmq.EntityOffer, Action: rabbitmq.ActionUpdated, MovieID: offer.MovieID, Data: offer})
+}
+
+func (h *Handler) DeleteOffer(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	id := r.PathValue("id")
+	kind := database.OfferKind(r.PathValue("kind"))
+	if err := h.C.DeleteOffer(ctx, id, kind); err != nil {
+		h.pricingError(ctx, rw, r, "Offre introuvable", "error deleting offer", err)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+	h.L.InfoContext(r.Context(), "offer deleted", "movie_id", id, "kind", kind)
+	h.notify(r.Context(), rabbitmq.Event{Entity: rabbitmq.EntityOffer, Action: rabbitmq.ActionDeleted, MovieID: id, Data: map[string]database.OfferKind{"kind": kind}})
+}
+
+// GetPromotions lists the current and upcoming promotions of a movie
+func (h *Handler) GetPromotions(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	promos, err := h.C.Promotions(ctx, r.PathValue("id"))
+	if err != nil {
+		h.pricingError(ctx, rw, r, "Aucun Film trouvé", "error getting promotions", err)
+		return
+	}
+	if err := util.Tojson(promos, rw); err != nil {
+		h.L.ErrorContext(r.Context(), "error encoding promotions", "error", err)
+	}
+}
+
+func (h *Handler) AddPromotion(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	var promo database.Promotion
+	if err := util.FromJson(&promo, r.Body); err != nil {
+		h.L.WarnContext(r.Context(), "invalid promotion payload", "error", err)
+		http.Error(rw, "promotion invalide", http.StatusBadRequest)
+		return
+	}
+	promo.ID = 0
+	promo.MovieID = r.PathValue("id")
+	switch {
+	case promo.Kind != "" && !promo.Kind.Valid():
+		http.Error(rw, "type d'offre invalide: 'rental' ou 'purchase' attendu", http.StatusBadRequest)
+		return
+	case promo.PercentOff < 1 || promo.PercentOff > 100:
+		http.Error(rw, "percent_off doit être entre 1 et 100", http.StatusBadRequest)
+		return
+	case promo.StartsAt.IsZero() || !promo.StartsAt.Before(promo.EndsAt):
+		http.Error(rw, "starts_at doit précéder ends_at", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.C.AddPromotion(ctx, &promo); err != nil {
+		h.pricingError(ctx, rw, r, "Aucun Film trouvé", "error adding promotion", err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(rw).Encode(promo); err != nil {
+		h.L.ErrorContext(r.Context(), "error encoding promotion", "error", err)
+	}
+	h.L.InfoContext(r.Context(), "promotion added", "promotion_id", promo.ID, "movie_id", promo.MovieID, "percent_off", promo.PercentOff)
+	h.notify(r.Context(), rabbitmq.Event{Entity: rabbitmq.EntityPromotion, Action: rabbitmq.ActionCreated, MovieID: promo.MovieID, Data: promo})
+}
+
+func (h *Handler) DeletePromotion(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(rw, "ID invalide", http.StatusBadRequest)
+		return
+	}
+	if err := h.C.DeletePromotion(ctx, id); err != nil {
+		h.pricingError(ctx, rw, r, "Promotion introuvable", "error deleting promotion", err)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+	h.L.InfoContext(r.Context(), "promotion deleted", "promotion_id", id)
+	h.notify(r.Context(), rabbitmq.Event{Entity: rabbitmq.EntityPromotion, Action: rabbitmq.ActionDeleted, Data: map[string]int64{"id": id}})
+}
+
+// pricingError maps a store error to the HTTP response; notFound is the
+// message sent when the store reports pgx.ErrNoRows
+func (h *Handler) pricingError(ctx context.Context, rw http.ResponseWriter, r *http.Request, notFound, msg string, err error) {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		http.Error(rw, notFound, http.StatusNotFound)
+	case ctx.Err() == context.DeadlineExceeded:
+		http.Error(rw, "Délai d'attente dépassé", http.StatusGatewayTimeout)
+	default:
+		h.L.ErrorContext(r.Context(), msg, "movie_id", r.PathValue("id"), "error", err)
+		http.Error(rw, "Erreur interne", http.StatusInternalServerError)
+	}
+}
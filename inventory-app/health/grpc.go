@@ -0,0 +1,38 @@
+package health
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Watch runs the checks every interval and reports the outcome to the gRPC
+// health service, for the whole server and each of services. Once ctx is
+// done, everything is reported as not serving.
+func (c *Checker) Watch(ctx context.Context, hs *health.Server, interval time.Duration, services ...string) {
+	set := func(status healthpb.HealthCheckResponse_ServingStatus) {
+		hs.SetServingStatus("", status)
+		for _, s := range services {
+			hs.SetServingStatus(s, status)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status := healthpb.HealthCheckResponse_SERVING
+		if c.Run(ctx).Status != StatusUp {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		set(status)
+
+		select {
+		case <-ctx.Done():
+			hs.Shutdown()
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -3,28 +3,84 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/n-nourdine/play-with-containers/inventory-app/config"
 	"github.com/n-nourdine/play-with-containers/inventory-app/handlers"
+	"github.com/n-nourdine/play-with-containers/inventory-app/health"
+	"github.com/n-nourdine/play-with-containers/inventory-app/metrics"
+	inventoryv1 "github.com/n-nourdine/play-with-containers/inventory-app/proto/inventory/v1"
+	"github.com/n-nourdine/play-with-containers/inventory-app/rabbitmq"
+	"github.com/n-nourdine/play-with-containers/inventory-app/tracing"
+	"github.com/n-nourdine/play-with-containers/shared/logging"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 func main() {
 
-	l := log.New(os.Stdout, fmt.Sprintf("inventory-app running on port %s -> ", os.Getenv("INVENTORY_APP_PORT")), log.LstdFlags)
+	cfg, err := config.Load()
+	if err != nil {
+		logging.New("inventory-app", "info").Error("failed to load configuration", "error", err)
+		os.Exit(1)
+	}
+
+	l := logging.New("inventory-app", cfg.LogLevel)
+	l.Info("configuration loaded", "config", cfg.Redacted())
+
+	shutdownTracing, err := tracing.Init(context.Background(), "inventory-app", cfg.Tracing)
+	if err != nil {
+		l.Error("failed to initialize tracing", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownTracing(ctx)
+	}()
+
+	// Catalog changes are announced when a broker is configured
+	var events *rabbitmq.Publisher
+	if cfg.RabbitMQ.Enabled() {
+		events, err = rabbitmq.NewPublisher(l, cfg.RabbitMQ)
+		if err != nil {
+			l.Error("failed to create RabbitMQ publisher", "error", err)
+			os.Exit(1)
+		}
+		defer events.Close()
+	} else {
+		l.Info("RABBITMQ_HOST not set, catalog changes are not announced")
+	}
 
-	h, err := handlers.NewHandler(l)
+	h, err := handlers.NewHandler(l, cfg.Database, events)
 	if err != nil {
-		l.Fatal(err.Error())
+		l.Error("failed to create handlers", "error", err)
+		os.Exit(1)
 	}
 	defer h.C.Close()
 
+	metrics.RegisterPool(h.C.Stat)
+
+	checker := health.NewChecker(2 * time.Second)
+	checker.Add("postgres", h.C.Ping)
+	if events != nil {
+		checker.Add("rabbitmq", events.Ping)
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /api/healthy", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("site ok")) })
+	mux.HandleFunc("GET /livez", checker.Live)   // the process is up.
+	mux.HandleFunc("GET /readyz", checker.Ready) // the database is reachable.
+	mux.Handle("GET /metrics", metrics.Handler())
 	mux.HandleFunc("GET /api/movies", h.GetMovies)           // retrieve all the movies. or retrieve all the movies with name in the title.(GET /api/movies?title=[name])
 	mux.HandleFunc("GET /api/movies/{id}", h.GetMovie)       // retrieve a single movie by id.
 	mux.HandleFunc("POST /api/movies", h.AddMovie)           // create a new product entry.
@@ -32,35 +88,83 @@ func main() {
 	mux.HandleFunc("DELETE /api/movies/{id}", h.DeleteMovie) // delete a single movie by id.
 	mux.HandleFunc("DELETE /api/movies", h.DeleteMovies)     // delete all movies in the database.
 
+	mux.HandleFunc("GET /api/movies/{id}/offers", h.GetOffers)             // rental and purchase prices, after promotions (?at=RFC3339).
+	mux.HandleFunc("PUT /api/movies/{id}/offers/{kind}", h.PutOffer)       // create or replace the rental or purchase offer.
+	mux.HandleFunc("DELETE /api/movies/{id}/offers/{kind}", h.DeleteOffer) // withdraw an offer.
+	mux.HandleFunc("GET /api/movies/{id}/promotions", h.GetPromotions)     // current and upcoming promotions.
+	mux.HandleFunc("POST /api/movies/{id}/promotions", h.AddPromotion)     // add a time-bounded discount.
+	mux.HandleFunc("DELETE /api/promotions/{id}", h.DeletePromotion)       // remove a promotion.
+
 	s := http.Server{
-		Addr:         fmt.Sprintf(":%v", os.Getenv("INVENTORY_APP_PORT")),
-		Handler:      mux,
-		ErrorLog:     l,
+		Addr:         fmt.Sprintf(":%v", cfg.Port),
+		Handler:      tracing.Middleware("inventory-app", logging.Middleware(l, metrics.Middleware(tracing.Route(mux)))),
+		ErrorLog:     slog.NewLogLogger(l.Handler(), slog.LevelError),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
 
 	go func() {
-		l.Println()
+		l.Info("starting inventory-app", "port", cfg.Port)
 		err := s.ListenAndServe()
-		if err == nil {
-			h.L.Fatalf("Error starting server: %s/n", err)
+		if err != nil && err != http.ErrServerClosed {
+			l.Error("HTTP server error", "error", err)
+			os.Exit(1)
 		}
 
 	}()
 
+	// gRPC API, with the standard health and reflection services
+	gs := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(logging.UnaryServerInterceptor(l), metrics.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(logging.StreamServerInterceptor(l), metrics.StreamServerInterceptor()),
+	)
+	inventoryv1.RegisterInventoryServiceServer(gs, handlers.NewInventoryServer(h))
+	hs := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(gs, hs)
+	reflection.Register(gs)
+
+	healthCtx, stopHealth := context.WithCancel(context.Background())
+	go checker.Watch(healthCtx, hs, 10*time.Second, inventoryv1.InventoryService_ServiceDesc.ServiceName)
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%v", cfg.GRPCPort))
+	if err != nil {
+		l.Error("gRPC listener error", "error", err)
+		os.Exit(1)
+	}
+	go func() {
+		l.Info("starting gRPC server", "port", cfg.GRPCPort)
+		if err := gs.Serve(lis); err != nil {
+			l.Error("gRPC server error", "error", err)
+			os.Exit(1)
+		}
+	}()
+
 	c := make(chan os.Signal, 1)
 
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	sig := <-c
-	h.L.Println("Got signal:", sig)
+	l.Info("received signal", "signal", sig.String())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
 	defer cancel()
+
+	// Report not serving first so that clients move to another instance
+	stopHealth()
+	stopped := make(chan struct{})
+	go func() {
+		gs.GracefulStop()
+		close(stopped)
+	}()
 	s.Shutdown(ctx)
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		gs.Stop()
+	}
 }
 
 
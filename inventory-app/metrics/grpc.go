@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	grpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_requests_total",
+		Help:      "Total number of gRPC calls handled.",
+	}, []string{"method", "code"})
+
+	grpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_request_duration_seconds",
+		Help:      "Latency of gRPC calls, until the last message of a stream.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+)
+
+func observeGRPC(method string, err error, start time.Time) {
+	code := status.Code(err).String()
+	grpcRequests.WithLabelValues(method, code).Inc()
+	grpcDuration.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+}
+
+// UnaryServerInterceptor records call counts and latencies by method and
+// status code
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		observeGRPC(info.FullMethod, err, start)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		observeGRPC(info.FullMethod, err, start)
+		return err
+	}
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "inventory_app"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Total number of HTTP requests handled.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// EventsPublished counts catalog change events sent to the events
+	// exchange, by routing key and outcome (published or failed)
+	EventsPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "amqp_events_published_total",
+		Help:      "Total number of catalog change events published.",
+	}, []string{"routing_key", "outcome"})
+)
+
+// Handler exposes the registered metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware records request counts and latencies by route and status
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rw, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(rw.status)
+		httpRequests.WithLabelValues(r.Method, route, status).Inc()
+		httpDuration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// RegisterPool exports the statistics of a pgx connection pool
+func RegisterPool(stat func() *pgxpool.Stat) {
+	prometheus.MustRegister(&poolCollector{stat: stat})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+var (
+	poolAcquired = prometheus.NewDesc(namespace+"_pgxpool_acquired_conns",
+		"Number of connections currently acquired from the pool.", nil, nil)
+	poolIdle = prometheus.NewDesc(namespace+"_pgxpool_idle_conns",
+		"Number of idle connections in the pool.", nil, nil)
+	poolTotal = prometheus.NewDesc(namespace+"_pgxpool_total_conns",
+		"Total number of connections in the pool.", nil, nil)
+	poolMax = prometheus.NewDesc(namespace+"_pgxpool_max_conns",
+		"Maximum size of the pool.", nil, nil)
+	poolAcquireCount = prometheus.NewDesc(namespace+"_pgxpool_acquire_total",
+		"Cumulative count of successful acquires from the pool.", nil, nil)
+	poolEmptyAcquire = prometheus.NewDesc(namespace+"_pgxpool_empty_acquire_total",
+		"Cumulative count of acquires that had to wait for a connection.", nil, nil)
+	poolWait = prometheus.NewDesc(namespace+"_pgxpool_acquire_wait_seconds_total",
+		"Cumulative time spent waiting to acquire a connection.", nil, nil)
+)
+
+// poolCollector reads pgxpool statistics at scrape time
+type poolCollector struct {
+	stat func() *pgxpool.Stat
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquired
+	ch <- poolIdle
+	ch <- poolTotal
+	ch <- poolMax
+	ch <- poolAcquireCount
+	ch <- poolEmptyAcquire
+	ch <- poolWait
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquired, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdle, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotal, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMax, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireCount, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquire, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolWait, prometheus.CounterValue, s.AcquireDuration().Seconds())
+}
@@ -0,0 +1,7 @@
+// Package proto holds the Protocol Buffers definitions of the gRPC API of
+// the inventory service and the Go code generated from them. Regenerate it
+// with go generate after editing a .proto file; it needs buf, protoc-gen-go
+// and protoc-gen-go-grpc on the PATH.
+package proto
+
+//go:generate buf generate
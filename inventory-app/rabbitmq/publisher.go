@@ -0,0 +1,177 @@
+// Package rabbitmq announces the changes of the catalog to RabbitMQ
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/n-nourdine/play-with-containers/inventory-app/config"
+	"github.com/n-nourdine/play-with-containers/inventory-app/metrics"
+	"github.com/n-nourdine/play-with-containers/inventory-app/tracing"
+	"github.com/n-nourdine/play-with-containers/shared/logging"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Catalog entities and the changes made to them
+const (
+	EntityMovie     = "movie"
+	EntityOffer     = "offer"
+	EntityPromotion = "promotion"
+
+	ActionCreated = "created"
+	ActionUpdated = "updated"
+	ActionDeleted = "deleted"
+	// ActionPurged means every movie was deleted
+	ActionPurged = "purged"
+)
+
+// Event announces a change of the catalog. Data is the entity after the
+// change, or what identifies it once deleted.
+type Event struct {
+	Entity     string    `json:"entity"`
+	Action     string    `json:"action"`
+	MovieID    string    `json:"movie_id,omitempty"`
+	Data       any       `json:"data,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// RoutingKey returns inventory.<entity>.<action>, e.g.
+// inventory.movie.updated
+func (e Event) RoutingKey() string {
+	return "inventory." + e.Entity + "." + e.Action
+}
+
+// Publisher sends catalog change events to a topic exchange
+type Publisher struct {
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	logger   *slog.Logger
+	exchange string
+
+	// channelClosed is set once the broker or the client closes the channel
+	channelClosed atomic.Bool
+}
+
+func NewPublisher(logger *slog.Logger, cfg config.RabbitMQ) (*Publisher, error) {
+	conn, err := dial(logger, cfg.URL())
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("impossible d'ouvrir un canal RabbitMQ: %w", err)
+	}
+
+	// Declare the exchange (idempotent operation)
+	err = channel.ExchangeDeclare(
+		cfg.EventsExchange, // name
+		"topic",            // kind
+		true,               // durable
+		false,              // auto-deleted
+		false,              // internal
+		false,              // no-wait
+		nil,                // arguments
+	)
+	if err != nil {
+		channel.Close()
+		conn.Close()
+		return nil, fmt.Errorf("impossible de déclarer l'exchange: %w", err)
+	}
+
+	p := &Publisher{
+		conn:     conn,
+		channel:  channel,
+		logger:   logger,
+		exchange: cfg.EventsExchange,
+	}
+	notify := channel.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		<-notify
+		p.channelClosed.Store(true)
+	}()
+
+	return p, nil
+}
+
+// Publish announces a change of the catalog
+func (p *Publisher) Publish(ctx context.Context, event Event) error {
+	key := event.RoutingKey()
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now().UTC()
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, p.exchange+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", p.exchange),
+			attribute.String("messaging.rabbitmq.destination.routing_key", key),
+		))
+	defer span.End()
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("impossible d'encoder l'événement: %w", err)
+	}
+
+	// Carry the trace context and request ID to the subscribers
+	headers := amqp.Table{}
+	tracing.InjectAMQP(ctx, headers)
+	if id := logging.RequestID(ctx); id != "" {
+		headers[logging.AMQPRequestIDHeader] = id
+	}
+
+	err = p.channel.Publish(
+		p.exchange, // exchange
+		key,        // routing key
+		false,      // mandatory
+		false,      // immediate
+		amqp.Publishing{
+			Headers:      headers,
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Body:         body,
+			Timestamp:    event.OccurredAt,
+		},
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "publish failed")
+		metrics.EventsPublished.WithLabelValues(key, "failed").Inc()
+		return fmt.Errorf("impossible de publier l'événement: %w", err)
+	}
+	metrics.EventsPublished.WithLabelValues(key, "published").Inc()
+
+	p.logger.DebugContext(ctx, "catalog event published", "exchange", p.exchange, "routing_key", key, "movie_id", event.MovieID)
+	return nil
+}
+
+// Ping reports whether the AMQP connection and channel are still open
+func (p *Publisher) Ping(ctx context.Context) error {
+	if p.conn == nil || p.conn.IsClosed() {
+		return errors.New("AMQP connection closed")
+	}
+	if p.channelClosed.Load() {
+		return errors.New("AMQP channel closed")
+	}
+	return nil
+}
+
+func (p *Publisher) Close() {
+	if p.channel != nil {
+		p.channel.Close()
+	}
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
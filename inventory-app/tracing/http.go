@@ -0,0 +1,32 @@
+package tracing
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware starts a server span for every request, continuing the trace
+// received in the W3C traceparent header. The span is named after the route
+// by Route.
+func Middleware(service string, next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, service)
+}
+
+// Route names the span of the request after the pattern matched by mux. It
+// must wrap the mux directly: the mux sets the pattern on the request it is
+// given, and the layers in between pass copies of the request on, so the
+// pattern never reaches Middleware.
+func Route(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r)
+
+		if r.Pattern != "" {
+			span := trace.SpanFromContext(r.Context())
+			span.SetName(r.Pattern)
+			span.SetAttributes(attribute.String("http.route", r.Pattern))
+		}
+	})
+}
@@ -0,0 +1,270 @@
+// Package envconfig fills a configuration struct from its field tags:
+//
+//	env:"NAME"       environment variable (NAME_FILE points to a file holding the value)
+//	flag:"name"      command-line flag overriding every other source
+//	default:"value"  value used when no source provides one
+//	required:"true"  the value must not be empty after loading
+//	secret:"true"    the value is masked by Redact
+//	oneof:"a,b"      a non-empty value must be one of the listed words
+//
+// Sources are applied in increasing order of precedence: defaults, the
+// optional KEY=VALUE file, environment variables, then flags. Nested structs
+// are walked recursively.
+package envconfig
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const redacted = "******"
+
+type field struct {
+	value    reflect.Value
+	env      string
+	flag     string
+	def      string
+	required bool
+	secret   bool
+	oneof    []string
+}
+
+func fields(v reflect.Value) []field {
+	var out []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fv := v.Field(i)
+		if sf.Type.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(time.Duration(0)) {
+			out = append(out, fields(fv)...)
+			continue
+		}
+		env := sf.Tag.Get("env")
+		if env == "" {
+			continue
+		}
+		out = append(out, field{
+			value:    fv,
+			env:      env,
+			flag:     sf.Tag.Get("flag"),
+			def:      sf.Tag.Get("default"),
+			required: sf.Tag.Get("required") == "true",
+			secret:   sf.Tag.Get("secret") == "true",
+			oneof:    strings.FieldsFunc(sf.Tag.Get("oneof"), func(r rune) bool { return r == ',' }),
+		})
+	}
+	return out
+}
+
+// Load populates cfg (a pointer to struct) from all sources, args being the
+// command-line arguments without the program name
+func Load(cfg any, args []string) error {
+	fs := fields(reflect.ValueOf(cfg).Elem())
+
+	set := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	configFile := set.String("config", os.Getenv("CONFIG_FILE"), "optional KEY=VALUE configuration file")
+	flagValues := make(map[string]*string, len(fs))
+	for _, f := range fs {
+		if f.flag != "" {
+			flagValues[f.flag] = set.String(f.flag, "", "overrides "+f.env)
+		}
+	}
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	var fileValues map[string]string
+	if *configFile != "" {
+		var err error
+		if fileValues, err = readFile(*configFile); err != nil {
+			return err
+		}
+	}
+
+	explicit := map[string]bool{}
+	set.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var errs []error
+	for _, f := range fs {
+		raw := f.def
+		if v, ok := fileValues[f.env]; ok {
+			raw = v
+		}
+		v, ok, err := lookupEnv(f.env)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			raw = v
+		}
+		if f.flag != "" && explicit[f.flag] {
+			raw = *flagValues[f.flag]
+		}
+
+		if f.required && strings.TrimSpace(raw) == "" {
+			errs = append(errs, fmt.Errorf("%s is required", f.env))
+			continue
+		}
+		if len(f.oneof) > 0 && raw != "" && !slices.Contains(f.oneof, raw) {
+			errs = append(errs, fmt.Errorf("%s must be one of %s, got %q", f.env, strings.Join(f.oneof, ", "), raw))
+			continue
+		}
+		if err := assign(f.value, raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.env, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// lookupEnv reads NAME, or the content of the file named by NAME_FILE as
+// used with Docker secrets
+func lookupEnv(name string) (string, bool, error) {
+	v, ok := os.LookupEnv(name)
+	path, fileOK := os.LookupEnv(name + "_FILE")
+	if ok && fileOK {
+		return "", false, fmt.Errorf("both %s and %s_FILE are set", name, name)
+	}
+	if fileOK {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", false, fmt.Errorf("%s_FILE: %w", name, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), true, nil
+	}
+	return v, ok, nil
+}
+
+// readFile parses a KEY=VALUE file, ignoring blank lines and # comments
+func readFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+	defer file.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.Trim(strings.TrimSpace(parts[1]), `"'`)
+		if key != "" {
+			values[key] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+	return values, nil
+}
+
+func assign(v reflect.Value, raw string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		if raw == "" {
+			v.SetInt(0)
+			return nil
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		if raw == "" {
+			v.SetInt(0)
+			return nil
+		}
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Bool:
+		if raw == "" {
+			v.SetBool(false)
+			return nil
+		}
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Float64:
+		if raw == "" {
+			v.SetFloat(0)
+			return nil
+		}
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
+
+// Redact returns every configuration value keyed by its variable name, with
+// secrets masked
+func Redact(cfg any) map[string]string {
+	out := map[string]string{}
+	for _, f := range fields(reflect.ValueOf(cfg)) {
+		switch {
+		case f.secret && !f.value.IsZero():
+			out[f.env] = redacted
+		case f.value.Kind() == reflect.Slice:
+			out[f.env] = strings.Join(f.value.Interface().([]string), ",")
+		default:
+			out[f.env] = fmt.Sprint(f.value.Interface())
+		}
+	}
+	return out
+}
+
+// Dump formats redacted values as sorted KEY=VALUE lines
+func Dump(cfg any) string {
+	values := Redact(cfg)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%s\n", k, values[k])
+	}
+	return b.String()
+}
@@ -0,0 +1,18 @@
+module github.com/n-nourdine/play-with-containers/shared
+
+go 1.24.2
+
+require (
+	github.com/google/uuid v1.6.0
+	go.opentelemetry.io/otel/trace v1.36.0
+	google.golang.org/grpc v1.72.1
+)
+
+require (
+	go.opentelemetry.io/otel v1.36.0 // indirect
+	golang.org/x/net v0.35.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250218202821-56aae31c358a // indirect
+	google.golang.org/protobuf v1.36.5 // indirect
+)
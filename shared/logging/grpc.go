@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcRequestID reads the request ID from the call metadata, generating one
+// when it is missing, and sends it back in the response headers
+func grpcRequestID(ctx context.Context) context.Context {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(strings.ToLower(RequestIDHeader)); len(v) > 0 {
+			id = v[0]
+		}
+	}
+	if id == "" || len(id) > 128 {
+		id = uuid.NewString()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, id))
+	return WithRequestID(ctx, id)
+}
+
+func logCall(ctx context.Context, logger *slog.Logger, method string, err error, start time.Time) {
+	logger.InfoContext(ctx, "rpc completed",
+		"method", method,
+		"code", status.Code(err).String(),
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+}
+
+// UnaryServerInterceptor propagates the x-request-id metadata like
+// Middleware does the X-Request-ID header, and logs every completed call
+func UnaryServerInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		ctx = grpcRequestID(ctx)
+		resp, err := handler(ctx, req)
+		logCall(ctx, logger, info.FullMethod, err, start)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls
+func StreamServerInterceptor(logger *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx := grpcRequestID(ss.Context())
+		err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+		logCall(ctx, logger, info.FullMethod, err, start)
+		return err
+	}
+}
+
+// contextStream overrides the context of a server stream
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}